@@ -0,0 +1,125 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// aliasCache periodically resolves human readable names of the targets'
+// accounts, either their IAM account aliases or their names in AWS
+// Organizations, and exports them as info metrics. Scrapes only read the
+// cache, so resolving names adds neither latency nor API calls to them.
+type aliasCache struct {
+	targets []*target
+	org     *organizations.Organizations
+	desc    *prometheus.Desc
+
+	mutex   sync.RWMutex
+	aliases map[string]string
+}
+
+// newAliasCache returns a cache of the targets' account aliases. With the
+// organizations source, names are listed with the given session, which must
+// belong to the management account of the organization.
+func newAliasCache(sess *session.Session, source string, targets []*target, constLabels prometheus.Labels) *aliasCache {
+	c := &aliasCache{
+		targets: targets,
+		desc: newInfoDesc("account_alias",
+			"Human readable alias of the account given by the account_id label.", []string{"account_id", "alias"}, constLabels),
+		aliases: map[string]string{},
+	}
+	if source == "organizations" {
+		c.org = organizations.New(sess)
+	}
+	return c
+}
+
+// run refreshes the cache immediately and then at the given interval, until
+// the context is done.
+func (c *aliasCache) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh resolves the aliases of all targets. Aliases that can't be
+// resolved keep their previous value.
+func (c *aliasCache) refresh(ctx context.Context) {
+	aliases := map[string]string{}
+	if c.org != nil {
+		err := c.org.ListAccountsPagesWithContext(ctx, &organizations.ListAccountsInput{}, func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+			for _, a := range page.Accounts {
+				aliases[aws.StringValue(a.Id)] = aws.StringValue(a.Name)
+			}
+			return true
+		})
+		if err != nil {
+			log.Errorf("Can't list AWS Organizations accounts: %v", err)
+		}
+	} else {
+		for _, t := range c.targets {
+			accountID, err := t.AccountID(ctx)
+			if err != nil {
+				log.Errorf("Can't get AWS account ID: %v", err)
+				continue
+			}
+			resp, err := t.iam.ListAccountAliasesWithContext(ctx, &iam.ListAccountAliasesInput{})
+			if err != nil {
+				log.Errorf("Can't get IAM account alias of account %s: %v", accountID, err)
+				continue
+			}
+			if len(resp.AccountAliases) > 0 {
+				aliases[accountID] = aws.StringValue(resp.AccountAliases[0])
+			}
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for accountID, alias := range aliases {
+		c.aliases[accountID] = alias
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *aliasCache) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect exports the cached aliases. It implements prometheus.Collector.
+func (c *aliasCache) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for accountID, alias := range c.aliases {
+		ch <- newInfoMetric(c.desc, accountID, alias)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAliasCache(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "ListAccountAliases" {
+			http.Error(w, "unexpected action", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`<ListAccountAliasesResponse><ListAccountAliasesResult>
+			<AccountAliases><member>acme-prod</member></AccountAliases><IsTruncated>false</IsTruncated>
+		</ListAccountAliasesResult></ListAccountAliasesResponse>`))
+	}))
+	defer s.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+	tg := newTarget(sess, awsConfig{partition: "aws"})
+	tg.accountID = "123456789012"
+	c := newAliasCache(sess, "iam", []*target{tg}, nil)
+	c.refresh(context.Background())
+
+	expected := `
+# HELP aws_billing_account_alias_info Human readable alias of the account given by the account_id label.
+# TYPE aws_billing_account_alias_info gauge
+aws_billing_account_alias_info{account_id="123456789012",alias="acme-prod"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		c.run(ctx, time.Hour)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return after the context was canceled")
+	}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Errorf("failed refresh dropped aliases: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/common/log"
+)
+
+// dimensionValues is the response of the dimensions API.
+type dimensionValues struct {
+	Key       string           `json:"key"`
+	AccountID string           `json:"account_id"`
+	Start     string           `json:"start"`
+	End       string           `json:"end"`
+	Values    []dimensionValue `json:"values"`
+}
+
+type dimensionValue struct {
+	Value      string            `json:"value"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// dimensionsHandler serves the values of the cost and usage dimension given
+// by the key parameter, e.g. SERVICE, in the last 30 days or between the
+// start and end parameters, so that valid filter and group values can be
+// discovered. The account parameter selects the target, the first one by
+// default. Calls count against the call budget and are only made by the
+// leader of an HA deployment.
+func (e *Exporter) dimensionsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		key := q.Get("key")
+		if !validDimension(key) {
+			http.Error(w, fmt.Sprintf("invalid dimension %q", key), http.StatusBadRequest)
+			return
+		}
+		end := today()
+		start := end.AddDate(0, 0, -30)
+		for _, p := range []struct {
+			name string
+			t    *time.Time
+		}{{"start", &start}, {"end", &end}} {
+			if v := q.Get(p.name); v != "" {
+				t, err := time.ParseInLocation(dateFormat, v, time.Local)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("invalid %s date %q", p.name, v), http.StatusBadRequest)
+					return
+				}
+				*p.t = t
+			}
+		}
+
+		t, accountID, err := e.findTarget(r, q.Get("account"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		resp := dimensionValues{Key: key, AccountID: accountID, Start: start.Format(dateFormat), End: end.Format(dateFormat)}
+		input := &costexplorer.GetDimensionValuesInput{
+			Dimension: aws.String(key),
+			TimePeriod: &costexplorer.DateInterval{
+				Start: aws.String(resp.Start),
+				End:   aws.String(resp.End),
+			},
+		}
+		for {
+			if !e.mayCall(1) {
+				http.Error(w, "Cost Explorer calls are not allowed on a standby replica or with the call budget exhausted", http.StatusServiceUnavailable)
+				return
+			}
+			out, err := t.client.GetDimensionValuesWithContext(r.Context(), input)
+			if err != nil {
+				log.Errorf("Can't get values of dimension %s of account %s: %v", key, accountID, err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			for _, v := range out.DimensionValues {
+				resp.Values = append(resp.Values, dimensionValue{Value: aws.StringValue(v.Value), Attributes: aws.StringValueMap(v.Attributes)})
+			}
+			if aws.StringValue(out.NextPageToken) == "" {
+				break
+			}
+			input.NextPageToken = out.NextPageToken
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Can't write dimension values: %v", err)
+		}
+	})
+}
+
+// findTarget returns the target of the given account, or the first target if
+// accountID is empty.
+func (e *Exporter) findTarget(r *http.Request, accountID string) (*target, string, error) {
+	for _, t := range e.targets {
+		id, err := t.AccountID(r.Context())
+		if err != nil {
+			return nil, "", fmt.Errorf("can't get AWS account ID: %v", err)
+		}
+		if accountID == "" || id == accountID {
+			return t, id, nil
+		}
+	}
+	return nil, "", fmt.Errorf("unknown account %q", accountID)
+}
+
+func validDimension(key string) bool {
+	for _, d := range costexplorer.Dimension_Values() {
+		if key == d {
+			return true
+		}
+	}
+	return false
+}
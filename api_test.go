@@ -0,0 +1,81 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+func TestDimensionsHandler(t *testing.T) {
+	var requests []string
+	ce := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(b))
+		if len(requests) == 1 {
+			w.Write([]byte(`{"DimensionValues": [{"Value": "Amazon S3"}], "NextPageToken": "2"}`))
+			return
+		}
+		w.Write([]byte(`{"DimensionValues": [{"Value": "AWS Lambda"}]}`))
+	}))
+	defer ce.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ce.URL),
+	}))
+	e, err := NewExporter([]*target{{client: costexplorer.New(sess), accountID: "123456789012"}}, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	e.dimensionsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/dimensions?key=SERVICE&start=2019-07-01&end=2019-08-01", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp dimensionValues
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.AccountID != "123456789012" || resp.Start != "2019-07-01" || len(resp.Values) != 2 || resp.Values[1].Value != "AWS Lambda" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if len(requests) != 2 || !strings.Contains(requests[1], `"NextPageToken":"2"`) {
+		t.Errorf("want a second request for the next page, got %q", requests)
+	}
+
+	for _, url := range []string{"/api/v1/dimensions", "/api/v1/dimensions?key=NOPE", "/api/v1/dimensions?key=SERVICE&start=July"} {
+		w := httptest.NewRecorder()
+		e.dimensionsHandler().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: want status 400, got %d", url, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	e.dimensionsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/dimensions?key=SERVICE&account=210987654321", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("want status 404 for an unknown account, got %d", w.Code)
+	}
+}
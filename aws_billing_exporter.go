@@ -14,186 +14,1396 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"os"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/budgets"
 	"github.com/aws/aws-sdk-go/service/costexplorer"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
+	"golang.org/x/sync/singleflight"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 const (
-	namespace = "aws_billing" // For Prometheus metrics.
+	namespace       = "aws_billing" // For Prometheus metrics.
+	legacySubsystem = "server"
 )
 
-var (
-	serverLabelNames = []string{"type", "unit"}
-)
+// labelLayout is the layout of the labels of the billing metrics. Costs are
+// labeled with their currency and usage amounts with their unit, unless
+// legacyUnit puts currencies into the unit label, as before the currency
+// label was introduced.
+type labelLayout struct {
+	legacyUnit bool
+}
 
-func newAwsBillingMetric(metricName string, docString string, constLabels prometheus.Labels) *prometheus.Desc {
-	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "server", metricName), docString, serverLabelNames, constLabels)
+// names returns the labels of the billing metrics followed by extra.
+func (l labelLayout) names(extra ...string) []string {
+	names := append(append([]string{"type"}, l.unitNames()...), "account_id")
+	return append(names, extra...)
 }
 
-type metrics map[int]*prometheus.Desc
-type awsMetrics map[int]string
+// values returns the values of the labels given by names for an amount of
+// the billing metric awsName in the given unit. Of the unit and currency
+// labels, only the one that applies is set.
+func (l labelLayout) values(awsName, unit, accountID string, extra ...string) []string {
+	values := append(append([]string{awsName}, l.unitValues(unit)...), accountID)
+	return append(values, extra...)
+}
 
-func (m metrics) String() string {
+// unitNames returns the labels of the unit of an amount: unit and currency,
+// or only unit with legacyUnit.
+func (l labelLayout) unitNames() []string {
+	if l.legacyUnit {
+		return []string{"unit"}
+	}
+	return []string{"unit", "currency"}
+}
+
+// unitValues returns the values of the labels given by unitNames for an
+// amount in the given unit.
+func (l labelLayout) unitValues(unit string) []string {
+	switch {
+	case l.legacyUnit:
+		return []string{unit}
+	case isCurrency(unit):
+		return []string{"", unit}
+	default:
+		return []string{unit, ""}
+	}
+}
+
+// currency returns the label of the currency of the costs exported by the
+// collectors.
+func (l labelLayout) currency() string {
+	if l.legacyUnit {
+		return "unit"
+	}
+	return "currency"
+}
+
+func newAwsBillingMetric(subsystem, metricName string, docString string, layout labelLayout, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, metricName), docString, layout.names(), constLabels)
+}
+
+// newInfoDesc returns the descriptor of an info metric named
+// aws_billing_<subsystem>_info. Info metrics carry their information in
+// labels and are always 1, see newInfoMetric.
+func newInfoDesc(subsystem, help string, labelNames []string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "info"), help, labelNames, constLabels)
+}
+
+// newInfoMetric returns the sample of an info metric with the given label
+// values.
+func newInfoMetric(desc *prometheus.Desc, labelValues ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, labelValues...)
+}
+
+// newSubsystemDescs returns a descriptor for the named metric under each of
+// the given subsystems.
+func newSubsystemDescs(subsystems []string, metricName, docString string, labelNames []string, constLabels prometheus.Labels) []*prometheus.Desc {
+	descs := make([]*prometheus.Desc, 0, len(subsystems))
+	for _, subsystem := range subsystems {
+		descs = append(descs, prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, metricName), docString, labelNames, constLabels))
+	}
+	return descs
+}
+
+// metricSubsystems returns the subsystems billing metrics are exported under.
+// With legacy set, metrics are additionally exported under the historical
+// "server" subsystem to ease migrating dashboards and alerts.
+func metricSubsystems(subsystem string, legacy bool) []string {
+	if legacy && subsystem != legacySubsystem {
+		return []string{subsystem, legacySubsystem}
+	}
+	return []string{subsystem}
+}
+
+// metricInfo holds the AWS name of a billing metric along with the name and
+// help text it is exported with.
+type metricInfo struct {
+	awsName string
+	name    string
+	help    string
+}
+
+type metrics map[int]metricInfo
+
+// keys returns the field numbers of the metrics in ascending order.
+func (m metrics) keys() []int {
 	keys := make([]int, 0, len(m))
 	for k := range m {
 		keys = append(keys, k)
 	}
 	sort.Ints(keys)
+	return keys
+}
+
+// String returns the comma separated AWS names of the metrics.
+func (m metrics) String() string {
+	keys := m.keys()
 	s := make([]string, len(keys))
 	for i, k := range keys {
-		s[i] = strconv.Itoa(k)
+		s[i] = m[k].awsName
 	}
 	return strings.Join(s, ",")
 }
 
-/**
-AWSMetrics are original metrics defined by AWS
-**/
-var (
-	prometheusMetrics = metrics{
-		1: newAwsBillingMetric("amortized_cost", "This cost metric reflects the effective cost of the upfront and monthly reservation fees spread across the billing period..", nil),
-		2: newAwsBillingMetric("blended_cost", "This cost metric reflects the average cost of usage across the consolidated billing family.", nil),
-		3: newAwsBillingMetric("net_amortized_cost", "This cost metric amortizes the upfront and monthly reservation fees while including discounts such as RI volume discounts.", nil),
-		4: newAwsBillingMetric("net_unblended_cost", "This cost metric reflects the cost after discounts.", nil),
-		5: newAwsBillingMetric("normalized_usage_amount", "Cost of amount of resource consumption like CPU.", nil),
-		6: newAwsBillingMetric("unblended_cost", "Unblended costs separate discounts into their own line items. This enables you to view the amount of each discount received.", nil),
-		7: newAwsBillingMetric("usage_quantity", "Usage of quantity like data in GB.", nil),
-	}
-	awsBillingUp = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "up"), "Was the last scrape of aws billing successful.", nil, nil)
-	AWSMetrics   = awsMetrics{
-		1: "AmortizedCost",
-		2: "BlendedCost",
-		3: "NetAmortizedCost",
-		4: "NetUnblendedCost",
-		5: "NormalizedUsageAmount",
-		6: "UnblendedCost",
-		7: "UsageQuantity",
+// lookup returns the field number of the metric with the given AWS name or
+// field number.
+func (m metrics) lookup(s string) (int, bool) {
+	if field, err := strconv.Atoi(s); err == nil {
+		_, ok := m[field]
+		return field, ok
 	}
-)
+	for field, metric := range m {
+		if strings.EqualFold(metric.awsName, s) {
+			return field, true
+		}
+	}
+	return 0, false
+}
+
+// usage lists the valid values of a metric filter.
+func (m metrics) usage() string {
+	keys := m.keys()
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = fmt.Sprintf("%s (%d)", m[k].awsName, k)
+	}
+	return strings.Join(s, ", ")
+}
+
+// prometheusMetrics are the metrics defined by the AWS cost and usage API,
+// keyed by the field numbers historically used to select them.
+var prometheusMetrics = metrics{
+	1: {"AmortizedCost", "amortized_cost", "This cost metric reflects the effective cost of the upfront and monthly reservation fees spread across the billing period.."},
+	2: {"BlendedCost", "blended_cost", "This cost metric reflects the average cost of usage across the consolidated billing family."},
+	3: {"NetAmortizedCost", "net_amortized_cost", "This cost metric amortizes the upfront and monthly reservation fees while including discounts such as RI volume discounts."},
+	4: {"NetUnblendedCost", "net_unblended_cost", "This cost metric reflects the cost after discounts."},
+	5: {"NormalizedUsageAmount", "normalized_usage_amount", "Cost of amount of resource consumption like CPU."},
+	6: {"UnblendedCost", "unblended_cost", "Unblended costs separate discounts into their own line items. This enables you to view the amount of each discount received."},
+	7: {"UsageQuantity", "usage_quantity", "Usage of quantity like data in GB."},
+}
 
 // Exporter collects AWS Billing stats and exports them using
 // the prometheus metrics package.
 type Exporter struct {
-	mutex sync.RWMutex
-	fetch func() (*costexplorer.GetCostAndUsageOutput, error)
+	mutex   sync.RWMutex
+	flight  singleflight.Group
+	targets []*target
+	fetch   func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error)
+	// dataExports is set when billing metrics are read from an AWS Data
+	// Exports export instead of Cost Explorer.
+	dataExports bool
+	// selected are the AWS names of the selected billing metrics.
+	selected []string
+	// fetchServices, if not nil, queries the selected billing metrics by
+	// service for the web UI and the digest.
+	fetchServices func(context.Context, *target) ([]*costexplorer.ResultByTime, error)
 
-	up                prometheus.Gauge
-	totalScrapes      prometheus.Counter
-	prometheusMetrics map[int]*prometheus.Desc
+	concurrency   int
+	targetTimeout time.Duration
+
+	elector    elector
+	leaderDesc *prometheus.Desc
+
+	cache     []prometheus.Metric
+	cacheTime time.Time
+	snapshot  *snapshot
+
+	lastError     string
+	lastErrorTime time.Time
+	cacheTTL      time.Duration
+	cacheJitter   time.Duration
+	schedule      *cronSchedule
+	jitter        time.Duration
+	dataAgeDesc   *prometheus.Desc
+
+	callBudget   *callBudget
+	callsSkipped prometheus.Counter
+
+	// seriesLimiter, if not nil, caps the number of series per breakdown
+	// metric family.
+	seriesLimiter *seriesLimiter
+
+	sinks []metricSink
+	// pushes queues the refresh waiting to be pushed to the sinks.
+	pushes chan pushRequest
+
+	up           prometheus.Gauge
+	totalScrapes prometheus.Counter
+	// collectPanics counts the panics recovered from while scraping.
+	collectPanics      prometheus.Counter
+	prometheusMetrics  map[int][]*prometheus.Desc
+	upDesc             *prometheus.Desc
+	estimatedDescs     []*prometheus.Desc
+	changeDescs        []*prometheus.Desc
+	changePercentDescs []*prometheus.Desc
+	periodCostDescs    []*prometheus.Desc
+	periodChangeDescs  []*prometheus.Desc
+	comparePeriods     bool
+	runRateDescs       []*prometheus.Desc
+	runRateDays        int
+	historyDescs       []*prometheus.Desc
+	historyDays        int
+	projectionDescs    []*prometheus.Desc
+	projectMonthEnd    bool
+	convertedCostDescs []*prometheus.Desc
+	converter          *converter
+	fixedRate          *fixedRate
+	normalizeUnits     bool
+	// zeroFill, if not nil, exports groups that disappeared from a
+	// breakdown as 0.
+	zeroFill *zeroFiller
+
+	budgets          *budgetsCollector
+	forecast         *forecastCollector
+	budgetRatioDesc  *prometheus.Desc
+	hourly           *hourlyCollector
+	trustedAdvisor   *trustedAdvisorCollector
+	computeOptimizer *computeOptimizerCollector
+	reservations     *reservationsCollector
+	savingsPlans     *savingsPlansCollector
+	accountInfo      *accountInfoCollector
+	costCategories   *costCategoriesCollector
+	tags             *costAllocationTagsCollector
+	billingConductor *billingConductorCollector
+	presets          []*presetCollector
+	serviceNames     serviceNames
+	tagBreakdowns    []*presetCollector
+	// jobs are replaced when the configuration file is reloaded.
+	jobs          []*jobCollector
+	jobsMutex     sync.RWMutex
+	constLabels   prometheus.Labels
+	layout        labelLayout
+	labelMappings []labelMapping
+	labelInfoDesc *prometheus.Desc
+	// normalizationFactorDesc is set if NormalizedUsageAmount is selected.
+	normalizationFactorDesc *prometheus.Desc
+
+	collectorSuccessDesc  *prometheus.Desc
+	collectorDurationDesc *prometheus.Desc
 }
 
-// NewExporter returns an initialized Exporter.
-func NewExporter(filter string, selectedServerMetrics map[int]*prometheus.Desc) (*Exporter, error) {
+// exporterOptions configures an Exporter.
+type exporterOptions struct {
+	// fixedRate, if not nil, converts amounts in its source currency in place.
+	fixedRate *fixedRate
+	// normalizeUnits converts usage amounts into canonical units.
+	normalizeUnits bool
+	// zeroFill exports groups that disappeared from a breakdown as 0, until
+	// they have been missing for zeroFillTTL if it is positive.
+	zeroFill    bool
+	zeroFillTTL time.Duration
+	// maxSeries, if positive, caps the number of series per breakdown metric
+	// family.
+	maxSeries int
+	// converter, if not nil, additionally exports cost metrics converted
+	// into its target currency.
+	converter *converter
+	// constLabels are attached to all metrics of the exporter.
+	constLabels prometheus.Labels
+	// labelLayout is the layout of the labels of the billing metrics. It
+	// must match the layout selectedServerMetrics were created with.
+	labelLayout labelLayout
+	// subsystems are the subsystems billing metrics are exported under.
+	subsystems []string
+	// comparePeriods enables week-over-week and month-over-month
+	// comparisons.
+	comparePeriods bool
+	// runRateDays, if positive, exports the average daily value of the
+	// billing metrics over that many complete days.
+	runRateDays int
+	// historyDays, if positive, exports the billing metrics of each of
+	// that many complete days as a separate series.
+	historyDays int
+	// projectMonthEnd exports a linear projection of the billing metrics
+	// to the end of the month.
+	projectMonthEnd bool
+	// budgets and forecast enable the respective collectors.
+	budgets  bool
+	forecast bool
+	// hourlyHours enables the hourly collector if positive.
+	hourlyHours int
+	// trustedAdvisor and computeOptimizer enable the respective collectors.
+	trustedAdvisor   bool
+	computeOptimizer bool
+	reservations     bool
+	// reservationRegions are the regions the reservations collector lists
+	// reservations in, the region of the session if empty.
+	reservationRegions []string
+	savingsPlans       bool
+	accountInfo        bool
+	costCategories     bool
+	tags               bool
+	billingConductor   bool
+	// dataExportARN, if set, is the AWS Data Exports export the billing
+	// metrics are read from instead of Cost Explorer.
+	dataExportARN string
+	// serviceBreakdown records the billing metrics of each service in the
+	// snapshot shown by the web UI and used by the digest. It always
+	// queries Cost Explorer.
+	serviceBreakdown bool
+	// tagKeys and categoryKeys break the billing metrics down by the tag
+	// keys and cost categories of those names.
+	tagKeys      []string
+	categoryKeys []string
+	// allowedTagKeys, if not empty, are the only tag keys exported, as
+	// labels or label values.
+	allowedTagKeys []string
+	// jobs are the query jobs of the configuration file.
+	jobs []jobConfig
+	// serviceNames, if not nil, shortens the service names exported as
+	// label values.
+	serviceNames serviceNames
+	// presets are the names of the enabled breakdown presets.
+	presets []string
+	// concurrency is the number of targets scraped in parallel, at least one.
+	concurrency int
+	// targetTimeout, if positive, bounds the time spent scraping a target.
+	targetTimeout time.Duration
+	// elector, if not nil, restricts scraping to the leader of an HA
+	// deployment.
+	elector elector
+	// callBudget, if positive, caps the number of Cost Explorer calls per
+	// day.
+	callBudget int
+	// cacheTTL, if positive, is how long scraped metrics are served before
+	// they are refreshed in the background.
+	cacheTTL time.Duration
+	// schedule, if not nil, refreshes the cached metrics in the background
+	// at the scheduled times instead, see runSchedule.
+	schedule *cronSchedule
+	// jitter, if positive, delays background refreshes by a random duration
+	// up to it, so that many exporters don't call AWS at the same time.
+	jitter time.Duration
+	// sinks receive the metrics of every refresh.
+	sinks []metricSink
+}
 
-	var fetch func() (*costexplorer.GetCostAndUsageOutput, error)
-	selected := []string{}
-	if len(filter) == 0 {
-		for _, v := range AWSMetrics {
-			selected = append(selected, v)
-		}
-	} else {
-		for _, f := range strings.Split(filter, ",") {
-			field, err := strconv.Atoi(f)
-			if err != nil {
-				return nil, fmt.Errorf("invalid server metric field number: %v", f)
-			}
-			selected = append(selected, AWSMetrics[field])
+// NewExporter returns an initialized Exporter collecting the selected billing
+// metrics from the given targets.
+func NewExporter(targets []*target, selectedServerMetrics map[int][]*prometheus.Desc, opts exporterOptions) (*Exporter, error) {
+	selected := make([]string, 0, len(selectedServerMetrics))
+	for field := range selectedServerMetrics {
+		selected = append(selected, prometheusMetrics[field].awsName)
+	}
+	sort.Strings(selected)
+
+	window := windowConfig{comparePeriods: opts.comparePeriods, runRateDays: opts.runRateDays, historyDays: opts.historyDays, monthToDate: opts.projectMonthEnd}
+	fetch := fetchHTTP(selected, window)
+	if opts.dataExportARN != "" {
+		var err error
+		if fetch, err = fetchDataExport(opts.dataExportARN, selected, window); err != nil {
+			return nil, err
 		}
 	}
+	var fetchServices func(context.Context, *target) ([]*costexplorer.ResultByTime, error)
+	if opts.serviceBreakdown {
+		fetchServices = fetchServiceBreakdown(selected, window)
+	}
+	constLabels, layout, subsystems := opts.constLabels, opts.labelLayout, opts.subsystems
 
-	fetch = fetchHTTP(selected)
+	var bc *budgetsCollector
+	if opts.budgets {
+		bc = newBudgetsCollector(layout, constLabels)
+	}
+	var fc *forecastCollector
+	if opts.forecast {
+		fc = newForecastCollector(layout, constLabels)
+	}
+	var tc *trustedAdvisorCollector
+	if opts.trustedAdvisor {
+		tc = newTrustedAdvisorCollector(constLabels)
+	}
+	var co *computeOptimizerCollector
+	if opts.computeOptimizer {
+		co = newComputeOptimizerCollector(layout, constLabels)
+	}
+	var rc *reservationsCollector
+	if opts.reservations {
+		rc = newReservationsCollector(opts.reservationRegions, constLabels)
+	}
+	var sc *savingsPlansCollector
+	if opts.savingsPlans {
+		sc = newSavingsPlansCollector(layout, constLabels)
+	}
+	var aic *accountInfoCollector
+	if opts.accountInfo {
+		aic = newAccountInfoCollector(constLabels)
+	}
+	var cc *costCategoriesCollector
+	if opts.costCategories {
+		cc = newCostCategoriesCollector(constLabels)
+	}
+	var tgc *costAllocationTagsCollector
+	if opts.tags {
+		tgc = newCostAllocationTagsCollector(constLabels)
+		tgc.allowed = newTagAllowlist(opts.allowedTagKeys)
+	}
+	var bcc *billingConductorCollector
+	if opts.billingConductor {
+		bcc = newBillingConductorCollector(layout, constLabels)
+	}
+	var pcs []*presetCollector
+	for _, name := range opts.presets {
+		pc, err := newPresetCollector(name, selected, layout, constLabels)
+		if err != nil {
+			return nil, err
+		}
+		pc.serviceNames = opts.serviceNames
+		pc.runRateDays = opts.runRateDays
+		pcs = append(pcs, pc)
+	}
+	allowed := newTagAllowlist(opts.allowedTagKeys)
+	for _, k := range opts.tagKeys {
+		if !allowed.allows(k) {
+			return nil, fmt.Errorf("tag key %q is not in the allowed tag keys", k)
+		}
+	}
+	var tbcs []*presetCollector
+	var mappings []labelMapping
+	for _, g := range []struct {
+		groupType string
+		keys      []string
+	}{{costexplorer.GroupDefinitionTypeTag, opts.tagKeys}, {costexplorer.GroupDefinitionTypeCostCategory, opts.categoryKeys}} {
+		if len(g.keys) == 0 {
+			continue
+		}
+		tbc, m, err := newTagCollector(g.groupType, g.keys, selected, layout, constLabels)
+		if err != nil {
+			return nil, err
+		}
+		tbc.runRateDays = opts.runRateDays
+		tbcs = append(tbcs, tbc)
+		mappings = append(mappings, m...)
+	}
+	jcs, err := newJobCollectors(opts.jobs, nil, selected, layout, constLabels, opts.serviceNames, opts.runRateDays)
+	if err != nil {
+		return nil, err
+	}
+	var hc *hourlyCollector
+	if opts.hourlyHours > 0 {
+		var err error
+		if hc, err = newHourlyCollector(selected, opts.hourlyHours, layout, constLabels); err != nil {
+			return nil, err
+		}
+	}
+	concurrency := opts.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var budget *callBudget
+	if opts.callBudget > 0 {
+		budget = newCallBudget(opts.callBudget)
+	}
+	var zf *zeroFiller
+	if opts.zeroFill {
+		zf = newZeroFiller(opts.zeroFillTTL)
+	}
+	var limiter *seriesLimiter
+	if opts.maxSeries > 0 {
+		limiter = newSeriesLimiter(opts.maxSeries, constLabels)
+	}
+	var nfd *prometheus.Desc
+	for _, m := range selected {
+		if m == "NormalizedUsageAmount" {
+			nfd = newNormalizationFactorDesc(constLabels)
+		}
+	}
 
-	return &Exporter{
-		fetch: fetch,
+	e := &Exporter{
+		targets:       targets,
+		fetch:         fetch,
+		fetchServices: fetchServices,
+		dataExports:   opts.dataExportARN != "",
+		selected:      selected,
+		concurrency:   concurrency,
+		targetTimeout: opts.targetTimeout,
+		callBudget:    budget,
+		cacheTTL:      opts.cacheTTL,
+		schedule:      opts.schedule,
+		jitter:        opts.jitter,
+		sinks:         opts.sinks,
+		dataAgeDesc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "data_age_seconds"),
+			"Time since the delivered billing metrics were scraped from AWS.", nil, constLabels),
+		elector: opts.elector,
+		callsSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_calls_skipped_total",
+			Help:        "Cost Explorer calls skipped because the daily call budget was exhausted.",
+			ConstLabels: constLabels,
+		}),
+		seriesLimiter: limiter,
+		leaderDesc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "leader"),
+			"Whether this replica is the elected leader calling the Cost Explorer APIs.", nil, constLabels),
 		up: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      "up",
-			Help:      "Was the last scrape of aws cost and usage API successful.",
+			Namespace:   namespace,
+			Name:        "up",
+			Help:        "Was the last scrape of aws cost and usage API successful.",
+			ConstLabels: constLabels,
 		}),
 		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "exporter_total_scrapes",
-			Help:      "Current total aws cost and usage API scrapes.",
+			Namespace:   namespace,
+			Name:        "exporter_total_scrapes",
+			Help:        "Current total aws cost and usage API scrapes.",
+			ConstLabels: constLabels,
+		}),
+		collectPanics: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_collect_panics_total",
+			Help:        "Panics recovered from while scraping accounts, running collectors, publishing metrics and running background tasks, e.g. on unexpected AWS responses.",
+			ConstLabels: constLabels,
 		}),
 		prometheusMetrics: selectedServerMetrics,
-	}, nil
+		upDesc:            prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "up"), "Was the last scrape of aws billing successful.", []string{"account_id"}, constLabels),
+		estimatedDescs: newSubsystemDescs(subsystems, "estimated",
+			"Whether the exported billing metrics are estimated and may still be revised by AWS (1) or final (0).",
+			[]string{"account_id"}, constLabels),
+		changeDescs: newSubsystemDescs(subsystems, "day_over_day_change",
+			"Change of the billing metric given by the type label versus the previous day.",
+			layout.names(), constLabels),
+		changePercentDescs: newSubsystemDescs(subsystems, "day_over_day_change_percent",
+			"Change of the billing metric given by the type label versus the previous day, in percent.",
+			layout.names(), constLabels),
+		periodCostDescs: newSubsystemDescs(subsystems, "period_cost",
+			"Total of the billing metric given by the type label over the current or previous window of a comparison period.",
+			layout.names("period", "window"), constLabels),
+		periodChangeDescs: newSubsystemDescs(subsystems, "period_over_period_change_percent",
+			"Change of the billing metric given by the type label over the current window of a comparison period versus the previous one, in percent.",
+			layout.names("period"), constLabels),
+		comparePeriods: opts.comparePeriods,
+		runRateDescs: newSubsystemDescs(subsystems, "daily_run_rate",
+			"Average daily value of the billing metric given by the type label over the last complete days given by the days label.",
+			layout.names("days"), constLabels),
+		runRateDays: opts.runRateDays,
+		historyDescs: newSubsystemDescs(subsystems, "daily_history",
+			"Billing metric given by the type label on the complete day given by the date label.",
+			layout.names("date"), constLabels),
+		historyDays: opts.historyDays,
+		projectionDescs: newSubsystemDescs(subsystems, "month_end_projection",
+			"Billing metric given by the type label projected linearly to the end of the month from its month to date total.",
+			layout.names(), constLabels),
+		projectMonthEnd: opts.projectMonthEnd,
+		convertedCostDescs: newSubsystemDescs(subsystems, "converted_cost",
+			"Cost metrics converted into the currency given by the currency label.",
+			[]string{"type", "currency", "account_id"}, constLabels),
+		converter:      opts.converter,
+		fixedRate:      opts.fixedRate,
+		normalizeUnits: opts.normalizeUnits,
+		zeroFill:       zf,
+		budgets:        bc,
+		forecast:       fc,
+		budgetRatioDesc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "forecast_to_budget_ratio"),
+			"Month-end spend projected from the actual spend and the forecast, relative to the limit of monthly cost budgets without filters.",
+			[]string{"account_id", "budget_name"}, constLabels),
+		hourly:           hc,
+		trustedAdvisor:   tc,
+		computeOptimizer: co,
+		reservations:     rc,
+		savingsPlans:     sc,
+		accountInfo:      aic,
+		costCategories:   cc,
+		tags:             tgc,
+		billingConductor: bcc,
+		presets:          pcs,
+		serviceNames:     opts.serviceNames,
+		tagBreakdowns:    tbcs,
+		jobs:             jcs,
+		constLabels:      constLabels,
+		layout:           layout,
+		labelMappings:    mappings,
+		labelInfoDesc: newInfoDesc("label",
+			"Label the tag key or cost category given by the source label is exported as in the metric given by the metric label.",
+			[]string{"metric", "label", "source"}, constLabels),
+		collectorSuccessDesc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "collector", "success"),
+			"Whether the collector given by the collector label succeeded for the account.", []string{"collector", "account_id"}, constLabels),
+		collectorDurationDesc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "collector", "duration_seconds"),
+			"Duration of the collector given by the collector label for the account.", []string{"collector", "account_id"}, constLabels),
+		normalizationFactorDesc: nfd,
+	}
+	if err := e.checkJobs(jcs); err != nil {
+		return nil, err
+	}
+	if len(e.sinks) > 0 {
+		e.pushes = make(chan pushRequest, 1)
+		go e.runPushes()
+	}
+	return e, nil
 }
 
 // Describe describes all the metrics ever exported by the HAProxy exporter. It
 // implements prometheus.Collector.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.describe(ch)
+	for _, j := range e.currentJobs() {
+		j.Describe(ch)
+	}
+}
 
-	for _, m := range e.prometheusMetrics {
+// describe describes the metrics of the exporter other than those of the
+// query jobs.
+func (e *Exporter) describe(ch chan<- *prometheus.Desc) {
+	for _, descs := range e.prometheusMetrics {
+		for _, m := range descs {
+			ch <- m
+		}
+	}
+	for _, m := range e.estimatedDescs {
 		ch <- m
 	}
-	ch <- awsBillingUp
+	for _, m := range e.changeDescs {
+		ch <- m
+	}
+	for _, m := range e.changePercentDescs {
+		ch <- m
+	}
+	if e.comparePeriods {
+		for _, m := range e.periodCostDescs {
+			ch <- m
+		}
+		for _, m := range e.periodChangeDescs {
+			ch <- m
+		}
+	}
+	if e.runRateDays > 0 {
+		for _, m := range e.runRateDescs {
+			ch <- m
+		}
+	}
+	if e.historyDays > 0 {
+		for _, m := range e.historyDescs {
+			ch <- m
+		}
+	}
+	if e.projectMonthEnd {
+		for _, m := range e.projectionDescs {
+			ch <- m
+		}
+	}
+	if e.converter != nil {
+		for _, m := range e.convertedCostDescs {
+			ch <- m
+		}
+	}
+	if e.budgets != nil {
+		e.budgets.Describe(ch)
+	}
+	if e.forecast != nil {
+		e.forecast.Describe(ch)
+	}
+	if e.budgets != nil && e.forecast != nil {
+		ch <- e.budgetRatioDesc
+	}
+	if e.hourly != nil {
+		e.hourly.Describe(ch)
+	}
+	if e.trustedAdvisor != nil {
+		e.trustedAdvisor.Describe(ch)
+	}
+	if e.computeOptimizer != nil {
+		e.computeOptimizer.Describe(ch)
+	}
+	if e.reservations != nil {
+		e.reservations.Describe(ch)
+	}
+	if e.savingsPlans != nil {
+		e.savingsPlans.Describe(ch)
+	}
+	if e.accountInfo != nil {
+		e.accountInfo.Describe(ch)
+	}
+	if e.costCategories != nil {
+		e.costCategories.Describe(ch)
+	}
+	if e.tags != nil {
+		e.tags.Describe(ch)
+	}
+	if e.billingConductor != nil {
+		e.billingConductor.Describe(ch)
+	}
+	for _, p := range e.presets {
+		p.Describe(ch)
+	}
+	for _, b := range e.tagBreakdowns {
+		b.Describe(ch)
+	}
+	if len(e.labelMappings) > 0 {
+		ch <- e.labelInfoDesc
+	}
+	if e.normalizationFactorDesc != nil {
+		ch <- e.normalizationFactorDesc
+	}
+	if e.elector != nil {
+		ch <- e.leaderDesc
+	}
+	if e.callBudget != nil {
+		ch <- e.callsSkipped.Desc()
+	}
+	if e.seriesLimiter != nil {
+		ch <- e.seriesLimiter.dropped.Desc()
+	}
+	ch <- e.collectorSuccessDesc
+	ch <- e.collectorDurationDesc
+	ch <- e.dataAgeDesc
+	ch <- e.upDesc
 	ch <- e.totalScrapes.Desc()
+	ch <- e.collectPanics.Desc()
 }
 
-func (e *Exporter) scrape(ch chan<- prometheus.Metric) (up float64) {
+// scrape collects the billing metrics of the given targets, recording them
+// in snap.
+func (e *Exporter) scrape(ch chan<- prometheus.Metric, targets []*target, snap *snapshot) {
 	e.totalScrapes.Inc()
 
-	response, err := e.fetch()
+	var rates exchangeRates
+	if e.converter != nil {
+		var err error
+		if rates, err = e.converter.source.Rates(); err != nil {
+			snap.errorf("Can't get exchange rates: %v", err)
+		}
+	}
+
+	work := make(chan *target)
+	var wg sync.WaitGroup
+	for i := 0; i < e.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range work {
+				ctx, cancel := e.targetContext()
+				var accountID string
+				up := 0.0
+				if err := e.safely(func() error {
+					accountID, up = e.scrapeTarget(ctx, ch, t, rates, snap)
+					return nil
+				}); err != nil {
+					accountID, _ = t.AccountID(ctx)
+					snap.errorf("Can't scrape AWS account %s: %v", accountID, err)
+				}
+				cancel()
+				ch <- prometheus.MustNewConstMetric(e.upDesc, prometheus.GaugeValue, up, accountID)
+			}
+		}()
+	}
+	for _, t := range targets {
+		work <- t
+	}
+	close(work)
+	wg.Wait()
+}
+
+// targetContext returns the context of a single target's scrape, bounded by
+// the target timeout if one is configured.
+func (e *Exporter) targetContext() (context.Context, context.CancelFunc) {
+	if e.targetTimeout > 0 {
+		return context.WithTimeout(context.Background(), e.targetTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// scrapeTarget collects the billing metrics of a single target, recording
+// them in snap, and returns its account ID and whether the scrape succeeded.
+// The collectors run and export their metrics even if others fail, so that a
+// failing Cost Explorer query only hides its own metrics.
+func (e *Exporter) scrapeTarget(ctx context.Context, ch chan<- prometheus.Metric, t *target, rates exchangeRates, snap *snapshot) (accountID string, up float64) {
+	accountID, err := t.AccountID(ctx)
 	if err != nil {
-		log.Errorf("Can't scrape AWS Billing data: %v", err)
-		return 0
+		snap.errorf("Can't get AWS account ID, set --aws.account-id to skip the lookup: %v", err)
+		return "", 0
 	}
 
-	for key, metric := range e.prometheusMetrics {
-		for awsCostKey, cost := range response.ResultsByTime[0].Total {
-			if awsCostKey == AWSMetrics[key] {
-				if f, err := strconv.ParseFloat(*cost.Amount, 64); err == nil {
-					ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, f, awsCostKey, *cost.Unit)
+	up = 1
+	account := &accountSnapshot{AccountID: accountID}
+	defer snap.add(account)
+	var response *costexplorer.GetCostAndUsageOutput
+	if e.observe(ch, snap, "costusage", "AWS Billing data", accountID, func() (err error) {
+		response, err = e.fetch(ctx, t)
+		return err
+	}) {
+		account.Series = dailySeries(response.ResultsByTime, e.selected, e.amount)
+		e.collectTotals(ch, response.ResultsByTime, rates, accountID)
+	} else {
+		up = 0
+	}
+	if e.fetchServices != nil && !e.observe(ch, snap, "services", "AWS service breakdown", accountID, func() error {
+		results, err := e.fetchServices(ctx, t)
+		if err != nil {
+			return err
+		}
+		account.Services = serviceSeries(results, e.selected, e.amount, e.serviceNames)
+		return nil
+	}) {
+		up = 0
+	}
+	if e.updateCollectors(ctx, ch, t, accountID, snap) == 0 {
+		up = 0
+	}
+	return accountID, up
+}
+
+// collectTotals exports the billing metrics of the last result, and their
+// changes versus the previous results.
+func (e *Exporter) collectTotals(ch chan<- prometheus.Metric, results []*costexplorer.ResultByTime, rates exchangeRates, accountID string) {
+	if len(results) == 0 {
+		return
+	}
+	current := results[len(results)-1].Total
+	estimated := 0.0
+	if aws.BoolValue(results[len(results)-1].Estimated) {
+		estimated = 1
+	}
+	for _, metric := range e.estimatedDescs {
+		ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, estimated, accountID)
+	}
+	var previous map[string]*costexplorer.MetricValue
+	if len(results) > 1 {
+		previous = results[len(results)-2].Total
+	}
+
+	for key, descs := range e.prometheusMetrics {
+		awsName := prometheusMetrics[key].awsName
+		f, unit, ok := e.amount(current[awsName])
+		if !ok {
+			continue
+		}
+		for _, metric := range descs {
+			ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, f, e.layout.values(awsName, unit, accountID)...)
+		}
+		if prev, _, ok := e.amount(previous[awsName]); ok {
+			for _, metric := range e.changeDescs {
+				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, f-prev, e.layout.values(awsName, unit, accountID)...)
+			}
+			if prev != 0 {
+				for _, metric := range e.changePercentDescs {
+					ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, (f-prev)/prev*100, e.layout.values(awsName, unit, accountID)...)
 				}
 			}
 		}
+		if e.comparePeriods {
+			e.collectComparisons(ch, results, awsName, accountID)
+		}
+		if e.runRateDays > 0 {
+			e.collectRunRate(ch, results, awsName, accountID)
+		}
+		if e.historyDays > 0 {
+			e.collectHistory(ch, results, awsName, accountID)
+		}
+		if e.projectMonthEnd {
+			e.collectProjection(ch, results, awsName, accountID)
+		}
+		if rates == nil {
+			continue
+		}
+		if converted, ok := rates.convert(f, unit, e.converter.target); ok {
+			for _, metric := range e.convertedCostDescs {
+				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, converted, awsName, e.converter.target, accountID)
+			}
+		}
+	}
+}
+
+// updateCollectors runs the optional collectors that are enabled, and derives
+// the forecast to budget ratio when both the budgets and forecast collectors
+// are. It returns whether all of them succeeded.
+func (e *Exporter) updateCollectors(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string, snap *snapshot) (up float64) {
+	up = 1
+	run := func(collector, what string, f func() error) {
+		if !e.observe(ch, snap, collector, what, accountID, f) {
+			up = 0
+		}
+	}
+	// breakdown runs a collector breaking the billing metrics down into
+	// groups, exporting the groups it lost as 0 if configured.
+	breakdown := func(collector, what string, f func(chan<- prometheus.Metric) error) {
+		run(collector, what, func() error {
+			if e.zeroFill == nil {
+				return f(ch)
+			}
+			return e.zeroFill.run(ch, collector+"/"+accountID, f)
+		})
+	}
+
+	var budgetList []*budgets.Budget
+	if e.budgets != nil {
+		run("budgets", "AWS Budgets", func() (err error) {
+			budgetList, err = e.budgets.update(ctx, ch, t, accountID)
+			return err
+		})
+	}
+	var forecast *costexplorer.MetricValue
+	if e.forecast != nil {
+		run("forecast", "AWS cost forecast", func() (err error) {
+			forecast, err = e.forecast.update(ctx, ch, t, accountID)
+			return err
+		})
+	}
+	if e.hourly != nil {
+		run("hourly", "hourly AWS Billing data", func() error { return e.hourly.update(ctx, ch, t, accountID, e.amount) })
+	}
+	if e.trustedAdvisor != nil {
+		run("trusted-advisor", "Trusted Advisor checks", func() error { return e.trustedAdvisor.update(ctx, ch, t, accountID) })
+	}
+	if e.computeOptimizer != nil {
+		run("compute-optimizer", "Compute Optimizer recommendations", func() error { return e.computeOptimizer.update(ctx, ch, t, accountID) })
+	}
+	if e.reservations != nil {
+		run("reservations", "reservations", func() error { return e.reservations.update(ctx, ch, t, accountID) })
+	}
+	if e.savingsPlans != nil {
+		run("savings-plans", "savings plans", func() error { return e.savingsPlans.update(ctx, ch, t, accountID) })
+	}
+	if e.accountInfo != nil {
+		run("account-info", "the caller identity", func() error { return e.accountInfo.update(ctx, ch, t, accountID) })
+	}
+	if e.costCategories != nil {
+		run("cost-categories", "cost categories", func() error { return e.costCategories.update(ctx, ch, t, accountID) })
+	}
+	if e.tags != nil {
+		run("cost-allocation-tags", "cost allocation tags", func() error { return e.tags.update(ctx, ch, t, accountID) })
+	}
+	if e.billingConductor != nil {
+		run("billing-conductor", "Billing Conductor billing groups", func() error { return e.billingConductor.update(ctx, ch, t, accountID) })
+	}
+	for _, p := range e.presets {
+		breakdown("preset:"+p.name, "the "+p.name+" breakdown", func(ch chan<- prometheus.Metric) error { return p.update(ctx, ch, t, accountID, e.amount) })
+	}
+	for _, b := range e.tagBreakdowns {
+		breakdown(b.name+":"+strings.Join(b.keys(), ","), "the "+strings.Join(b.keys(), ", ")+" breakdown", func(ch chan<- prometheus.Metric) error { return b.update(ctx, ch, t, accountID, e.amount) })
+	}
+	for _, j := range e.currentJobs() {
+		breakdown("job:"+j.name, "job "+j.name, func(ch chan<- prometheus.Metric) error { return j.update(ctx, ch, t, accountID, e.amount) })
 	}
 
-	return 1
+	for _, b := range budgetList {
+		if ratio, ok := forecastToBudgetRatio(b, forecast); ok {
+			ch <- prometheus.MustNewConstMetric(e.budgetRatioDesc, prometheus.GaugeValue, ratio, accountID, aws.StringValue(b.BudgetName))
+		}
+	}
+	return up
+}
+
+// observe runs a collector for an account and exports whether it succeeded
+// and how long it took, in the manner of the node exporter. Errors are
+// recorded in snap as failures to scrape what the collector exports.
+func (e *Exporter) observe(ch chan<- prometheus.Metric, snap *snapshot, collector, what, accountID string, f func() error) bool {
+	start := time.Now()
+	err := e.safely(f)
+	ch <- prometheus.MustNewConstMetric(e.collectorDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds(), collector, accountID)
+	success := 1.0
+	if err != nil {
+		snap.errorf("Can't scrape %s of account %s: %v", what, accountID, err)
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(e.collectorSuccessDesc, prometheus.GaugeValue, success, collector, accountID)
+	return err == nil
+}
+
+// safely runs f, turning a panic into an error counted in
+// aws_billing_exporter_collect_panics_total, so that an unexpected AWS
+// response fails a scrape, a push or a background run instead of crashing
+// the exporter.
+func (e *Exporter) safely(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.collectPanics.Inc()
+			log.Errorf("Recovered from panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return f()
 }
 
-// Collect fetches the stats from configured AWS account and delivers them
-// as Prometheus metrics. It implements prometheus.Collector.
+// collectComparisons exports the period-over-period comparisons of the named
+// metric. The comparisons are relative to the day following the last result.
+func (e *Exporter) collectComparisons(ch chan<- prometheus.Metric, results []*costexplorer.ResultByTime, awsName, accountID string) {
+	last := results[len(results)-1].TimePeriod
+	if last == nil {
+		return
+	}
+	end, err := time.ParseInLocation(dateFormat, aws.StringValue(last.End), time.Local)
+	if err != nil {
+		return
+	}
+
+	for _, c := range comparisons(end) {
+		current, previous, unit := periodTotals(results, c, awsName, e.amount)
+		for _, metric := range e.periodCostDescs {
+			ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, current, e.layout.values(awsName, unit, accountID, c.period, "current")...)
+			ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, previous, e.layout.values(awsName, unit, accountID, c.period, "previous")...)
+		}
+		if previous == 0 {
+			continue
+		}
+		for _, metric := range e.periodChangeDescs {
+			ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, (current-previous)/previous*100, e.layout.values(awsName, unit, accountID, c.period)...)
+		}
+	}
+}
+
+// collectRunRate exports the average daily value of the named metric over
+// the last runRateDays days of the results. Days without results count as
+// zero.
+func (e *Exporter) collectRunRate(ch chan<- prometheus.Metric, results []*costexplorer.ResultByTime, awsName, accountID string) {
+	last := results[len(results)-1].TimePeriod
+	if last == nil {
+		return
+	}
+	end, err := time.ParseInLocation(dateFormat, aws.StringValue(last.End), time.Local)
+	if err != nil {
+		return
+	}
+	window := comparison{current: dateRange{end.AddDate(0, 0, -e.runRateDays), end}}
+	total, _, unit := periodTotals(results, window, awsName, e.amount)
+	if unit == "" {
+		return
+	}
+	days := strconv.Itoa(e.runRateDays)
+	for _, metric := range e.runRateDescs {
+		ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, total/float64(e.runRateDays), e.layout.values(awsName, unit, accountID, days)...)
+	}
+}
+
+// collectHistory exports the value of the named metric on each of the last
+// historyDays days of the results, labeled with the day's date, so that a
+// single scrape gives the recent history, e.g. for tables.
+func (e *Exporter) collectHistory(ch chan<- prometheus.Metric, results []*costexplorer.ResultByTime, awsName, accountID string) {
+	last := results[len(results)-1].TimePeriod
+	if last == nil {
+		return
+	}
+	end, err := time.ParseInLocation(dateFormat, aws.StringValue(last.End), time.Local)
+	if err != nil {
+		return
+	}
+	window := dateRange{end.AddDate(0, 0, -e.historyDays), end}
+	for _, r := range results {
+		if r.TimePeriod == nil {
+			continue
+		}
+		date := aws.StringValue(r.TimePeriod.Start)
+		day, err := time.ParseInLocation(dateFormat, date, time.Local)
+		if err != nil || !window.contains(day) {
+			continue
+		}
+		f, unit, ok := e.amount(r.Total[awsName])
+		if !ok {
+			continue
+		}
+		for _, metric := range e.historyDescs {
+			ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, f, e.layout.values(awsName, unit, accountID, date)...)
+		}
+	}
+}
+
+// collectProjection exports the month to date total of the named metric
+// scaled to the whole month. The month is that of the last result, so that
+// on the first day of a month the previous month's total is exported.
+// Unlike the forecast collector, it needs no additional Cost Explorer calls.
+func (e *Exporter) collectProjection(ch chan<- prometheus.Metric, results []*costexplorer.ResultByTime, awsName, accountID string) {
+	last := results[len(results)-1].TimePeriod
+	if last == nil {
+		return
+	}
+	end, err := time.ParseInLocation(dateFormat, aws.StringValue(last.End), time.Local)
+	if err != nil {
+		return
+	}
+	lastDay := end.AddDate(0, 0, -1)
+	window := comparison{current: dateRange{monthStart(lastDay), end}}
+	total, _, unit := periodTotals(results, window, awsName, e.amount)
+	if unit == "" {
+		return
+	}
+	days := time.Date(lastDay.Year(), lastDay.Month()+1, 0, 0, 0, 0, 0, lastDay.Location()).Day()
+	for _, metric := range e.projectionDescs {
+		ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, total/float64(lastDay.Day())*float64(days), e.layout.values(awsName, unit, accountID)...)
+	}
+}
+
+// amount parses a metric value returned by the cost and usage API, applying
+// the fixed exchange rate and unit normalization if configured. ok is false
+// if the value is missing or malformed.
+func (e *Exporter) amount(v *costexplorer.MetricValue) (amount float64, unit string, ok bool) {
+	if v == nil || v.Amount == nil {
+		return 0, "", false
+	}
+	amount, err := strconv.ParseFloat(*v.Amount, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	unit = aws.StringValue(v.Unit)
+	if e.fixedRate != nil {
+		amount, unit = e.fixedRate.apply(amount, unit)
+	}
+	if e.normalizeUnits {
+		amount, unit = normalizeUnit(amount, unit)
+	}
+	return amount, unit, true
+}
+
+// Collect fetches the stats from the configured AWS accounts and delivers
+// them as Prometheus metrics. With a cache TTL, cached metrics are delivered
+// until they expire, and then once more while they are refreshed in the
+// background. With a refresh schedule, cached metrics are always delivered
+// once there are any. It implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.mutex.Lock() // To protect metrics from concurrent collects.
-	defer e.mutex.Unlock()
+	e.mutex.RLock()
+	cached, age := !e.cacheTime.IsZero(), time.Since(e.cacheTime)
+	e.mutex.RUnlock()
 
-	up := e.scrape(ch)
+	switch {
+	case !cached, e.schedule == nil && e.cacheTTL <= 0:
+		e.refresh()
+	case e.schedule == nil && age >= e.cacheTTL+e.cacheJitter:
+		go e.refresh()
+	}
 
-	ch <- prometheus.MustNewConstMetric(awsBillingUp, prometheus.GaugeValue, up)
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	for _, m := range e.cache {
+		ch <- m
+	}
+	if !e.cacheTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(e.dataAgeDesc, prometheus.GaugeValue, time.Since(e.cacheTime).Seconds())
+	}
+
+	if e.elector != nil {
+		v := 0.0
+		if e.elector.IsLeader() {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(e.leaderDesc, prometheus.GaugeValue, v)
+	}
+	if e.callBudget != nil {
+		ch <- e.callsSkipped
+	}
+	if e.seriesLimiter != nil {
+		ch <- e.seriesLimiter.dropped
+	}
+	for _, m := range e.labelMappings {
+		ch <- newInfoMetric(e.labelInfoDesc, m.metric, m.label, m.source)
+	}
+	if e.normalizationFactorDesc != nil {
+		collectNormalizationFactors(ch, e.normalizationFactorDesc)
+	}
 	ch <- e.totalScrapes
+	ch <- e.collectPanics
+}
+
+// warmUp scrapes the targets once to fill the cache before the first
+// collect, and reports whether it did: without a cache TTL or refresh
+// schedule, every collect scrapes them anyway.
+func (e *Exporter) warmUp() bool {
+	if e.schedule == nil && e.cacheTTL <= 0 {
+		return false
+	}
+	log.Infoln("Warming up the cache")
+	e.refresh()
+	return true
+}
+
+// runSchedule refreshes the cached metrics at the times of the refresh
+// schedule.
+func (e *Exporter) runSchedule() {
+	for {
+		next := e.schedule.next(time.Now())
+		if next.IsZero() {
+			log.Errorln("Refresh schedule has no upcoming runs")
+			return
+		}
+		time.Sleep(time.Until(next) + e.randomJitter())
+		e.refresh()
+	}
+}
+
+// refresh scrapes the targets, caches the collected metrics and queues them
+// for the sinks. Concurrent refreshes share a single scrape, and a panic
+// fails the refresh only, whether run by a scrape or in the background. In an HA
+// deployment, standby replicas keep the metrics of their last scrape as
+// leader instead, as do all replicas once the call budget is exhausted.
+func (e *Exporter) refresh() {
+	e.flight.Do("refresh", func() (interface{}, error) {
+		return nil, e.safely(func() error {
+			if !e.mayCall(e.callsPerTarget() * len(e.targets)) {
+				return nil
+			}
+			metrics, snap := e.scrapeAll()
+			if e.seriesLimiter != nil {
+				metrics = e.seriesLimiter.filter(metrics, e.breakdownFamilies())
+			}
+
+			e.mutex.Lock()
+			e.cache, e.cacheTime, e.snapshot = metrics, snap.Time, snap
+			if snap.LastError != "" {
+				e.lastError, e.lastErrorTime = snap.LastError, snap.Time
+			}
+			e.cacheJitter = e.randomJitter()
+			e.mutex.Unlock()
+
+			e.queuePush(metrics, snap.Time)
+			return nil
+		})
+	})
+}
+
+// randomJitter returns a random duration up to the configured jitter.
+func (e *Exporter) randomJitter() time.Duration {
+	if e.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(e.jitter)))
+}
+
+// mayCall returns whether this replica may make the given number of Cost
+// Explorer calls: it must be the leader of an HA deployment, if any, and the
+// calls are taken from the call budget, if any. Calls exceeding the budget
+// are counted as skipped.
+func (e *Exporter) mayCall(calls int) bool {
+	if e.elector != nil && !e.elector.IsLeader() {
+		return false
+	}
+	if e.callBudget == nil {
+		return true
+	}
+	if e.callBudget.take(calls) {
+		return true
+	}
+	e.callsSkipped.Add(float64(calls))
+	return false
+}
+
+// callsPerTarget returns the number of Cost Explorer calls made when
+// scraping a target.
+func (e *Exporter) callsPerTarget() int {
+	calls := 1
+	if e.dataExports {
+		calls = 0
+	}
+	if e.forecast != nil {
+		calls++
+	}
+	if e.hourly != nil {
+		calls++
+	}
+	if e.fetchServices != nil {
+		calls++
+	}
+	if e.costCategories != nil {
+		calls++
+	}
+	if e.tags != nil {
+		calls++
+	}
+	for _, p := range e.presets {
+		calls += p.calls()
+	}
+	for _, b := range e.tagBreakdowns {
+		calls += b.calls()
+	}
+	return calls + len(e.currentJobs())
+}
+
+// currentJobs returns the collectors of the query jobs of the configuration
+// file as last loaded.
+func (e *Exporter) currentJobs() []*jobCollector {
+	e.jobsMutex.RLock()
+	defer e.jobsMutex.RUnlock()
+	return e.jobs
 }
 
-func fetchHTTP(metrics []string) func() (*costexplorer.GetCostAndUsageOutput, error) {
-	sess := session.Must(session.NewSession())
-	client := costexplorer.New(sess)
+// reloadJobs replaces the query jobs with those of a reloaded configuration
+// file.
+func (e *Exporter) reloadJobs(jobs []jobConfig) error {
+	e.jobsMutex.Lock()
+	defer e.jobsMutex.Unlock()
+	jcs, err := newJobCollectors(jobs, e.jobs, e.selected, e.layout, e.constLabels, e.serviceNames, e.runRateDays)
+	if err != nil {
+		return err
+	}
+	if err := e.checkJobs(jcs); err != nil {
+		return err
+	}
+	e.jobs = jcs
+	return nil
+}
+
+// scrapeAll scrapes all targets and returns the collected metrics and a
+// snapshot of the billing data for the web UI.
+func (e *Exporter) scrapeAll() ([]prometheus.Metric, *snapshot) {
+	metrics := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var all []prometheus.Metric
+		for m := range metrics {
+			all = append(all, m)
+		}
+		done <- all
+	}()
+	snap := &snapshot{}
+	e.scrape(metrics, e.targets, snap)
+	close(metrics)
+	snap.Time = time.Now()
+	return <-done, snap
+}
 
-	return func() (*costexplorer.GetCostAndUsageOutput, error) {
+// queryWindow returns the days covered by the billing metrics: the last two
+// complete days, so that day-over-day changes can be computed from a single
+// query, widened as configured.
+func queryWindow(cfg windowConfig) (start, end time.Time) {
+	end = today()
+	start = end.AddDate(0, 0, -2)
+	if cfg.runRateDays > 2 {
+		start = end.AddDate(0, 0, -cfg.runRateDays)
+	}
+	if s := end.AddDate(0, 0, -cfg.historyDays); s.Before(start) {
+		start = s
+	}
+	if cfg.comparePeriods {
+		if s := comparisonStart(end); s.Before(start) {
+			start = s
+		}
+	}
+	if cfg.monthToDate {
+		if s := monthStart(end.AddDate(0, 0, -1)); s.Before(start) {
+			start = s
+		}
+	}
+	return start, end
+}
+
+// fetchHTTP returns a function querying the given metrics from Cost Explorer
+// over the query window.
+func fetchHTTP(metrics []string, window windowConfig) func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+	return func(ctx context.Context, t *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		start, end := queryWindow(window)
 		input := &costexplorer.GetCostAndUsageInput{
 			Metrics:     aws.StringSlice(metrics),
 			Granularity: aws.String("DAILY"),
 			TimePeriod: &costexplorer.DateInterval{
-				Start: aws.String(time.Now().AddDate(0, 0, -1).Format("2006-01-02")),
-				End:   aws.String(time.Now().Format("2006-01-02")),
+				Start: aws.String(start.Format(dateFormat)),
+				End:   aws.String(end.Format(dateFormat)),
 			},
 		}
 
-		resp, err := client.GetCostAndUsage(input)
+		resp, err := t.client.GetCostAndUsageWithContext(ctx, input)
 		if err != nil {
 			return nil, err
 		}
@@ -201,69 +1411,630 @@ func fetchHTTP(metrics []string) func() (*costexplorer.GetCostAndUsageOutput, er
 	}
 }
 
-// filterServerMetrics returns the set of server metrics specified by the comma
-// separated filter.
-func filterServerMetrics(filter string) (map[int]*prometheus.Desc, error) {
-	metrics := map[int]*prometheus.Desc{}
-	if len(filter) == 0 {
-		return metrics, nil
+// fetchServiceBreakdown returns a function querying the given metrics from
+// Cost Explorer by service over the query window, following all pages.
+func fetchServiceBreakdown(metrics []string, window windowConfig) func(context.Context, *target) ([]*costexplorer.ResultByTime, error) {
+	return func(ctx context.Context, t *target) ([]*costexplorer.ResultByTime, error) {
+		start, end := queryWindow(window)
+		input := &costexplorer.GetCostAndUsageInput{
+			Metrics:     aws.StringSlice(metrics),
+			Granularity: aws.String("DAILY"),
+			TimePeriod: &costexplorer.DateInterval{
+				Start: aws.String(start.Format(dateFormat)),
+				End:   aws.String(end.Format(dateFormat)),
+			},
+			GroupBy: []*costexplorer.GroupDefinition{{
+				Type: aws.String(costexplorer.GroupDefinitionTypeDimension),
+				Key:  aws.String(costexplorer.DimensionService),
+			}},
+		}
+
+		var results []*costexplorer.ResultByTime
+		for {
+			resp, err := t.client.GetCostAndUsageWithContext(ctx, input)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, resp.ResultsByTime...)
+			if aws.StringValue(resp.NextPageToken) == "" {
+				return results, nil
+			}
+			input.NextPageToken = resp.NextPageToken
+		}
 	}
+}
 
+// filterServerMetrics returns the set of server metrics specified by the comma
+// separated filter of AWS metric names or field numbers, carrying the labels
+// of the given layout and the given constant labels, with one descriptor per
+// subsystem. An empty filter selects all metrics.
+func filterServerMetrics(filter string, layout labelLayout, constLabels prometheus.Labels, subsystems []string) (map[int][]*prometheus.Desc, error) {
 	selected := map[int]struct{}{}
+	if len(filter) == 0 {
+		for field := range prometheusMetrics {
+			selected[field] = struct{}{}
+		}
+	}
 	for _, f := range strings.Split(filter, ",") {
-		field, err := strconv.Atoi(f)
-		if err != nil {
-			return nil, fmt.Errorf("invalid server metric field number: %v", f)
+		if len(f) == 0 {
+			continue
+		}
+		field, ok := prometheusMetrics.lookup(strings.TrimSpace(f))
+		if !ok {
+			return nil, fmt.Errorf("invalid billing metric %q, valid values are: %s", f, prometheusMetrics.usage())
 		}
 		selected[field] = struct{}{}
 	}
 
-	for field, metric := range prometheusMetrics {
-		if _, ok := selected[field]; ok {
-			metrics[field] = metric
+	metrics := map[int][]*prometheus.Desc{}
+	for field := range selected {
+		metric := prometheusMetrics[field]
+		for _, subsystem := range subsystems {
+			metrics[field] = append(metrics[field], newAwsBillingMetric(subsystem, metric.name, metric.help, layout, constLabels))
 		}
 	}
 	return metrics, nil
 }
 
+// parseLabels parses a comma separated list of name=value pairs into
+// constant labels.
+func parseLabels(s string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	if len(s) == 0 {
+		return labels, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || !model.LabelName(kv[0]).IsValid() {
+			return nil, fmt.Errorf("invalid label: %v", pair)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
 func main() {
 
 	var (
-		listenAddress                = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9614").String()
+		configFile                   = kingpin.Flag("config.file", "Path to a YAML configuration file defining additional named query jobs and secrets. Its jobs are reloaded on SIGHUP.").Default("").String()
+		configWatch                  = kingpin.Flag("config.watch", "Also reload the configuration file when it changes, e.g. when a Kubernetes ConfigMap is updated.").Default("false").Bool()
+		listenAddresses              = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry, host:port or unix:///path/to/socket for a Unix domain socket. Repeat to listen on several addresses.").Default(":9614").Strings()
 		metricsPath                  = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-		awsBillingServerMetricFields = kingpin.Flag("aws-billing.metrics", "Comma-separated list of billing metrics. Leave this argument if you want to scrape all available metrics. See https://docs.aws.amazon.com/aws-cost-management/latest/APIReference/API_GetCostAndUsage.html#API_GetCostAndUsage_RequestSyntax").Default(prometheusMetrics.String()).String()
+		systemdSocket                = kingpin.Flag("web.systemd-socket", "Use the socket passed by systemd socket activation instead of --web.listen-address.").Default("false").Bool()
+		readTimeout                  = kingpin.Flag("web.read-timeout", "Maximum duration for reading an entire request. Set to 0 to disable.").Default("30s").Duration()
+		readHeaderTimeout            = kingpin.Flag("web.read-header-timeout", "Maximum duration for reading the headers of a request. Set to 0 to use --web.read-timeout.").Default("10s").Duration()
+		writeTimeout                 = kingpin.Flag("web.write-timeout", "Maximum duration for writing a response, which includes scraping AWS unless the metrics are cached. Set to 0 to disable.").Default("5m").Duration()
+		idleTimeout                  = kingpin.Flag("web.idle-timeout", "Maximum duration to keep idle keep-alive connections open. Set to 0 to use --web.read-timeout.").Default("2m").Duration()
+		maxHeaderBytes               = kingpin.Flag("web.max-header-bytes", "Maximum size of the headers of a request in bytes.").Default("1048576").Int()
+		accessLogs                   = kingpin.Flag("web.access-log", "Log the method, path, status, duration and remote address of requests to the metrics and API endpoints at debug level.").Default("false").Bool()
+		bearerToken                  = kingpin.Flag("web.bearer-token", "Bearer token required to access the metrics and API endpoints. Leave empty to disable authentication.").Default("").String()
+		bearerTokenFile              = kingpin.Flag("web.bearer-token-file", "File containing the bearer token required to access the metrics and API endpoints.").Default("").String()
+		allowedCIDRs                 = kingpin.Flag("web.allowed-cidr", "Network in CIDR notation, e.g. 10.0.0.0/8, allowed to access the metrics, API and UI endpoints. Repeat for several networks. All addresses are allowed if not given.").Strings()
+		enableOpenMetrics            = kingpin.Flag("web.enable-openmetrics", "Serve metrics in the OpenMetrics format to scrapers asking for it. Counters are then exposed with the _total suffix.").Default("false").Bool()
+		awsProfile                   = kingpin.Flag("aws.profile", "Named profile from the shared AWS config and credentials files to use.").Default("").String()
+		awsRoleARN                   = kingpin.Flag("aws.role-arn", "ARN of an IAM role to assume before calling the cost and usage API. Repeat to collect from several accounts.").Strings()
+		awsAccountID                 = kingpin.Flag("aws.account-id", "Account ID of the exporter's own credentials, used as the account_id label instead of looking it up with STS. Ignored with --aws.role-arn.").Default("").String()
+		awsExternalID                = kingpin.Flag("aws.external-id", "External ID to pass when assuming the role given by --aws.role-arn.").Default("").String()
+		awsExternalIDFile            = kingpin.Flag("aws.external-id-file", "File containing the external ID to pass when assuming the role given by --aws.role-arn.").Default("").String()
+		awsSessionName               = kingpin.Flag("aws.session-name", "Session name to use when assuming the role given by --aws.role-arn.").Default("aws_billing_exporter").String()
+		awsPartition                 = kingpin.Flag("aws.partition", "AWS partition to query: aws, aws-us-gov or aws-cn.").Default("aws").Enum(partitionNames()...)
+		awsCEEndpoint                = kingpin.Flag("aws.ce-endpoint", "Cost Explorer endpoint URL. Defaults to the endpoint of the selected partition.").Default("").String()
+		awsBillingViewARN            = kingpin.Flag("aws.billing-view-arn", "ARN of an AWS Billing View to scope Cost Explorer queries to, e.g. a view shared to a member account. Defaults to the payer-wide view.").Default("").String()
+		awsHTTPProxy                 = kingpin.Flag("aws.http-proxy", "Proxy URL for plain HTTP requests to AWS. Overrides HTTP_PROXY.").Default("").String()
+		awsHTTPSProxy                = kingpin.Flag("aws.https-proxy", "Proxy URL for HTTPS requests to AWS. Overrides HTTPS_PROXY.").Default("").String()
+		awsNoProxy                   = kingpin.Flag("aws.no-proxy", "Comma-separated list of hosts, domains and CIDRs that bypass the proxy. Overrides NO_PROXY.").Default("").String()
+		awsVaultAddress              = kingpin.Flag("aws.vault-address", "Address of the HashiCorp Vault server to fetch AWS credentials from.").Default("").Envar("VAULT_ADDR").String()
+		awsVaultToken                = kingpin.Flag("aws.vault-token", "Vault token to read AWS credentials with.").Default("").Envar("VAULT_TOKEN").String()
+		awsVaultTokenFile            = kingpin.Flag("aws.vault-token-file", "File containing the Vault token to read AWS credentials with.").Default("").String()
+		awsVaultPath                 = kingpin.Flag("aws.vault-path", "Path of the Vault AWS secrets engine to fetch short-lived credentials from, e.g. aws/sts/billing. Credentials are refreshed before they expire. Uses the default credential chain if not given.").Default("").String()
+		awsDebug                     = kingpin.Flag("aws.debug", "Log the requests to and responses from AWS APIs, with credentials redacted, to diagnose signature, endpoint and throttling issues. The logs contain billing data.").Default("false").Bool()
+		validateCredentials          = kingpin.Flag("aws.validate-credentials", "Check the credentials of every account with STS GetCallerIdentity at startup and exit if any fail.").Default("false").Bool()
+		validateCostExplorer         = kingpin.Flag("aws.validate-cost-explorer", "Also make one Cost Explorer call per account when validating the credentials at startup. Each call is billed at $0.01.").Default("false").Bool()
+		awsCABundle                  = kingpin.Flag("aws.ca-bundle", "Path to a PEM encoded bundle of additional root CAs to trust for AWS API calls, e.g. for TLS intercepting proxies.").Default("").String()
+		targetCurrency               = kingpin.Flag("aws-billing.target-currency", "ISO 4217 code of a currency, e.g. EUR, to additionally export cost metrics in. Leave empty to disable conversion.").Default("").String()
+		ratesSource                  = kingpin.Flag("aws-billing.rates-source", "Source of the exchange rates used for --aws-billing.target-currency: ecb or file.").Default("ecb").Enum("ecb", "file")
+		ratesFile                    = kingpin.Flag("aws-billing.rates-file", "JSON file mapping currency codes to their rates against a common base, used with --aws-billing.rates-source=file.").Default("").String()
+		zeroFill                     = kingpin.Flag("aws-billing.zero-fill", "Export groups of presets, tag and cost category breakdowns and jobs that were seen before but have no cost or usage in the current period as 0 instead of dropping their series.").Default("false").Bool()
+		zeroFillTTL                  = kingpin.Flag("aws-billing.zero-fill.ttl", "Stop exporting groups as 0 once they have been missing for this long, e.g. 720h. Set to 0 to export them as long as the exporter runs.").Default("0s").Duration()
+		maxSeries                    = kingpin.Flag("aws-billing.max-series-per-metric", "Maximum number of series exported per metric family of presets, tag and cost category breakdowns and jobs. New label combinations beyond it are dropped and counted in aws_billing_series_dropped_total. Set to 0 for no limit.").Default("0").Int()
+		legacyUnit                   = kingpin.Flag("aws-billing.legacy-unit-label", "Export the currency of costs in the unit label, as before the currency label was introduced.").Default("false").Bool()
+		normalizeUnits               = kingpin.Flag("aws-billing.normalize-units", "Convert usage amounts into canonical units, e.g. GB into bytes and Hrs into hours, reflected in the unit label, so that they can be aggregated across usage types.").Default("false").Bool()
+		exchangeRate                 = kingpin.Flag("aws-billing.exchange-rate", "Static exchange rate as FROM:TO=RATE, e.g. USD:EUR=0.92, applied to all amounts in the FROM currency.").Default("").String()
+		subsystem                    = kingpin.Flag("aws-billing.subsystem", "Subsystem of the billing metric names, as in aws_billing_<subsystem>_blended_cost. Set to an empty string to drop it.").Default(legacySubsystem).String()
+		legacyNames                  = kingpin.Flag("aws-billing.legacy-names", "Additionally export billing metrics under the old aws_billing_server_* names while migrating to another subsystem.").Default("false").Bool()
+		comparePeriods               = kingpin.Flag("aws-billing.period-comparison", "Export week-over-week and month-over-month comparisons. Widens the cost and usage query to cover the previous month.").Default("false").Bool()
+		projectMonthEnd              = kingpin.Flag("aws-billing.month-end-projection", "Export the billing metrics projected linearly to the end of the month from the month to date. Widens the cost and usage query to cover the month.").Default("false").Bool()
+		historyDays                  = kingpin.Flag("aws-billing.history-days", "Export the billing metrics of each of this many complete days as separate series with a date label, so that a single scrape gives the recent history. Widens the cost and usage query to cover them. Disabled if 0.").Default("0").Int()
+		runRateDays                  = kingpin.Flag("aws-billing.run-rate-days", "Export the average daily value of the billing metrics, and of each group of the breakdowns and daily query jobs, over this many complete days as a smoother signal for alerting. Widens the cost and usage queries to cover them. Disabled if 0.").Default("0").Int()
+		dataExportARN                = kingpin.Flag("data-exports.export-arn", "ARN of an AWS Data Exports (CUR 2.0) export delivering gzipped CSV files to S3 to read the billing metrics from instead of Cost Explorer. The amortized costs are not available from exports.").Default("").String()
+		serviceBreakdown             = kingpin.Flag("aws-billing.service-breakdown", "Also query the billing metrics by service from Cost Explorer, shown per service by the web UI at /ui. Takes one more cost and usage query per account and refresh.").Default("false").Bool()
+		shortServiceNames            = kingpin.Flag("aws-billing.short-service-names", "Export service names shortened to a stable short form, e.g. ec2 for \"Amazon Elastic Compute Cloud - Compute\".").Default("false").Bool()
+		serviceNameOverrides         = kingpin.Flag("aws-billing.service-name", "Short name of a service as NAME=SHORT, overriding or extending the built-in short names. Implies --aws-billing.short-service-names. Repeat for several services.").Strings()
+		tagKeys                      = kingpin.Flag("aws-billing.tag-key", "Cost allocation tag key to break the billing metrics of the last complete day down by, exported as a label of aws_billing_tag_last_day named after the sanitized key. Repeat for a second key.").Strings()
+		allowedTagKeys               = kingpin.Flag("aws-billing.allowed-tag-key", "Tag key allowed to be exported, as a label of --aws-billing.tag-key or by the cost allocation tags collector. Repeat for several keys. All keys are allowed if not given.").Strings()
+		categoryKeys                 = kingpin.Flag("aws-billing.cost-category", "Cost category to break the billing metrics of the last complete day down by, exported as a label of aws_billing_cost_category_last_day named after the sanitized category name. Repeat for a second cost category.").Strings()
+		enabledPresets               = kingpin.Flag("aws-billing.preset", "Breakdown preset to export in addition to the totals, one of: "+strings.Join(presetNames(), ", ")+". Repeat for several presets.").Strings()
+		enableBudgets                = kingpin.Flag("collector.budgets", "Enable the collector exporting limits and spend of AWS Budgets.").Default("false").Bool()
+		enableForecast               = kingpin.Flag("collector.forecast", "Enable the collector exporting the AWS cost forecast for the rest of the month.").Default("false").Bool()
+		enableHourly                 = kingpin.Flag("collector.hourly", "Enable the collector exporting billing metrics at hourly granularity. Requires hourly data to be enabled in the Cost Explorer preferences.").Default("false").Bool()
+		hourlyHours                  = kingpin.Flag("collector.hourly.hours", "Number of past hours covered by the hourly collector, up to 336.").Default("24").Int()
+		enableTrustedAdvisor         = kingpin.Flag("collector.trusted-advisor", "Enable the collector exporting estimated savings and flagged resources of the Trusted Advisor cost optimization checks.").Default("false").Bool()
+		enableComputeOptimizer       = kingpin.Flag("collector.compute-optimizer", "Enable the collector exporting estimated savings and resource counts by finding of the Compute Optimizer recommendations.").Default("false").Bool()
+		enableReservations           = kingpin.Flag("collector.reservations", "Enable the collector exporting the inventory and expiration of active EC2, RDS and ElastiCache reservations.").Default("false").Bool()
+		reservationRegions           = kingpin.Flag("collector.reservations.region", "Region to list reservations in. Repeat for several regions. Defaults to the region of the AWS session; reservations in other regions are not exported.").Strings()
+		enableSavingsPlans           = kingpin.Flag("collector.savings-plans", "Enable the collector exporting the inventory, commitment, term and state of savings plans.").Default("false").Bool()
+		enableAccountInfo            = kingpin.Flag("collector.account-info", "Enable the collector exporting the caller identity of the exporter in each account.").Default("false").Bool()
+		enableCostCategories         = kingpin.Flag("collector.cost-categories", "Enable the collector exporting the definitions of cost categories.").Default("false").Bool()
+		enableTags                   = kingpin.Flag("collector.cost-allocation-tags", "Enable the collector exporting whether cost allocation tags are active.").Default("false").Bool()
+		enableBillingConductor       = kingpin.Flag("collector.billing-conductor", "Enable the collector exporting the pro forma cost and margin of AWS Billing Conductor billing groups and their custom line items.").Default("false").Bool()
+		enableAliases                = kingpin.Flag("collector.account-alias", "Enable the collector exporting account aliases as aws_billing_account_alias_info.").Default("false").Bool()
+		aliasSource                  = kingpin.Flag("collector.account-alias.source", "Source of account aliases: iam for the IAM account alias of each target, or organizations for the names of all accounts in the organization.").Default("iam").Enum("iam", "organizations")
+		aliasRefresh                 = kingpin.Flag("collector.account-alias.refresh-interval", "Interval at which account aliases are resolved again.").Default("1h").Duration()
+		concurrency                  = kingpin.Flag("aws-billing.concurrency", "Number of accounts scraped in parallel.").Default("4").Int()
+		targetTimeout                = kingpin.Flag("aws-billing.target-timeout", "Timeout for scraping a single account. Set to 0 to disable.").Default("30s").Duration()
+		callBudget                   = kingpin.Flag("aws-billing.daily-call-budget", "Maximum number of Cost Explorer calls per day. Once exhausted, scrapes serve the metrics of the last scrape. Set to 0 for no limit.").Default("0").Int()
+		cacheTTL                     = kingpin.Flag("aws-billing.cache-ttl", "How long scraped metrics are served from the cache. Expired metrics are still served while they are refreshed in the background. Set to 0 to scrape AWS on every request.").Default("0s").Duration()
+		warmUp                       = kingpin.Flag("aws-billing.warm-up", "Scrape AWS once on startup, before registering the collectors and reporting ready on /-/ready, so the first scrape is served from a warm cache. Only applies with a cache TTL or refresh schedule.").Default("false").Bool()
+		refreshSchedule              = kingpin.Flag("aws-billing.refresh-schedule", "Cron expression in local time, e.g. \"15 */6 * * *\", at which to refresh the cached metrics in the background. Scrapes are then always served from the cache.").Default("").String()
+		refreshJitter                = kingpin.Flag("aws-billing.refresh-jitter", "Maximum random delay added to background refreshes, after the cache TTL or at the refresh schedule, so that fleets of exporters don't call Cost Explorer in sync.").Default("0s").Duration()
+		notifyURL                    = kingpin.Flag("notify.webhook-url", "URL to POST a JSON notification to when a spend threshold given by --notify.threshold is crossed.").Default("").String()
+		notifyURLFile                = kingpin.Flag("notify.webhook-url-file", "File containing the URL given by --notify.webhook-url, e.g. one with credentials.").Default("").String()
+		notifySNSTopic               = kingpin.Flag("notify.sns-topic-arn", "ARN of an SNS topic to publish a JSON notification to when a spend threshold given by --notify.threshold is crossed.").Default("").String()
+		notifyThresholds             = kingpin.Flag("notify.threshold", "Spend threshold as PERIOD:METRIC[@ACCOUNT][/SERVICE]>AMOUNT, with PERIOD daily, mtd or forecast, e.g. mtd:UnblendedCost>1000. Repeat for several thresholds.").Strings()
+		notifyInterval               = kingpin.Flag("notify.interval", "Interval at which spend thresholds are evaluated.").Default("1h").Duration()
+		cloudWatchNamespace          = kingpin.Flag("cloudwatch.namespace", "CloudWatch namespace to publish the metrics of every refresh to as custom metrics. Publishing is disabled if empty.").Default("").String()
+		cloudWatchRegion             = kingpin.Flag("cloudwatch.region", "AWS region to publish CloudWatch metrics in, the region of the AWS session if empty.").Default("").String()
+		influxDBURL                  = kingpin.Flag("influxdb.url", "URL of an InfluxDB 2 server to write the metrics of every refresh to in the line protocol, e.g. http://influxdb:8086. Writing is disabled if empty.").Default("").String()
+		influxDBOrg                  = kingpin.Flag("influxdb.org", "InfluxDB organization owning the bucket.").Default("").String()
+		influxDBBucket               = kingpin.Flag("influxdb.bucket", "InfluxDB bucket to write the metrics to.").Default("").String()
+		influxDBToken                = kingpin.Flag("influxdb.token", "InfluxDB API token with write access to the bucket.").Default("").Envar("INFLUXDB_TOKEN").String()
+		influxDBTokenFile            = kingpin.Flag("influxdb.token-file", "File containing the InfluxDB API token.").Default("").String()
+		graphiteAddress              = kingpin.Flag("graphite.address", "Host and port of a Graphite Carbon plaintext endpoint to send the metrics of every refresh to, e.g. carbon:2003. Sending is disabled if empty.").Default("").String()
+		graphitePrefix               = kingpin.Flag("graphite.prefix", "Prefix of the Graphite paths of the metrics, e.g. billing.").Default("").String()
+		graphiteTags                 = kingpin.Flag("graphite.tags", "Send the labels of the metrics as Graphite tags instead of appending their values to the paths.").Default("false").Bool()
+		statsDAddress                = kingpin.Flag("statsd.address", "Host and port of a StatsD server to send the metrics of every refresh to as DogStatsD gauges with tags, e.g. localhost:8125. Sending is disabled if empty.").Default("").String()
+		statsDPrefix                 = kingpin.Flag("statsd.prefix", "Prefix of the StatsD metric names.").Default("").String()
+		kafkaURL                     = kingpin.Flag("kafka.rest-proxy-url", "URL of a Kafka REST proxy to publish the metrics of every refresh through as JSON records, e.g. http://rest-proxy:8082. Publishing is disabled if empty.").Default("").String()
+		kafkaTopic                   = kingpin.Flag("kafka.topic", "Kafka topic to publish the metrics to.").Default("").String()
+		pushURL                      = kingpin.Flag("push.webhook-url", "URL to POST the metrics of every refresh to as JSON. Pushing is disabled if empty.").Default("").String()
+		pushURLFile                  = kingpin.Flag("push.webhook-url-file", "File containing the URL given by --push.webhook-url, e.g. one with credentials.").Default("").String()
+		pushSecret                   = kingpin.Flag("push.secret", "Secret to sign the pushed JSON with HMAC-SHA256 in the X-Signature-256 header. Not signed if empty.").Default("").String()
+		pushSecretFile               = kingpin.Flag("push.secret-file", "File containing the secret given by --push.secret.").Default("").String()
+		digestURL                    = kingpin.Flag("digest.webhook-url", "Slack or Microsoft Teams incoming webhook URL to post a cost summary to.").Default("").String()
+		digestURLFile                = kingpin.Flag("digest.webhook-url-file", "File containing the webhook URL given by --digest.webhook-url.").Default("").String()
+		digestSchedule               = kingpin.Flag("digest.schedule", "Cron expression in local time at which to post the cost summary.").Default("0 9 * * *").String()
+		digestTopServices            = kingpin.Flag("digest.top-services", "Number of services with the highest spend listed in the cost summary.").Default("5").Int()
+		shardFlag                    = kingpin.Flag("shard", "Scrape only the accounts assigned to shard N of M, given as N/M with N from 0 to M-1, to spread many accounts across replicas. Leave empty to scrape all accounts.").Default("").String()
+		haTable                      = kingpin.Flag("ha.dynamodb-table", "DynamoDB table holding the leader lease of an HA deployment. Only the leader calls the Cost Explorer APIs. Leave empty to disable.").Default("").String()
+		haLockName                   = kingpin.Flag("ha.lock-name", "Name of the leader lease item, shared by all replicas of a deployment.").Default("aws_billing_exporter").String()
+		haIdentity                   = kingpin.Flag("ha.identity", "Identity of this replica when holding the leader lease. Defaults to the hostname.").Default("").String()
+		haLease                      = kingpin.Flag("ha.lease-duration", "Duration of the leader lease. It is renewed every third of it.").Default("60s").Duration()
+		constLabels                  = kingpin.Flag("labels", "Comma-separated list of name=value pairs attached as constant labels to every exported billing metric, e.g. env=prod,org=platform.").Default("").String()
+		awsBillingServerMetricFields = kingpin.Flag("aws-billing.metrics", "Comma-separated list of billing metrics, given by AWS name or field number. Leave this argument if you want to scrape all available metrics. See https://docs.aws.amazon.com/aws-cost-management/latest/APIReference/API_GetCostAndUsage.html#API_GetCostAndUsage_RequestSyntax").Default(prometheusMetrics.String()).String()
 	)
 
+	rand.Seed(time.Now().UnixNano())
+
 	log.AddFlags(kingpin.CommandLine)
 	kingpin.Version(version.Print("aws_billing_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	kingpin.Command("serve", "Serve the billing metrics.").Default()
+	dashboardCmd := kingpin.Command("dashboard", "Write a Grafana dashboard for the metrics exported with the given flags.")
+	dashboardOut := dashboardCmd.Flag("out", "File to write the dashboard JSON to, - for standard output.").Default("-").String()
+	backfillCmd := kingpin.Command("backfill", "Write the billing metrics of the last months, as exported with the given flags, to a remote write endpoint or an OpenMetrics file.")
+	backfillURL := backfillCmd.Flag("remote-write-url", "URL of the Prometheus remote write endpoint to write the samples to.").Default("").String()
+	backfillOut := backfillCmd.Flag("out", "File to write the samples to in the OpenMetrics format for promtool tsdb create-blocks-from openmetrics, - for standard output.").Default("").String()
+	backfillMonths := backfillCmd.Flag("months", "Number of months to backfill, at most 12.").Default("12").Int()
+	backfillStep := backfillCmd.Flag("step", "Interval of the samples written for every day, which should not exceed the lookback delta of Prometheus queries.").Default("5m").Duration()
+	command := kingpin.Parse()
+	labels, err := parseLabels(*constLabels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fileConfig := &config{}
+	if *configFile != "" {
+		if fileConfig, err = loadConfig(*configFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	secrets := fileConfig.secrets()
+	for _, s := range []struct {
+		name        string
+		value, file *string
+	}{
+		{"aws.external-id", awsExternalID, awsExternalIDFile},
+		{"notify.webhook-url", notifyURL, notifyURLFile},
+		{"digest.webhook-url", digestURL, digestURLFile},
+		{"aws.vault-token", awsVaultToken, awsVaultTokenFile},
+		{"influxdb.token", influxDBToken, influxDBTokenFile},
+		{"push.webhook-url", pushURL, pushURLFile},
+		{"push.secret", pushSecret, pushSecretFile},
+	} {
+		if *s.value, err = readSecret(s.name, *s.value, *s.file); err != nil {
+			log.Fatal(err)
+		}
+		if v := secrets[s.name]; v != "" {
+			if *s.value != "" {
+				log.Fatalf("%s is given both by --%s and by the configuration file", s.name, s.name)
+			}
+			*s.value = v
+		}
+	}
+	if *bearerToken, err = readBearerToken(*bearerToken, *bearerTokenFile); err != nil {
+		log.Fatal(err)
+	}
 
-	selectedServerMetrics, err := filterServerMetrics(*awsBillingServerMetricFields)
+	subsystems := metricSubsystems(*subsystem, *legacyNames)
+	layout := labelLayout{legacyUnit: *legacyUnit}
+	selectedServerMetrics, err := filterServerMetrics(*awsBillingServerMetricFields, layout, labels, subsystems)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	hours := 0
+	if *enableHourly {
+		if hours = *hourlyHours; hours < 1 {
+			log.Fatalf("Invalid --collector.hourly.hours %d, must be positive", hours)
+		}
+	}
+
+	if command == dashboardCmd.FullCommand() {
+		// The exporter is only built to tell which collectors it runs, and
+		// never calls AWS.
+		e, err := NewExporter(nil, selectedServerMetrics, exporterOptions{
+			constLabels:      labels,
+			labelLayout:      layout,
+			subsystems:       subsystems,
+			comparePeriods:   *comparePeriods,
+			presets:          *enabledPresets,
+			budgets:          *enableBudgets,
+			trustedAdvisor:   *enableTrustedAdvisor,
+			computeOptimizer: *enableComputeOptimizer,
+			reservations:     *enableReservations,
+			savingsPlans:     *enableSavingsPlans,
+			accountInfo:      *enableAccountInfo,
+			costCategories:   *enableCostCategories,
+			tags:             *enableTags,
+			billingConductor: *enableBillingConductor,
+			forecast:         *enableForecast,
+			hourlyHours:      hours,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeDashboard(*dashboardOut, e.dashboardConfig(*subsystem)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	log.Infoln("Starting aws_billing_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	exporter, err := NewExporter(*awsBillingServerMetricFields, selectedServerMetrics)
+	cfg := awsConfig{
+		profile:        *awsProfile,
+		roleARNs:       *awsRoleARN,
+		accountID:      *awsAccountID,
+		externalID:     *awsExternalID,
+		sessionName:    *awsSessionName,
+		partition:      *awsPartition,
+		ceEndpoint:     *awsCEEndpoint,
+		billingViewARN: *awsBillingViewARN,
+		httpProxy:      *awsHTTPProxy,
+		httpsProxy:     *awsHTTPSProxy,
+		noProxy:        *awsNoProxy,
+		caBundle:       *awsCABundle,
+		vaultAddress:   *awsVaultAddress,
+		vaultToken:     *awsVaultToken,
+		vaultPath:      *awsVaultPath,
+		debug:          *awsDebug,
+	}
+	if cfg.vaultPath != "" && cfg.vaultAddress == "" {
+		log.Fatal("--aws.vault-path requires --aws.vault-address")
+	}
+	sess, err := newSession(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var rate *fixedRate
+	if *exchangeRate != "" {
+		if rate, err = parseFixedRate(*exchangeRate); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var conv *converter
+	if *targetCurrency != "" {
+		if conv, err = newConverter(*targetCurrency, *ratesSource, *ratesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	targets, err := newTargets(sess, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *shardFlag != "" {
+		s, err := parseShard(*shardFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		all := len(targets)
+		targets = s.filter(targets)
+		log.Infof("Scraping %d of %d accounts in shard %s", len(targets), all, *shardFlag)
+	}
+	if *validateCredentials {
+		for _, t := range targets {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := t.validate(ctx, *validateCostExplorer)
+			cancel()
+			if err != nil {
+				log.Fatalf("Invalid AWS credentials: %v", err)
+			}
+		}
+		log.Infof("Validated the AWS credentials of %d accounts", len(targets))
+	}
+
+	if *runRateDays < 0 {
+		log.Fatalf("Invalid --aws-billing.run-rate-days %d, must not be negative", *runRateDays)
+	}
+	if *historyDays < 0 {
+		log.Fatalf("Invalid --aws-billing.history-days %d, must not be negative", *historyDays)
+	}
+
+	if command == backfillCmd.FullCommand() {
+		if *backfillMonths < 1 || *backfillMonths > 12 {
+			log.Fatalf("Invalid --months %d, must be between 1 and 12", *backfillMonths)
+		}
+		if *backfillStep <= 0 {
+			log.Fatalf("Invalid --step %s, must be positive", *backfillStep)
+		}
+		if (*backfillURL == "") == (*backfillOut == "") {
+			log.Fatal("Exactly one of --remote-write-url and --out must be given")
+		}
+		// Only a file opened here is closed once the samples are written.
+		var w backfillWriter
+		var out *os.File
+		switch *backfillOut {
+		case "":
+			w = newRemoteWriter(*backfillURL)
+		case "-":
+			w = openMetricsWriter{os.Stdout}
+		default:
+			if out, err = os.Create(*backfillOut); err != nil {
+				log.Fatal(err)
+			}
+			w = openMetricsWriter{out}
+		}
+		e, err := NewExporter(targets, selectedServerMetrics, exporterOptions{
+			fixedRate:       rate,
+			normalizeUnits:  *normalizeUnits,
+			constLabels:     labels,
+			labelLayout:     layout,
+			subsystems:      subsystems,
+			comparePeriods:  *comparePeriods,
+			runRateDays:     *runRateDays,
+			projectMonthEnd: *projectMonthEnd,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := e.backfill(*backfillMonths, *backfillStep, w); err != nil {
+			log.Fatal(err)
+		}
+		if out != nil {
+			if err := out.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+	var el elector
+	if *haTable != "" {
+		identity := *haIdentity
+		if identity == "" {
+			if identity, err = os.Hostname(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		el = newDynamoLock(sess, *haTable, *haLockName, identity, *haLease)
+	}
+
+	var schedule *cronSchedule
+	if *refreshSchedule != "" {
+		if schedule, err = parseCron(*refreshSchedule); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var names serviceNames
+	if *shortServiceNames || len(*serviceNameOverrides) > 0 {
+		if names, err = newServiceNames(*serviceNameOverrides); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var metricSinks []metricSink
+	if *cloudWatchNamespace != "" {
+		metricSinks = append(metricSinks, newCloudWatchSink(sess, *cloudWatchNamespace, *cloudWatchRegion))
+	}
+	if *influxDBURL != "" {
+		if *influxDBBucket == "" {
+			log.Fatal("--influxdb.url requires --influxdb.bucket")
+		}
+		metricSinks = append(metricSinks, newInfluxDBSink(*influxDBURL, *influxDBOrg, *influxDBBucket, *influxDBToken))
+	}
+	if *graphiteAddress != "" {
+		metricSinks = append(metricSinks, newGraphiteSink(*graphiteAddress, *graphitePrefix, *graphiteTags))
+	}
+	if *statsDAddress != "" {
+		metricSinks = append(metricSinks, newStatsDSink(*statsDAddress, *statsDPrefix))
+	}
+	if *kafkaURL != "" {
+		if *kafkaTopic == "" {
+			log.Fatal("--kafka.rest-proxy-url requires --kafka.topic")
+		}
+		metricSinks = append(metricSinks, newKafkaSink(*kafkaURL, *kafkaTopic))
+	}
+	if *pushURL != "" {
+		metricSinks = append(metricSinks, newPushSink(*pushURL, *pushSecret, fileConfig.Push.BasicAuth))
+	}
+
+	exporter, err := NewExporter(targets, selectedServerMetrics, exporterOptions{
+		fixedRate:          rate,
+		normalizeUnits:     *normalizeUnits,
+		zeroFill:           *zeroFill,
+		zeroFillTTL:        *zeroFillTTL,
+		maxSeries:          *maxSeries,
+		converter:          conv,
+		constLabels:        labels,
+		labelLayout:        layout,
+		subsystems:         subsystems,
+		comparePeriods:     *comparePeriods,
+		runRateDays:        *runRateDays,
+		historyDays:        *historyDays,
+		projectMonthEnd:    *projectMonthEnd,
+		dataExportARN:      *dataExportARN,
+		serviceBreakdown:   *serviceBreakdown || (*digestURL != "" && *digestTopServices > 0),
+		presets:            *enabledPresets,
+		serviceNames:       names,
+		tagKeys:            *tagKeys,
+		categoryKeys:       *categoryKeys,
+		allowedTagKeys:     *allowedTagKeys,
+		jobs:               fileConfig.Jobs,
+		budgets:            *enableBudgets,
+		trustedAdvisor:     *enableTrustedAdvisor,
+		computeOptimizer:   *enableComputeOptimizer,
+		reservations:       *enableReservations,
+		reservationRegions: *reservationRegions,
+		savingsPlans:       *enableSavingsPlans,
+		accountInfo:        *enableAccountInfo,
+		costCategories:     *enableCostCategories,
+		tags:               *enableTags,
+		billingConductor:   *enableBillingConductor,
+		forecast:           *enableForecast,
+		hourlyHours:        hours,
+		concurrency:        *concurrency,
+		targetTimeout:      *targetTimeout,
+		elector:            el,
+		callBudget:         *callBudget,
+		cacheTTL:           *cacheTTL,
+		schedule:           schedule,
+		jitter:             *refreshJitter,
+		sinks:              metricSinks,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	if lock, ok := el.(*dynamoLock); ok {
+		go lock.run(exporter.safely)
+	}
+
+	var sinks []notificationSink
+	if *notifyURL != "" {
+		sinks = append(sinks, newWebhookSink(*notifyURL, fileConfig.Notify.BasicAuth))
+	}
+	if *notifySNSTopic != "" {
+		s, err := newSNSSink(sess, *notifySNSTopic)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks = append(sinks, s)
+	}
+	if len(sinks) > 0 || len(*notifyThresholds) > 0 {
+		if len(sinks) == 0 || len(*notifyThresholds) == 0 {
+			log.Fatal("--notify.threshold must be given together with --notify.webhook-url or --notify.sns-topic-arn")
+		}
+		n, err := newNotifier(exporter, *notifyThresholds, sinks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go n.run(*notifyInterval)
+	}
+	if *digestURL != "" {
+		schedule, err := parseCron(*digestSchedule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go newDigest(exporter, *digestURL, *digestTopServices, fileConfig.Digest.BasicAuth).run(schedule)
+	}
+
+	var ready int32
+	allowlist, err := newIPAllowlist(*allowedCIDRs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The profiling endpoints registered on http.DefaultServeMux by
+	// net/http/pprof are not served; routes registers them behind web.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Healthy"))
+	})
+	mux.Handle("/-/ready", readyHandler(&ready))
+	var extraCollectors []string
+	var extraPermissions []permission
+	if *enableAliases {
+		extraCollectors = append(extraCollectors, "account-alias")
+		action := "iam:ListAccountAliases"
+		if *aliasSource == "organizations" {
+			action = "organizations:ListAccounts"
+		}
+		extraPermissions = append(extraPermissions, permission{Action: action, Feature: "account-alias"})
+	}
+	web := webConfig{bearerToken: *bearerToken, allowlist: allowlist, accessLogs: *accessLogs}
+	exporter.routes(mux, web, *metricsPath, promhttp.HandlerOpts{EnableOpenMetrics: *enableOpenMetrics},
+		extraCollectors, extraPermissions)
+	var listeners []net.Listener
+	if *systemdSocket {
+		if listeners, err = systemdListeners(); err != nil {
+			log.Fatal(err)
+		}
+		if len(listeners) == 0 {
+			log.Fatal("--web.systemd-socket is set but systemd passed no socket")
+		}
+	} else if listeners, err = listenAll(*listenAddresses); err != nil {
+		log.Fatal(err)
+	}
+	server := serverConfig{
+		readTimeout:       *readTimeout,
+		readHeaderTimeout: *readHeaderTimeout,
+		writeTimeout:      *writeTimeout,
+		idleTimeout:       *idleTimeout,
+		maxHeaderBytes:    *maxHeaderBytes,
+	}.server(mux)
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		log.Infoln("Listening on", l.Addr())
+		go func(l net.Listener) {
+			errc <- server.Serve(l)
+		}(l)
+	}
+
+	if *warmUp && !exporter.warmUp() {
+		log.Warnln("Ignoring --aws-billing.warm-up without a cache TTL or refresh schedule, every scrape queries AWS anyway")
+	}
 	prometheus.MustRegister(exporter)
+	if *configFile != "" {
+		go newConfigReloader(*configFile, func(c *config) error { return exporter.reloadJobs(c.Jobs) }).run(*configWatch)
+	}
+	if schedule != nil {
+		go exporter.runSchedule()
+	}
+	if *enableAliases {
+		aliases := newAliasCache(sess, *aliasSource, targets, labels)
+		go aliases.run(context.Background(), *aliasRefresh)
+		prometheus.MustRegister(aliases)
+	}
 	prometheus.MustRegister(version.NewCollector("aws_billing_exporter"))
+	atomic.StoreInt32(&ready, 1)
+	if err := sdNotify("READY=1"); err != nil {
+		log.Errorf("Can't notify systemd of the startup: %v", err)
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go runWatchdog(interval)
+	}
 
-	log.Infoln("Listening on", *listenAddress)
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-             <head><title>AWS Billing Exporter</title></head>
-             <body>
-             <h1>AWS Billing Exporter</h1>
-             <p><a href='` + *metricsPath + `'>Metrics</a></p>
-             </body>
-             </html>`))
-	})
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	log.Fatal(<-errc)
 }
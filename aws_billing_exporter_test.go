@@ -12,3 +12,723 @@
 // limitations under the License.
 
 package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/budgets"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func costAndUsage(amounts ...string) *costexplorer.GetCostAndUsageOutput {
+	out := &costexplorer.GetCostAndUsageOutput{}
+	for _, a := range amounts {
+		out.ResultsByTime = append(out.ResultsByTime, &costexplorer.ResultByTime{
+			Estimated: aws.Bool(true),
+			Total: map[string]*costexplorer.MetricValue{
+				"BlendedCost": {Amount: aws.String(a), Unit: aws.String("USD")},
+			},
+		})
+	}
+	return out
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels("env=prod,org=platform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := prometheus.Labels{"env": "prod", "org": "platform"}
+	if len(labels) != len(want) {
+		t.Fatalf("want %v, got %v", want, labels)
+	}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("label %s: want %q, got %q", k, v, labels[k])
+		}
+	}
+
+	for _, s := range []string{"env", "1env=prod", "env=prod,"} {
+		if _, err := parseLabels(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestFilterServerMetrics(t *testing.T) {
+	metrics, err := filterServerMetrics("2,UsageQuantity,unblendedcost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 3 {
+		t.Fatalf("want 3 metrics, got %d", len(metrics))
+	}
+	for _, field := range []int{2, 6, 7} {
+		if _, ok := metrics[field]; !ok {
+			t.Errorf("metric %d not selected", field)
+		}
+	}
+
+	all, err := filterServerMetrics("", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(prometheusMetrics) {
+		t.Errorf("want all %d metrics for an empty filter, got %d", len(prometheusMetrics), len(all))
+	}
+
+	for _, filter := range []string{"8", "Blended"} {
+		if _, err := filterServerMetrics(filter, labelLayout{}, nil, []string{"server"}); err == nil {
+			t.Errorf("expected error for filter %q", filter)
+		}
+	}
+}
+
+// dailyCostAndUsage returns the given amounts as the results of consecutive
+// days from start on.
+func dailyCostAndUsage(start time.Time, amounts ...string) *costexplorer.GetCostAndUsageOutput {
+	out := costAndUsage(amounts...)
+	for i, r := range out.ResultsByTime {
+		r.TimePeriod = &costexplorer.DateInterval{
+			Start: aws.String(start.AddDate(0, 0, i).Format(dateFormat)),
+			End:   aws.String(start.AddDate(0, 0, i+1).Format(dateFormat)),
+		}
+	}
+	return out
+}
+
+// collectMetrics returns the metrics that f sends, failing the test if f
+// returns an error. f runs concurrently, so that it can send any number of
+// metrics.
+func collectMetrics(t *testing.T, f func(ch chan<- prometheus.Metric) error) metricSlice {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		errc <- f(ch)
+	}()
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return metrics
+}
+
+func TestLabelLayout(t *testing.T) {
+	var layout labelLayout
+	if got := layout.values("BlendedCost", "USD", "123456789012"); strings.Join(got, ",") != "BlendedCost,,USD,123456789012" {
+		t.Errorf("want costs labeled with their currency, got %q", got)
+	}
+	if got := layout.values("UsageQuantity", "Hrs", "123456789012", "m5"); strings.Join(got, ",") != "UsageQuantity,Hrs,,123456789012,m5" {
+		t.Errorf("want usage labeled with its unit, got %q", got)
+	}
+
+	legacy := labelLayout{legacyUnit: true}
+	if got := legacy.values("BlendedCost", "USD", "123456789012"); strings.Join(got, ",") != "BlendedCost,USD,123456789012" || len(legacy.names()) != len(got) {
+		t.Errorf("want costs labeled with their currency as unit, got %q", got)
+	}
+	if legacy.currency() != "unit" || layout.currency() != "currency" {
+		t.Errorf("want the currency label to follow the layout, got %q and %q", legacy.currency(), layout.currency())
+	}
+
+	if got := legacy.unitValues("Hrs"); strings.Join(got, ",") != "Hrs" || len(got) != len(legacy.unitNames()) {
+		t.Errorf("want a single unit label, got %q", got)
+	}
+	if got := layout.unitValues("EUR"); strings.Join(got, ",") != ",EUR" || len(got) != len(layout.unitNames()) {
+		t.Errorf("want the currency label set, got %q", got)
+	}
+
+	// The comparison metrics' label names must not share a backing array.
+	period := layout.names("period")
+	window := layout.names("period", "window")
+	window[0] = "changed"
+	if strings.Join(period, ",") != "type,unit,currency,account_id,period" {
+		t.Errorf("want independent label names, got %q", period)
+	}
+}
+
+func TestMetricSubsystems(t *testing.T) {
+	for _, c := range []struct {
+		subsystem string
+		legacy    bool
+		want      string
+	}{
+		{"server", false, "server"},
+		{"server", true, "server"},
+		{"billing", false, "billing"},
+		{"", true, ",server"},
+	} {
+		if got := strings.Join(metricSubsystems(c.subsystem, c.legacy), ","); got != c.want {
+			t.Errorf("%q with legacy names %t: want %q, got %q", c.subsystem, c.legacy, c.want, got)
+		}
+	}
+
+	subsystems := metricSubsystems("", true)
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, subsystems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: subsystems})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return costAndUsage("80", "100"), nil
+	}
+
+	expected := `
+# HELP aws_billing_blended_cost This cost metric reflects the average cost of usage across the consolidated billing family.
+# TYPE aws_billing_blended_cost gauge
+aws_billing_blended_cost{account_id="123456789012",currency="USD",type="BlendedCost",unit=""} 100
+# HELP aws_billing_server_blended_cost This cost metric reflects the average cost of usage across the consolidated billing family.
+# TYPE aws_billing_server_blended_cost gauge
+aws_billing_server_blended_cost{account_id="123456789012",currency="USD",type="BlendedCost",unit=""} 100
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected),
+		"aws_billing_blended_cost",
+		"aws_billing_server_blended_cost",
+	); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDayOverDayChange(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return costAndUsage("80", "100"), nil
+	}
+
+	expected := `
+# HELP aws_billing_server_blended_cost This cost metric reflects the average cost of usage across the consolidated billing family.
+# TYPE aws_billing_server_blended_cost gauge
+aws_billing_server_blended_cost{account_id="123456789012",currency="USD",type="BlendedCost",unit=""} 100
+# HELP aws_billing_server_day_over_day_change Change of the billing metric given by the type label versus the previous day.
+# TYPE aws_billing_server_day_over_day_change gauge
+aws_billing_server_day_over_day_change{account_id="123456789012",currency="USD",type="BlendedCost",unit=""} 20
+# HELP aws_billing_server_day_over_day_change_percent Change of the billing metric given by the type label versus the previous day, in percent.
+# TYPE aws_billing_server_day_over_day_change_percent gauge
+aws_billing_server_day_over_day_change_percent{account_id="123456789012",currency="USD",type="BlendedCost",unit=""} 25
+# HELP aws_billing_server_estimated Whether the exported billing metrics are estimated and may still be revised by AWS (1) or final (0).
+# TYPE aws_billing_server_estimated gauge
+aws_billing_server_estimated{account_id="123456789012"} 1
+# HELP aws_billing_up Was the last scrape of aws billing successful.
+# TYPE aws_billing_up gauge
+aws_billing_up{account_id="123456789012"} 1
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected),
+		"aws_billing_server_blended_cost",
+		"aws_billing_server_day_over_day_change",
+		"aws_billing_server_day_over_day_change_percent",
+		"aws_billing_server_estimated",
+		"aws_billing_up",
+	); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunRate(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}, runRateDays: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return dailyCostAndUsage(time.Date(2019, 7, 1, 0, 0, 0, 0, time.Local), "10", "30", "30", "60"), nil
+	}
+
+	expected := `
+# HELP aws_billing_server_daily_run_rate Average daily value of the billing metric given by the type label over the last complete days given by the days label.
+# TYPE aws_billing_server_daily_run_rate gauge
+aws_billing_server_daily_run_rate{account_id="123456789012",currency="USD",days="3",type="BlendedCost",unit=""} 40
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "aws_billing_server_daily_run_rate"); err != nil {
+		t.Error(err)
+	}
+
+	if start, end := queryWindow(windowConfig{runRateDays: 14}); end.Sub(start) < 14*24*time.Hour-time.Hour {
+		t.Errorf("want the query window to cover the run rate days, got %s to %s", start, end)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}, historyDays: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return dailyCostAndUsage(time.Date(2019, 7, 1, 0, 0, 0, 0, time.Local), "10", "20", "30"), nil
+	}
+
+	expected := `
+# HELP aws_billing_server_daily_history Billing metric given by the type label on the complete day given by the date label.
+# TYPE aws_billing_server_daily_history gauge
+aws_billing_server_daily_history{account_id="123456789012",currency="USD",date="2019-07-02",type="BlendedCost",unit=""} 20
+aws_billing_server_daily_history{account_id="123456789012",currency="USD",date="2019-07-03",type="BlendedCost",unit=""} 30
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "aws_billing_server_daily_history"); err != nil {
+		t.Error(err)
+	}
+
+	if start, end := queryWindow(windowConfig{historyDays: 30}); end.Sub(start) < 30*24*time.Hour-time.Hour {
+		t.Errorf("want the query window to cover the history days, got %s to %s", start, end)
+	}
+}
+
+func TestMonthEndProjection(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}, projectMonthEnd: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return dailyCostAndUsage(time.Date(2019, 6, 29, 0, 0, 0, 0, time.Local), "100", "100", "10", "20"), nil
+	}
+
+	expected := `
+# HELP aws_billing_server_month_end_projection Billing metric given by the type label projected linearly to the end of the month from its month to date total.
+# TYPE aws_billing_server_month_end_projection gauge
+aws_billing_server_month_end_projection{account_id="123456789012",currency="USD",type="BlendedCost",unit=""} 465
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "aws_billing_server_month_end_projection"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTargetTimeout(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := &costexplorer.CostExplorer{}
+	targets := []*target{
+		{accountID: "111111111111", client: &costexplorer.CostExplorer{}},
+		{accountID: "222222222222", client: slow},
+		{accountID: "333333333333", client: &costexplorer.CostExplorer{}},
+	}
+	e, err := NewExporter(targets, metrics, exporterOptions{
+		subsystems:    []string{"server"},
+		concurrency:   2,
+		targetTimeout: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(ctx context.Context, tg *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		if tg.client == slow {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return costAndUsage("100"), nil
+	}
+
+	expected := `
+# HELP aws_billing_up Was the last scrape of aws billing successful.
+# TYPE aws_billing_up gauge
+aws_billing_up{account_id="111111111111"} 1
+aws_billing_up{account_id="222222222222"} 0
+aws_billing_up{account_id="333333333333"} 1
+# HELP aws_billing_collector_success Whether the collector given by the collector label succeeded for the account.
+# TYPE aws_billing_collector_success gauge
+aws_billing_collector_success{account_id="111111111111",collector="costusage"} 1
+aws_billing_collector_success{account_id="222222222222",collector="costusage"} 0
+aws_billing_collector_success{account_id="333333333333",collector="costusage"} 1
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "aws_billing_up", "aws_billing_collector_success"); err != nil {
+		t.Error(err)
+	}
+}
+
+// panicSink is a metric sink panicking on every push.
+type panicSink struct{}
+
+func (panicSink) name() string { return "panic" }
+
+func (panicSink) push([]*dto.MetricFamily, time.Time) error { panic("unexpected push") }
+
+func TestWarmUp(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ttl := range []time.Duration{0, time.Hour} {
+		e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}, cacheTTL: ttl})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fetches int
+		e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+			fetches++
+			return costAndUsage("100"), nil
+		}
+		warm := e.warmUp()
+		if ttl == 0 {
+			if warm || fetches != 0 {
+				t.Errorf("want no warm-up without a cache, got %d fetches", fetches)
+			}
+			continue
+		}
+		if !warm || fetches != 1 {
+			t.Errorf("want the cache warmed up with one fetch, got %t with %d fetches", warm, fetches)
+		}
+		if n := testutil.CollectAndCount(e, "aws_billing_server_blended_cost"); n != 1 || fetches != 1 {
+			t.Errorf("want the first collect served from the warm cache, got %d series after %d fetches", n, fetches)
+		}
+	}
+}
+
+func TestRefreshJitter(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}, cacheTTL: time.Hour, jitter: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		d := e.randomJitter()
+		if d < 0 || d >= time.Hour {
+			t.Fatalf("want a jitter below an hour, got %s", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Error("want random jitters")
+	}
+
+	var fetches int32
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		atomic.AddInt32(&fetches, 1)
+		return costAndUsage("100"), nil
+	}
+	e.refresh()
+	// The cache outlives the TTL by the jitter drawn for it.
+	e.mutex.Lock()
+	e.cacheJitter = 30 * time.Minute
+	e.cacheTime = time.Now().Add(-80 * time.Minute)
+	e.mutex.Unlock()
+	testutil.CollectAndCount(e)
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("want no refresh within the jitter, got %d fetches", n)
+	}
+	e.mutex.Lock()
+	e.cacheTime = time.Now().Add(-100 * time.Minute)
+	e.mutex.Unlock()
+	testutil.CollectAndCount(e)
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&fetches) < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("want a background refresh after the jitter, got %d fetches", n)
+	}
+}
+
+func TestConcurrentCollects(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	started, release := make(chan struct{}), make(chan struct{})
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return costAndUsage("100"), nil
+	}
+
+	counts := make([]int, 3)
+	var wg sync.WaitGroup
+	for i := range counts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			counts[i] = testutil.CollectAndCount(e, "aws_billing_server_blended_cost")
+		}(i)
+	}
+	<-started
+	// Give the other collects time to join the scrape in flight.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("want concurrent collects to share one scrape, got %d", n)
+	}
+	for i, n := range counts {
+		if n != 1 {
+			t.Errorf("collect %d: want the metrics of the shared scrape, got %d series", i, n)
+		}
+	}
+}
+
+func TestCollectPanic(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := []*target{{accountID: "111111111111"}, {accountID: "222222222222"}}
+	e, err := NewExporter(targets, metrics, exporterOptions{subsystems: []string{"server"}, cacheTTL: time.Hour, sinks: []metricSink{panicSink{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(ctx context.Context, tg *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		if tg == targets[1] {
+			// An unexpected empty response, dereferenced by the scrape.
+			return nil, nil
+		}
+		return costAndUsage("100"), nil
+	}
+	e.refresh()
+	for deadline := time.Now().Add(time.Second); testutil.ToFloat64(e.collectPanics) < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expected := `
+# HELP aws_billing_up Was the last scrape of aws billing successful.
+# TYPE aws_billing_up gauge
+aws_billing_up{account_id="111111111111"} 1
+aws_billing_up{account_id="222222222222"} 0
+# HELP aws_billing_exporter_collect_panics_total Panics recovered from while scraping accounts, running collectors, publishing metrics and running background tasks, e.g. on unexpected AWS responses.
+# TYPE aws_billing_exporter_collect_panics_total counter
+aws_billing_exporter_collect_panics_total 2
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "aws_billing_up", "aws_billing_exporter_collect_panics_total"); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(e.lastError, "panic") {
+		t.Errorf("want the panic recorded as the last error, got %q", e.lastError)
+	}
+}
+
+// blockingSink is a metric sink whose pushes wait for release.
+type blockingSink struct {
+	started chan struct{}
+	release chan struct{}
+	pushed  chan time.Time
+}
+
+func (blockingSink) name() string { return "blocking" }
+
+func (s blockingSink) push(_ []*dto.MetricFamily, t time.Time) error {
+	s.started <- struct{}{}
+	<-s.release
+	s.pushed <- t
+	return nil
+}
+
+func TestQueuePush(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := blockingSink{started: make(chan struct{}, 3), release: make(chan struct{}), pushed: make(chan time.Time, 3)}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}, sinks: []metricSink{s}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric := prometheus.MustNewConstMetric(e.upDesc, prometheus.GaugeValue, 1, "123456789012")
+	start := time.Unix(0, 0)
+	e.queuePush([]prometheus.Metric{metric}, start)
+	<-s.started
+	// These return right away although the sink is blocked, and the second
+	// replaces the first in the queue.
+	e.queuePush([]prometheus.Metric{metric}, start.Add(time.Minute))
+	e.queuePush([]prometheus.Metric{metric}, start.Add(2*time.Minute))
+	close(s.release)
+
+	var pushed []time.Time
+	for len(pushed) < 2 {
+		select {
+		case p := <-s.pushed:
+			pushed = append(pushed, p)
+		case <-time.After(time.Second):
+			t.Fatalf("want 2 pushes, got %v", pushed)
+		}
+	}
+	if !pushed[0].Equal(start) || !pushed[1].Equal(start.Add(2*time.Minute)) {
+		t.Errorf("want the first and the last refresh pushed, got %v", pushed)
+	}
+}
+
+// budgetsClient returns an AWS Budgets client whose calls are answered with
+// the given response, and a function to shut its server down.
+func budgetsClient(response string) (*budgets.Budgets, func()) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+	return budgets.New(sess), s.Close
+}
+
+func TestCollectorIsolation(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, shutdown := budgetsClient(`{"Budgets": [{"BudgetName": "total", "BudgetType": "COST", "TimeUnit": "MONTHLY", "BudgetLimit": {"Amount": "100", "Unit": "USD"}}]}`)
+	defer shutdown()
+	e, err := NewExporter([]*target{{accountID: "123456789012", budgets: client}}, metrics, exporterOptions{
+		subsystems: []string{"server"},
+		budgets:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return nil, errors.New("throttled")
+	}
+
+	// The budgets are still exported although the cost and usage query
+	// failed.
+	expected := `
+# HELP aws_billing_budget_limit Spend limit of the budget.
+# TYPE aws_billing_budget_limit gauge
+aws_billing_budget_limit{account_id="123456789012",budget_name="total",budget_type="COST",currency="USD",time_unit="MONTHLY",unit=""} 100
+# HELP aws_billing_collector_success Whether the collector given by the collector label succeeded for the account.
+# TYPE aws_billing_collector_success gauge
+aws_billing_collector_success{account_id="123456789012",collector="budgets"} 1
+aws_billing_collector_success{account_id="123456789012",collector="costusage"} 0
+# HELP aws_billing_up Was the last scrape of aws billing successful.
+# TYPE aws_billing_up gauge
+aws_billing_up{account_id="123456789012"} 0
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "aws_billing_budget_limit", "aws_billing_collector_success", "aws_billing_up"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{
+		subsystems: []string{"server"},
+		cacheTTL:   time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := make(chan struct{}, 2)
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		calls <- struct{}{}
+		return costAndUsage(strconv.Itoa(len(calls) * 100)), nil
+	}
+
+	expected := `
+# HELP aws_billing_server_blended_cost This cost metric reflects the average cost of usage across the consolidated billing family.
+# TYPE aws_billing_server_blended_cost gauge
+aws_billing_server_blended_cost{account_id="123456789012",currency="USD",type="BlendedCost",unit=""} 100
+`
+	for i := 0; i < 2; i++ {
+		if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "aws_billing_server_blended_cost"); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(calls) != 1 {
+		t.Fatalf("want 1 Cost Explorer call while the cache is fresh, got %d", len(calls))
+	}
+
+	e.mutex.Lock()
+	e.cacheTime = e.cacheTime.Add(-2 * time.Hour)
+	e.mutex.Unlock()
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "aws_billing_server_blended_cost"); err != nil {
+		t.Errorf("want stale metrics served while refreshing: %v", err)
+	}
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		e.mutex.RLock()
+		age := time.Since(e.cacheTime)
+		e.mutex.RUnlock()
+		if age < time.Hour {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("cache not refreshed in the background")
+		}
+	}
+	if len(calls) != 2 {
+		t.Errorf("want 2 Cost Explorer calls after the refresh, got %d", len(calls))
+	}
+}
+
+func TestOpenMetrics(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}, callBudget: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return costAndUsage("100"), nil
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, r)
+	body := w.Body.String()
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/openmetrics-text") {
+		t.Errorf("want OpenMetrics, got content type %q", w.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"# TYPE aws_billing_exporter_calls_skipped counter\naws_billing_exporter_calls_skipped_total 0.0\n",
+		"# EOF\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// remoteWriteBatch is the maximum number of samples per remote write
+// request.
+const remoteWriteBatch = 10000
+
+// timeSeries is a series of backfilled samples.
+type timeSeries struct {
+	name    string
+	help    string
+	typ     dto.MetricType
+	labels  []*dto.LabelPair
+	samples []sample
+}
+
+// id returns the name and labels of the series.
+func (s *timeSeries) id() string {
+	id := s.name + "{"
+	for _, l := range s.labels {
+		id += l.GetName() + "=" + l.GetValue() + ","
+	}
+	return id + "}"
+}
+
+type sample struct {
+	t     time.Time
+	value float64
+}
+
+// backfillWriter writes backfilled series.
+type backfillWriter interface {
+	write(series []*timeSeries) error
+}
+
+// backfill queries the daily billing metrics of the last months of all
+// targets and writes them as the series the exporter would have exported,
+// one sample per step during the day after each day, when its costs were
+// the latest complete ones.
+func (e *Exporter) backfill(months int, step time.Duration, w backfillWriter) error {
+	end := today()
+	start := end.AddDate(0, -months, 0)
+	var series []*timeSeries
+	for _, t := range e.targets {
+		ctx := context.Background()
+		accountID, err := t.AccountID(ctx)
+		if err != nil {
+			return fmt.Errorf("can't get AWS account ID: %v", err)
+		}
+		results, err := queryHistory(ctx, t, e.selected, start, end)
+		if err != nil {
+			return fmt.Errorf("can't query the billing history of account %s: %v", accountID, err)
+		}
+		s, err := e.backfillSeries(results, accountID, step, time.Now())
+		if err != nil {
+			return err
+		}
+		log.Infof("Backfilling %d series of account %s from %s to %s", len(s), accountID, start.Format(dateFormat), end.Format(dateFormat))
+		series = append(series, s...)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].id() < series[j].id() })
+	return w.write(series)
+}
+
+// backfillSeries returns the series exported for each of the results,
+// sampled every step during the following day up to now.
+func (e *Exporter) backfillSeries(results []*costexplorer.ResultByTime, accountID string, step time.Duration, now time.Time) ([]*timeSeries, error) {
+	byID := map[string]*timeSeries{}
+	var series []*timeSeries
+	for i, r := range results {
+		if r.TimePeriod == nil {
+			continue
+		}
+		from, err := time.ParseInLocation(dateFormat, aws.StringValue(r.TimePeriod.End), time.Local)
+		if err != nil {
+			continue
+		}
+
+		metrics := make(chan prometheus.Metric)
+		done := make(chan []prometheus.Metric)
+		go func() {
+			var all []prometheus.Metric
+			for m := range metrics {
+				all = append(all, m)
+			}
+			done <- all
+		}()
+		e.collectTotals(metrics, results[:i+1], nil, accountID)
+		close(metrics)
+		families, err := gather(<-done)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range families {
+			for _, m := range f.Metric {
+				v, ok := metricValue(m)
+				if !ok {
+					continue
+				}
+				s := &timeSeries{name: f.GetName(), help: f.GetHelp(), typ: f.GetType(), labels: m.Label}
+				if known, ok := byID[s.id()]; ok {
+					s = known
+				} else {
+					byID[s.id()] = s
+					series = append(series, s)
+				}
+				for t := from; t.Before(from.AddDate(0, 0, 1)) && !t.After(now); t = t.Add(step) {
+					s.samples = append(s.samples, sample{t: t, value: v})
+				}
+			}
+		}
+	}
+	return series, nil
+}
+
+// queryHistory returns the daily billing metrics between start and end,
+// following the pages of the response.
+func queryHistory(ctx context.Context, t *target, metrics []string, start, end time.Time) ([]*costexplorer.ResultByTime, error) {
+	input := &costexplorer.GetCostAndUsageInput{
+		Metrics:     aws.StringSlice(metrics),
+		Granularity: aws.String(costexplorer.GranularityDaily),
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(start.Format(dateFormat)),
+			End:   aws.String(end.Format(dateFormat)),
+		},
+	}
+	var results []*costexplorer.ResultByTime
+	for {
+		resp, err := t.client.GetCostAndUsageWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, resp.ResultsByTime...)
+		if aws.StringValue(resp.NextPageToken) == "" {
+			return results, nil
+		}
+		input.NextPageToken = resp.NextPageToken
+	}
+}
+
+// openMetricsWriter writes backfilled series in the OpenMetrics text format
+// with explicit timestamps, as read by promtool tsdb
+// create-blocks-from openmetrics.
+type openMetricsWriter struct {
+	w io.Writer
+}
+
+// write writes the series, which must be sorted by name so that the series
+// of a family are adjacent.
+func (w openMetricsWriter) write(series []*timeSeries) error {
+	b := bufio.NewWriter(w.w)
+	family := ""
+	for _, s := range series {
+		if s.name != family {
+			typ := "unknown"
+			if s.typ == dto.MetricType_GAUGE {
+				typ = "gauge"
+			}
+			fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", s.name, openMetricsEscaper.Replace(s.help), s.name, typ)
+			family = s.name
+		}
+		labels := make([]string, 0, len(s.labels))
+		for _, l := range s.labels {
+			labels = append(labels, fmt.Sprintf("%s=\"%s\"", l.GetName(), openMetricsEscaper.Replace(l.GetValue())))
+		}
+		name := s.name
+		if len(labels) > 0 {
+			name += "{" + strings.Join(labels, ",") + "}"
+		}
+		for _, smpl := range s.samples {
+			fmt.Fprintf(b, "%s %s %d\n", name, strconv.FormatFloat(smpl.value, 'g', -1, 64), smpl.t.Unix())
+		}
+	}
+	b.WriteString("# EOF\n")
+	return b.Flush()
+}
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// remoteWriter writes backfilled series to a Prometheus remote write
+// endpoint.
+type remoteWriter struct {
+	url    string
+	client *http.Client
+}
+
+func newRemoteWriter(url string) *remoteWriter {
+	return &remoteWriter{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// write sends the series in requests of up to remoteWriteBatch samples, the
+// samples of each series in order.
+func (w *remoteWriter) write(series []*timeSeries) error {
+	req := &prompb.WriteRequest{}
+	samples := 0
+	flush := func() error {
+		if samples == 0 {
+			return nil
+		}
+		err := w.send(req)
+		req, samples = &prompb.WriteRequest{}, 0
+		return err
+	}
+	for _, s := range series {
+		for rest := s.samples; len(rest) > 0; {
+			n := remoteWriteBatch - samples
+			if n > len(rest) {
+				n = len(rest)
+			}
+			req.Timeseries = append(req.Timeseries, remoteTimeSeries(s, rest[:n]))
+			rest = rest[n:]
+			if samples += n; samples == remoteWriteBatch {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return flush()
+}
+
+// send posts a WriteRequest.
+func (w *remoteWriter) send(req *prompb.WriteRequest) error {
+	data, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "aws_billing_exporter/"+version.Version)
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := w.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// remoteTimeSeries returns the TimeSeries message with the given samples of
+// a series. Its labels, including the metric name, are sorted by name.
+func remoteTimeSeries(s *timeSeries, samples []sample) *prompb.TimeSeries {
+	ts := &prompb.TimeSeries{Labels: []*prompb.Label{{Name: "__name__", Value: s.name}}}
+	for _, l := range s.labels {
+		ts.Labels = append(ts.Labels, &prompb.Label{Name: l.GetName(), Value: l.GetValue()})
+	}
+	sort.Slice(ts.Labels, func(i, j int) bool { return ts.Labels[i].Name < ts.Labels[j].Name })
+	for _, smpl := range samples {
+		ts.Samples = append(ts.Samples, prompb.Sample{Value: smpl.value, Timestamp: smpl.t.UnixNano() / int64(time.Millisecond)})
+	}
+	return ts
+}
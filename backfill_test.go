@@ -0,0 +1,139 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestBackfillSeries(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2019, 7, 1, 0, 0, 0, 0, time.Local)
+	now := start.AddDate(0, 0, 2).Add(13 * time.Hour)
+	series, err := e.backfillSeries(dailyCostAndUsage(start, "10", "20").ResultsByTime, "123456789012", 12*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cost *timeSeries
+	for _, s := range series {
+		if s.name == "aws_billing_server_blended_cost" {
+			cost = s
+		}
+	}
+	if cost == nil {
+		t.Fatalf("want a blended cost series, got %d other series", len(series))
+	}
+	want := []sample{
+		{start.AddDate(0, 0, 1), 10},
+		{start.AddDate(0, 0, 1).Add(12 * time.Hour), 10},
+		{start.AddDate(0, 0, 2), 20},
+		{start.AddDate(0, 0, 2).Add(12 * time.Hour), 20},
+	}
+	if len(cost.samples) != len(want) {
+		t.Fatalf("want %d samples, got %v", len(want), cost.samples)
+	}
+	for i, s := range cost.samples {
+		if !s.t.Equal(want[i].t) || s.value != want[i].value {
+			t.Errorf("want sample %d to be %v, got %v", i, want[i], s)
+		}
+	}
+}
+
+func TestRemoteWriter(t *testing.T) {
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, b)
+	}))
+	defer server.Close()
+
+	w := newRemoteWriter(server.URL)
+	if err := w.write([]*timeSeries{{name: "a", samples: []sample{{time.Unix(0, int64(time.Millisecond)), 1}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("want 1 request, got %d", len(bodies))
+	}
+	data, err := snappy.Decode(nil, bodies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	want := prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+		Labels:  []*prompb.Label{{Name: "__name__", Value: "a"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("want request %v, got %v", want, req)
+	}
+
+	bodies = nil
+	samples := make([]sample, remoteWriteBatch+1)
+	if err := w.write([]*timeSeries{{name: "a", samples: samples}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Errorf("want the samples split into 2 requests, got %d", len(bodies))
+	}
+}
+
+func TestOpenMetricsWriter(t *testing.T) {
+	series := []*timeSeries{
+		{name: "aws_billing_server_blended_cost", help: "Blended cost.", typ: dto.MetricType_GAUGE, labels: []*dto.LabelPair{{Name: aws.String("account_id"), Value: aws.String("123456789012")}}, samples: []sample{{time.Unix(1561939200, 0), 10}, {time.Unix(1561939500, 0), 10.5}}},
+		{name: "aws_billing_server_blended_cost", help: "Blended cost.", typ: dto.MetricType_GAUGE, labels: []*dto.LabelPair{{Name: aws.String("account_id"), Value: aws.String("210987654321")}}, samples: []sample{{time.Unix(1561939200, 0), 1}}},
+		{name: "aws_billing_server_estimated", help: "Whether \"estimated\".", typ: dto.MetricType_GAUGE, samples: []sample{{time.Unix(1561939200, 0), 1}}},
+	}
+	var b bytes.Buffer
+	if err := (openMetricsWriter{&b}).write(series); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP aws_billing_server_blended_cost Blended cost.
+# TYPE aws_billing_server_blended_cost gauge
+aws_billing_server_blended_cost{account_id="123456789012"} 10 1561939200
+aws_billing_server_blended_cost{account_id="123456789012"} 10.5 1561939500
+aws_billing_server_blended_cost{account_id="210987654321"} 1 1561939200
+# HELP aws_billing_server_estimated Whether \"estimated\".
+# TYPE aws_billing_server_estimated gauge
+aws_billing_server_estimated 1 1561939200
+# EOF
+`
+	if b.String() != want {
+		t.Errorf("want\n%s\ngot\n%s", want, b.String())
+	}
+}
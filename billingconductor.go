@@ -0,0 +1,158 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/billingconductor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var billingGroupLabelNames = []string{"account_id", "billing_group"}
+
+// billingConductorCollector exports the billing groups of AWS Billing
+// Conductor with their pro forma cost and margin in the current billing
+// period, and the custom line items applied to them, so that resellers and
+// internal chargeback setups can watch the rates they bill.
+type billingConductorCollector struct {
+	info          *prometheus.Desc
+	accounts      *prometheus.Desc
+	awsCost       *prometheus.Desc
+	proformaCost  *prometheus.Desc
+	margin        *prometheus.Desc
+	marginPercent *prometheus.Desc
+	charge        *prometheus.Desc
+	chargePercent *prometheus.Desc
+}
+
+func newBillingConductorCollector(layout labelLayout, constLabels prometheus.Labels) *billingConductorCollector {
+	lineItemLabelNames := []string{"account_id", "custom_line_item", "billing_group", "type"}
+	return &billingConductorCollector{
+		info: newInfoDesc("billing_group",
+			"Billing group of AWS Billing Conductor.",
+			concatLabels(billingGroupLabelNames, "arn", "primary_account_id", "pricing_plan_arn", "status"), constLabels),
+		accounts: prometheus.NewDesc(prometheus.BuildFQName(namespace, "billing_group", "accounts"),
+			"Number of accounts in the billing group.", billingGroupLabelNames, constLabels),
+		awsCost: prometheus.NewDesc(prometheus.BuildFQName(namespace, "billing_group", "aws_cost"),
+			"Cost of the billing group at AWS rates in the current billing period.", concatLabels(billingGroupLabelNames, layout.currency()), constLabels),
+		proformaCost: prometheus.NewDesc(prometheus.BuildFQName(namespace, "billing_group", "proforma_cost"),
+			"Cost of the billing group at the rates of its pricing plan in the current billing period.", concatLabels(billingGroupLabelNames, layout.currency()), constLabels),
+		margin: prometheus.NewDesc(prometheus.BuildFQName(namespace, "billing_group", "margin"),
+			"Pro forma cost minus AWS cost of the billing group in the current billing period.", concatLabels(billingGroupLabelNames, layout.currency()), constLabels),
+		marginPercent: prometheus.NewDesc(prometheus.BuildFQName(namespace, "billing_group", "margin_percent"),
+			"Margin of the billing group relative to its AWS cost in the current billing period.", billingGroupLabelNames, constLabels),
+		charge: prometheus.NewDesc(prometheus.BuildFQName(namespace, "custom_line_item", "charge"),
+			"Flat charge of the custom line item in the current billing period.", concatLabels(lineItemLabelNames, layout.currency()), constLabels),
+		chargePercent: prometheus.NewDesc(prometheus.BuildFQName(namespace, "custom_line_item", "charge_percent"),
+			"Percentage charge of the custom line item in the current billing period.", lineItemLabelNames, constLabels),
+	}
+}
+
+func (c *billingConductorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	ch <- c.accounts
+	ch <- c.awsCost
+	ch <- c.proformaCost
+	ch <- c.margin
+	ch <- c.marginPercent
+	ch <- c.charge
+	ch <- c.chargePercent
+}
+
+// update exports the billing groups and custom line items of the target's
+// account, which must be the payer account of the billing family.
+func (c *billingConductorCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) error {
+	var groups []*billingconductor.BillingGroupListElement
+	err := t.billingConductor.ListBillingGroupsPagesWithContext(ctx, &billingconductor.ListBillingGroupsInput{},
+		func(resp *billingconductor.ListBillingGroupsOutput, _ bool) bool {
+			groups = append(groups, resp.BillingGroups...)
+			return true
+		})
+	if err != nil {
+		return err
+	}
+	var reports []*billingconductor.BillingGroupCostReportElement
+	err = t.billingConductor.ListBillingGroupCostReportsPagesWithContext(ctx, &billingconductor.ListBillingGroupCostReportsInput{},
+		func(resp *billingconductor.ListBillingGroupCostReportsOutput, _ bool) bool {
+			reports = append(reports, resp.BillingGroupCostReports...)
+			return true
+		})
+	if err != nil {
+		return err
+	}
+	var items []*billingconductor.CustomLineItemListElement
+	err = t.billingConductor.ListCustomLineItemsPagesWithContext(ctx, &billingconductor.ListCustomLineItemsInput{},
+		func(resp *billingconductor.ListCustomLineItemsOutput, _ bool) bool {
+			items = append(items, resp.CustomLineItems...)
+			return true
+		})
+	if err != nil {
+		return err
+	}
+	c.collect(ch, groups, reports, items, accountID)
+	return nil
+}
+
+func (c *billingConductorCollector) collect(ch chan<- prometheus.Metric, groups []*billingconductor.BillingGroupListElement, reports []*billingconductor.BillingGroupCostReportElement, items []*billingconductor.CustomLineItemListElement, accountID string) {
+	names := make(map[string]string, len(groups))
+	for _, g := range groups {
+		name := aws.StringValue(g.Name)
+		names[aws.StringValue(g.Arn)] = name
+		var pricingPlanARN string
+		if g.ComputationPreference != nil {
+			pricingPlanARN = aws.StringValue(g.ComputationPreference.PricingPlanArn)
+		}
+		ch <- newInfoMetric(c.info,
+			accountID, name, aws.StringValue(g.Arn), aws.StringValue(g.PrimaryAccountId), pricingPlanARN, aws.StringValue(g.Status))
+		if g.Size != nil {
+			ch <- prometheus.MustNewConstMetric(c.accounts, prometheus.GaugeValue, float64(*g.Size), accountID, name)
+		}
+	}
+
+	for _, r := range reports {
+		name, ok := names[aws.StringValue(r.Arn)]
+		if !ok {
+			continue
+		}
+		unit := aws.StringValue(r.Currency)
+		for _, m := range []struct {
+			desc  *prometheus.Desc
+			value *string
+		}{{c.awsCost, r.AWSCost}, {c.proformaCost, r.ProformaCost}, {c.margin, r.Margin}} {
+			if f, err := strconv.ParseFloat(aws.StringValue(m.value), 64); err == nil {
+				ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, f, accountID, name, unit)
+			}
+		}
+		if f, err := strconv.ParseFloat(aws.StringValue(r.MarginPercentage), 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.marginPercent, prometheus.GaugeValue, f, accountID, name)
+		}
+	}
+
+	for _, i := range items {
+		d := i.ChargeDetails
+		if d == nil {
+			continue
+		}
+		labels := []string{accountID, aws.StringValue(i.Name), names[aws.StringValue(i.BillingGroupArn)], aws.StringValue(d.Type)}
+		if d.Flat != nil && d.Flat.ChargeValue != nil {
+			ch <- prometheus.MustNewConstMetric(c.charge, prometheus.GaugeValue, *d.Flat.ChargeValue, concatLabels(labels, aws.StringValue(i.CurrencyCode))...)
+		}
+		if d.Percentage != nil && d.Percentage.PercentageValue != nil {
+			ch <- prometheus.MustNewConstMetric(c.chargePercent, prometheus.GaugeValue, *d.Percentage.PercentageValue, labels...)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/billingconductor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBillingConductor(t *testing.T) {
+	c := newBillingConductorCollector(labelLayout{}, nil)
+	ch := make(chan prometheus.Metric, 10)
+	groupARN := "arn:aws:billingconductor::123456789012:billinggroup/123456789013"
+	c.collect(ch, []*billingconductor.BillingGroupListElement{{
+		Arn:                   aws.String(groupARN),
+		Name:                  aws.String("acme"),
+		PrimaryAccountId:      aws.String("123456789013"),
+		ComputationPreference: &billingconductor.ComputationPreference{PricingPlanArn: aws.String("arn:aws:billingconductor::123456789012:pricingplan/a1b2c3")},
+		Size:                  aws.Int64(3),
+		Status:                aws.String(billingconductor.BillingGroupStatusActive),
+	}}, []*billingconductor.BillingGroupCostReportElement{{
+		Arn:              aws.String(groupARN),
+		AWSCost:          aws.String("100.00"),
+		ProformaCost:     aws.String("110.00"),
+		Margin:           aws.String("10.00"),
+		MarginPercentage: aws.String("10.0"),
+		Currency:         aws.String("USD"),
+	}, {
+		Arn:     aws.String("arn:aws:billingconductor::123456789012:billinggroup/gone"),
+		AWSCost: aws.String("1"),
+	}}, []*billingconductor.CustomLineItemListElement{{
+		Name:            aws.String("support fee"),
+		BillingGroupArn: aws.String(groupARN),
+		CurrencyCode:    aws.String(billingconductor.CurrencyCodeUsd),
+		ChargeDetails: &billingconductor.ListCustomLineItemChargeDetails{
+			Type: aws.String(billingconductor.CustomLineItemTypeFee),
+			Flat: &billingconductor.ListCustomLineItemFlatChargeDetails{ChargeValue: aws.Float64(50)},
+		},
+	}, {
+		Name:            aws.String("discount"),
+		BillingGroupArn: aws.String(groupARN),
+		ChargeDetails: &billingconductor.ListCustomLineItemChargeDetails{
+			Type:       aws.String(billingconductor.CustomLineItemTypeCredit),
+			Percentage: &billingconductor.ListCustomLineItemPercentageChargeDetails{PercentageValue: aws.Float64(5)},
+		},
+	}}, "123456789012")
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	expected := `
+# HELP aws_billing_billing_group_accounts Number of accounts in the billing group.
+# TYPE aws_billing_billing_group_accounts gauge
+aws_billing_billing_group_accounts{account_id="123456789012",billing_group="acme"} 3
+# HELP aws_billing_billing_group_aws_cost Cost of the billing group at AWS rates in the current billing period.
+# TYPE aws_billing_billing_group_aws_cost gauge
+aws_billing_billing_group_aws_cost{account_id="123456789012",billing_group="acme",currency="USD"} 100
+# HELP aws_billing_billing_group_info Billing group of AWS Billing Conductor.
+# TYPE aws_billing_billing_group_info gauge
+aws_billing_billing_group_info{account_id="123456789012",arn="arn:aws:billingconductor::123456789012:billinggroup/123456789013",billing_group="acme",pricing_plan_arn="arn:aws:billingconductor::123456789012:pricingplan/a1b2c3",primary_account_id="123456789013",status="ACTIVE"} 1
+# HELP aws_billing_billing_group_margin Pro forma cost minus AWS cost of the billing group in the current billing period.
+# TYPE aws_billing_billing_group_margin gauge
+aws_billing_billing_group_margin{account_id="123456789012",billing_group="acme",currency="USD"} 10
+# HELP aws_billing_billing_group_margin_percent Margin of the billing group relative to its AWS cost in the current billing period.
+# TYPE aws_billing_billing_group_margin_percent gauge
+aws_billing_billing_group_margin_percent{account_id="123456789012",billing_group="acme"} 10
+# HELP aws_billing_billing_group_proforma_cost Cost of the billing group at the rates of its pricing plan in the current billing period.
+# TYPE aws_billing_billing_group_proforma_cost gauge
+aws_billing_billing_group_proforma_cost{account_id="123456789012",billing_group="acme",currency="USD"} 110
+# HELP aws_billing_custom_line_item_charge Flat charge of the custom line item in the current billing period.
+# TYPE aws_billing_custom_line_item_charge gauge
+aws_billing_custom_line_item_charge{account_id="123456789012",billing_group="acme",currency="USD",custom_line_item="support fee",type="FEE"} 50
+# HELP aws_billing_custom_line_item_charge_percent Percentage charge of the custom line item in the current billing period.
+# TYPE aws_billing_custom_line_item_charge_percent gauge
+aws_billing_custom_line_item_charge_percent{account_id="123456789012",billing_group="acme",custom_line_item="discount",type="CREDIT"} 5
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
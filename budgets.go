@@ -0,0 +1,105 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/budgets"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var budgetLabelNames = []string{"account_id", "budget_name", "budget_type", "time_unit"}
+
+// budgetsCollector exports the limits and spend of the AWS Budgets defined
+// in each target's account. Cost budgets are labeled with their currency
+// and usage budgets with their unit, as laid out by layout.
+type budgetsCollector struct {
+	layout labelLayout
+
+	limit      *prometheus.Desc
+	actual     *prometheus.Desc
+	forecasted *prometheus.Desc
+}
+
+func newBudgetsCollector(layout labelLayout, constLabels prometheus.Labels) *budgetsCollector {
+	labelNames := concatLabels(budgetLabelNames, layout.unitNames()...)
+	return &budgetsCollector{
+		layout: layout,
+		limit: prometheus.NewDesc(prometheus.BuildFQName(namespace, "budget", "limit"),
+			"Spend limit of the budget.", labelNames, constLabels),
+		actual: prometheus.NewDesc(prometheus.BuildFQName(namespace, "budget", "actual_spend"),
+			"Actual spend of the budget in its current period.", labelNames, constLabels),
+		forecasted: prometheus.NewDesc(prometheus.BuildFQName(namespace, "budget", "forecasted_spend"),
+			"Spend of the budget forecasted by AWS for its current period.", labelNames, constLabels),
+	}
+}
+
+func (c *budgetsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.limit
+	ch <- c.actual
+	ch <- c.forecasted
+}
+
+// update exports the budgets of the target's account and returns them.
+func (c *budgetsCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) ([]*budgets.Budget, error) {
+	var all []*budgets.Budget
+	input := &budgets.DescribeBudgetsInput{AccountId: aws.String(accountID)}
+	for {
+		resp, err := t.budgets.DescribeBudgetsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, resp.Budgets...)
+		if aws.StringValue(resp.NextToken) == "" {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	for _, b := range all {
+		if b.BudgetLimit == nil {
+			continue
+		}
+		name, budgetType, timeUnit := aws.StringValue(b.BudgetName), aws.StringValue(b.BudgetType), aws.StringValue(b.TimeUnit)
+		c.collectSpend(ch, c.limit, b.BudgetLimit, accountID, name, budgetType, timeUnit)
+		if b.CalculatedSpend == nil {
+			continue
+		}
+		c.collectSpend(ch, c.actual, b.CalculatedSpend.ActualSpend, accountID, name, budgetType, timeUnit)
+		c.collectSpend(ch, c.forecasted, b.CalculatedSpend.ForecastedSpend, accountID, name, budgetType, timeUnit)
+	}
+	return all, nil
+}
+
+func (c *budgetsCollector) collectSpend(ch chan<- prometheus.Metric, desc *prometheus.Desc, s *budgets.Spend, accountID, name, budgetType, timeUnit string) {
+	amount, ok := spendAmount(s)
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, amount,
+		concatLabels([]string{accountID, name, budgetType, timeUnit}, c.layout.unitValues(aws.StringValue(s.Unit))...)...)
+}
+
+// spendAmount parses the amount of a budget spend. ok is false if it is
+// missing or malformed.
+func spendAmount(s *budgets.Spend) (float64, bool) {
+	if s == nil || s.Amount == nil {
+		return 0, false
+	}
+	amount, err := strconv.ParseFloat(*s.Amount, 64)
+	return amount, err == nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// seriesLimiter caps the number of series exported per breakdown metric
+// family, so that a tag with unexpectedly many values can't overwhelm
+// Prometheus. Once a family is full, new label combinations are dropped and
+// counted, while the series it already exported keep being exported. Health
+// and info metrics are never dropped.
+type seriesLimiter struct {
+	mutex sync.Mutex
+	max   int
+	// admitted are the series exported in the last refresh by family.
+	admitted map[string]map[string]bool
+	dropped  prometheus.Counter
+}
+
+func newSeriesLimiter(max int, constLabels prometheus.Labels) *seriesLimiter {
+	return &seriesLimiter{
+		max:      max,
+		admitted: map[string]map[string]bool{},
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "series_dropped_total",
+			Help:        "Series not exported because their metric family reached the maximum number of series.",
+			ConstLabels: constLabels,
+		}),
+	}
+}
+
+// filter returns the metrics of a refresh within the limit, which applies to
+// the given families only. Series exported in the last refresh are kept
+// first, so that the same series stay exported as long as they have values.
+func (l *seriesLimiter) filter(metrics []prometheus.Metric, limited map[string]bool) []prometheus.Metric {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	families := make([]string, len(metrics))
+	ids := make([]string, len(metrics))
+	keep := make([]bool, len(metrics))
+	admitted := map[string]map[string]bool{}
+	admit := func(i int) {
+		if admitted[families[i]] == nil {
+			admitted[families[i]] = map[string]bool{}
+		}
+		admitted[families[i]][ids[i]] = true
+		keep[i] = true
+	}
+	for i, m := range metrics {
+		families[i] = m.Desc().String()
+		if !limited[families[i]] {
+			keep[i] = true
+			continue
+		}
+		ids[i] = labelsID(m)
+		if l.admitted[families[i]][ids[i]] {
+			admit(i)
+		}
+	}
+
+	dropped := map[string]int{}
+	for i := range metrics {
+		switch {
+		case keep[i], admitted[families[i]][ids[i]]:
+			keep[i] = true
+		case len(admitted[families[i]]) < l.max:
+			admit(i)
+		default:
+			dropped[families[i]]++
+		}
+	}
+	for family, n := range dropped {
+		log.Warnf("Dropped %d series exceeding the maximum of %d series of %s", n, l.max, family)
+		l.dropped.Add(float64(n))
+	}
+	l.admitted = admitted
+
+	kept := metrics[:0:0]
+	for i, m := range metrics {
+		if keep[i] {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// breakdownFamilies returns the metric families of the presets, tag and cost
+// category breakdowns and query jobs, which the series limit applies to.
+func (e *Exporter) breakdownFamilies() map[string]bool {
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		defer close(descs)
+		for _, p := range e.presets {
+			p.Describe(descs)
+		}
+		for _, b := range e.tagBreakdowns {
+			b.Describe(descs)
+		}
+		for _, j := range e.currentJobs() {
+			j.Describe(descs)
+		}
+	}()
+	families := map[string]bool{}
+	for d := range descs {
+		families[d.String()] = true
+	}
+	return families
+}
+
+// labelsID returns the sorted labels of a metric's series.
+func labelsID(m prometheus.Metric) string {
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		return ""
+	}
+	labels := make([]string, 0, len(out.Label))
+	for _, l := range out.Label {
+		labels = append(labels, l.GetName()+"="+l.GetValue())
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
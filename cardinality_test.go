@@ -0,0 +1,67 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSeriesLimiter(t *testing.T) {
+	desc := prometheus.NewDesc("aws_billing_team_last_day", "Cost by team.", []string{"team"}, nil)
+	upDesc := prometheus.NewDesc("aws_billing_up", "Up.", []string{"account_id"}, nil)
+	l := newSeriesLimiter(2, nil)
+	refresh := func(teams ...string) metricSlice {
+		var metrics []prometheus.Metric
+		for _, accountID := range []string{"123456789012", "210987654321", "111111111111"} {
+			metrics = append(metrics, prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1, accountID))
+		}
+		for _, team := range teams {
+			metrics = append(metrics, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, team))
+		}
+		return metricSlice(l.filter(metrics, map[string]bool{desc.String(): true}))
+	}
+
+	if n := testutil.CollectAndCount(refresh("a", "b", "c"), "aws_billing_team_last_day"); n != 2 {
+		t.Errorf("want 2 breakdown series, got %d", n)
+	}
+	if n := testutil.CollectAndCount(refresh("a", "b"), "aws_billing_up"); n != 3 {
+		t.Errorf("want all 3 up series kept, got %d", n)
+	}
+	expected := `
+# HELP aws_billing_team_last_day Cost by team.
+# TYPE aws_billing_team_last_day gauge
+aws_billing_team_last_day{team="a"} 1
+aws_billing_team_last_day{team="b"} 1
+`
+	if err := testutil.CollectAndCompare(refresh("d", "b", "a"), strings.NewReader(expected), "aws_billing_team_last_day"); err != nil {
+		t.Errorf("want the series of the last refresh kept: %v", err)
+	}
+	if v := testutil.ToFloat64(l.dropped); v != 2 {
+		t.Errorf("want 2 dropped series, got %v", v)
+	}
+
+	expected = `
+# HELP aws_billing_team_last_day Cost by team.
+# TYPE aws_billing_team_last_day gauge
+aws_billing_team_last_day{team="a"} 1
+aws_billing_team_last_day{team="d"} 1
+`
+	if err := testutil.CollectAndCompare(refresh("d", "a"), strings.NewReader(expected), "aws_billing_team_last_day"); err != nil {
+		t.Errorf("want new series admitted once others disappeared: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// cloudWatchBatchSize is the maximum number of data points per
+	// PutMetricData call.
+	cloudWatchBatchSize = 20
+	// cloudWatchMaxDimensions is the maximum number of dimensions of a
+	// CloudWatch metric.
+	cloudWatchMaxDimensions = 10
+)
+
+// cloudWatchSink publishes metrics as CloudWatch custom metrics, named like
+// the Prometheus metrics and with their labels as dimensions.
+type cloudWatchSink struct {
+	namespace string
+	client    *cloudwatch.CloudWatch
+	put       func(*cloudwatch.PutMetricDataInput) error
+}
+
+// newCloudWatchSink returns a sink publishing to the given namespace, in the
+// session's region if region is empty.
+func newCloudWatchSink(sess *session.Session, namespace, region string) *cloudWatchSink {
+	cfg := aws.NewConfig()
+	if region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+	s := &cloudWatchSink{namespace: namespace, client: cloudwatch.New(sess, cfg)}
+	s.put = func(input *cloudwatch.PutMetricDataInput) error {
+		_, err := s.client.PutMetricData(input)
+		return err
+	}
+	return s
+}
+
+func (s *cloudWatchSink) name() string {
+	return "CloudWatch namespace " + s.namespace
+}
+
+func (s *cloudWatchSink) push(families []*dto.MetricFamily, t time.Time) error {
+	var data []*cloudwatch.MetricDatum
+	for _, f := range families {
+		for _, m := range f.Metric {
+			value, ok := metricValue(m)
+			if !ok {
+				continue
+			}
+			datum := &cloudwatch.MetricDatum{
+				MetricName: f.Name,
+				Timestamp:  aws.Time(t),
+				Value:      aws.Float64(value),
+				Unit:       aws.String(cloudwatch.StandardUnitNone),
+			}
+			for _, l := range m.Label {
+				if l.GetValue() != "" {
+					datum.Dimensions = append(datum.Dimensions, &cloudwatch.Dimension{Name: l.Name, Value: l.Value})
+				}
+			}
+			// Dropping dimensions would merge distinct series.
+			if len(datum.Dimensions) > cloudWatchMaxDimensions {
+				continue
+			}
+			data = append(data, datum)
+		}
+	}
+
+	for len(data) > 0 {
+		n := len(data)
+		if n > cloudWatchBatchSize {
+			n = cloudWatchBatchSize
+		}
+		err := s.put(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(s.namespace),
+			MetricData: data[:n],
+		})
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCloudWatchSink(t *testing.T) {
+	desc := prometheus.NewDesc("aws_billing_blended_cost", "Blended cost.", []string{"type", "unit", "account_id"}, prometheus.Labels{"team": ""})
+	var metrics []prometheus.Metric
+	for i := 0; i < 25; i++ {
+		metrics = append(metrics, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(i), "BlendedCost", "USD", string('a'+rune(i))))
+	}
+	families, err := gather(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var inputs []*cloudwatch.PutMetricDataInput
+	s := &cloudWatchSink{namespace: "AWSBilling"}
+	s.put = func(input *cloudwatch.PutMetricDataInput) error {
+		inputs = append(inputs, input)
+		return nil
+	}
+	now := time.Now()
+	if err := s.push(families, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inputs) != 2 || len(inputs[0].MetricData) != 20 || len(inputs[1].MetricData) != 5 {
+		t.Fatalf("want batches of 20 and 5 data points, got %d batches", len(inputs))
+	}
+	d := inputs[0].MetricData[1]
+	if aws.StringValue(inputs[0].Namespace) != "AWSBilling" || aws.StringValue(d.MetricName) != "aws_billing_blended_cost" || aws.Float64Value(d.Value) != 1 || !aws.TimeValue(d.Timestamp).Equal(now) {
+		t.Errorf("unexpected data point %v", d)
+	}
+	if len(d.Dimensions) != 3 || aws.StringValue(d.Dimensions[0].Name) != "account_id" || aws.StringValue(d.Dimensions[0].Value) != "b" {
+		t.Errorf("want dimensions account_id, type and unit, got %v", d.Dimensions)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/computeoptimizer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// computeOptimizerCollector exports the recommendation summaries of AWS
+// Compute Optimizer for each target's account, per resource type. The
+// account must be opted in to Compute Optimizer.
+type computeOptimizerCollector struct {
+	savings        *prometheus.Desc
+	savingsPercent *prometheus.Desc
+	resources      *prometheus.Desc
+}
+
+func newComputeOptimizerCollector(layout labelLayout, constLabels prometheus.Labels) *computeOptimizerCollector {
+	return &computeOptimizerCollector{
+		savings: prometheus.NewDesc(prometheus.BuildFQName(namespace, "compute_optimizer", "estimated_monthly_savings"),
+			"Monthly savings estimated by Compute Optimizer if its recommendations for the resource type are applied.",
+			[]string{"account_id", "resource_type", layout.currency()}, constLabels),
+		savingsPercent: prometheus.NewDesc(prometheus.BuildFQName(namespace, "compute_optimizer", "savings_opportunity_percent"),
+			"Estimated monthly savings as a percentage of the cost of the resource type.",
+			[]string{"account_id", "resource_type"}, constLabels),
+		resources: prometheus.NewDesc(prometheus.BuildFQName(namespace, "compute_optimizer", "resources"),
+			"Number of resources of the resource type by Compute Optimizer finding, e.g. Overprovisioned.",
+			[]string{"account_id", "resource_type", "finding"}, constLabels),
+	}
+}
+
+func (c *computeOptimizerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.savings
+	ch <- c.savingsPercent
+	ch <- c.resources
+}
+
+// update exports the recommendation summaries of the target's account.
+func (c *computeOptimizerCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) error {
+	var summaries []*computeoptimizer.RecommendationSummary
+	err := t.computeOptimizer.GetRecommendationSummariesPagesWithContext(ctx, &computeoptimizer.GetRecommendationSummariesInput{},
+		func(resp *computeoptimizer.GetRecommendationSummariesOutput, lastPage bool) bool {
+			summaries = append(summaries, resp.RecommendationSummaries...)
+			return true
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range summaries {
+		resourceType := aws.StringValue(s.RecommendationResourceType)
+		for _, f := range s.Summaries {
+			ch <- prometheus.MustNewConstMetric(c.resources, prometheus.GaugeValue, aws.Float64Value(f.Value), accountID, resourceType, aws.StringValue(f.Name))
+		}
+		if s.SavingsOpportunity == nil {
+			continue
+		}
+		if m := s.SavingsOpportunity.EstimatedMonthlySavings; m != nil && m.Value != nil {
+			ch <- prometheus.MustNewConstMetric(c.savings, prometheus.GaugeValue, *m.Value, accountID, resourceType, aws.StringValue(m.Currency))
+		}
+		if p := s.SavingsOpportunity.SavingsOpportunityPercentage; p != nil {
+			ch <- prometheus.MustNewConstMetric(c.savingsPercent, prometheus.GaugeValue, *p, accountID, resourceType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/computeoptimizer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestComputeOptimizer(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(b), `"nextToken":"2"`) {
+			w.Write([]byte(`{"nextToken": "2", "recommendationSummaries": [{"recommendationResourceType": "Ec2Instance",
+				"summaries": [{"name": "Overprovisioned", "value": 4}, {"name": "Optimized", "value": 10}],
+				"savingsOpportunity": {"savingsOpportunityPercentage": 12.5, "estimatedMonthlySavings": {"currency": "USD", "value": 80}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"recommendationSummaries": [{"recommendationResourceType": "LambdaFunction",
+			"summaries": [{"name": "NotOptimized", "value": 2}]}]}`))
+	}))
+	defer s.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+
+	c := newComputeOptimizerCollector(labelLayout{}, nil)
+	ch := make(chan prometheus.Metric, 8)
+	if err := c.update(context.Background(), ch, &target{computeOptimizer: computeoptimizer.New(sess)}, "123456789012"); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	expected := `
+# HELP aws_billing_compute_optimizer_estimated_monthly_savings Monthly savings estimated by Compute Optimizer if its recommendations for the resource type are applied.
+# TYPE aws_billing_compute_optimizer_estimated_monthly_savings gauge
+aws_billing_compute_optimizer_estimated_monthly_savings{account_id="123456789012",currency="USD",resource_type="Ec2Instance"} 80
+# HELP aws_billing_compute_optimizer_resources Number of resources of the resource type by Compute Optimizer finding, e.g. Overprovisioned.
+# TYPE aws_billing_compute_optimizer_resources gauge
+aws_billing_compute_optimizer_resources{account_id="123456789012",finding="NotOptimized",resource_type="LambdaFunction"} 2
+aws_billing_compute_optimizer_resources{account_id="123456789012",finding="Optimized",resource_type="Ec2Instance"} 10
+aws_billing_compute_optimizer_resources{account_id="123456789012",finding="Overprovisioned",resource_type="Ec2Instance"} 4
+# HELP aws_billing_compute_optimizer_savings_opportunity_percent Estimated monthly savings as a percentage of the cost of the resource type.
+# TYPE aws_billing_compute_optimizer_savings_opportunity_percent gauge
+aws_billing_compute_optimizer_savings_opportunity_percent{account_id="123456789012",resource_type="Ec2Instance"} 12.5
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,474 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// config is the configuration file given by --config.file.
+type config struct {
+	Jobs []jobConfig `yaml:"jobs"`
+
+	// The secrets below stand in for the flags of the same names, which
+	// must not be given as well. They are only read at startup.
+	AWS    awsSecrets    `yaml:"aws"`
+	Notify webhookConfig `yaml:"notify"`
+	Digest webhookConfig `yaml:"digest"`
+	Push   webhookConfig `yaml:"push"`
+}
+
+// awsSecrets are the secrets of the AWS credentials, each given directly or
+// in a file read when the configuration is loaded.
+type awsSecrets struct {
+	ExternalID     string `yaml:"external_id"`
+	ExternalIDFile string `yaml:"external_id_file"`
+}
+
+// webhookConfig is a webhook the exporter posts to, with its URL given
+// directly or in a file read when the configuration is loaded.
+type webhookConfig struct {
+	URL       string    `yaml:"url"`
+	URLFile   string    `yaml:"url_file"`
+	BasicAuth basicAuth `yaml:"basic_auth"`
+}
+
+// basicAuth are the credentials sent to a webhook, unless the username is
+// empty.
+type basicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// jobConfig is a named cost and usage query run in addition to the totals.
+type jobConfig struct {
+	Name string `yaml:"name"`
+	// Metric is the name the results are exported under after the
+	// namespace, the name of the job by default.
+	Metric string `yaml:"metric"`
+	// Metrics are the billing metrics queried, the selected metrics by
+	// default.
+	Metrics []string `yaml:"metrics"`
+	// Granularity is DAILY for the last complete day or MONTHLY for the
+	// month to date.
+	Granularity string     `yaml:"granularity"`
+	GroupBy     []jobGroup `yaml:"group_by"`
+	// Filter restricts the query, e.g. dimension: {key: SERVICE, values:
+	// [AWS Lambda]}.
+	Filter *filterConfig `yaml:"filter"`
+	// Schedule, if set, is a cron expression at which the query runs.
+	// Otherwise it runs on every refresh.
+	Schedule string `yaml:"schedule"`
+	// Labels are constant labels attached to the results of the job, e.g.
+	// view: per-service.
+	Labels map[string]string `yaml:"labels"`
+
+	// filter is the Cost Explorer expression of Filter.
+	filter *costexplorer.Expression
+}
+
+// jobGroup is a key the results of a job are grouped by.
+type jobGroup struct {
+	// Type is DIMENSION, TAG or COST_CATEGORY, DIMENSION by default.
+	Type string `yaml:"type"`
+	Key  string `yaml:"key"`
+}
+
+// filterConfig is a filter of a job: a match of a dimension, tag or cost
+// category, or a combination of filters. Exactly one field must be set.
+type filterConfig struct {
+	Dimension    *matchConfig   `yaml:"dimension"`
+	Tag          *matchConfig   `yaml:"tag"`
+	CostCategory *matchConfig   `yaml:"cost_category"`
+	And          []filterConfig `yaml:"and"`
+	Or           []filterConfig `yaml:"or"`
+	Not          *filterConfig  `yaml:"not"`
+}
+
+// matchConfig matches values of the dimension, tag or cost category given
+// by key.
+type matchConfig struct {
+	Key    string   `yaml:"key"`
+	Values []string `yaml:"values"`
+	// MatchOptions are Cost Explorer match options such as EQUALS, ABSENT
+	// or CONTAINS, EQUALS by default.
+	MatchOptions []string `yaml:"match_options"`
+}
+
+// expression returns the Cost Explorer expression of the filter.
+func (f *filterConfig) expression() (*costexplorer.Expression, error) {
+	set := 0
+	for _, ok := range []bool{f.Dimension != nil, f.Tag != nil, f.CostCategory != nil, f.And != nil, f.Or != nil, f.Not != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("filter must have exactly one of dimension, tag, cost_category, and, or and not")
+	}
+
+	var e costexplorer.Expression
+	switch {
+	case f.Dimension != nil:
+		if !contains(costexplorer.Dimension_Values(), f.Dimension.Key) {
+			return nil, fmt.Errorf("invalid filter dimension %q", f.Dimension.Key)
+		}
+		if err := f.Dimension.validate(); err != nil {
+			return nil, err
+		}
+		e.Dimensions = &costexplorer.DimensionValues{Key: aws.String(f.Dimension.Key), Values: aws.StringSlice(f.Dimension.Values), MatchOptions: aws.StringSlice(f.Dimension.MatchOptions)}
+	case f.Tag != nil:
+		if err := f.Tag.validate(); err != nil {
+			return nil, err
+		}
+		e.Tags = &costexplorer.TagValues{Key: aws.String(f.Tag.Key), Values: aws.StringSlice(f.Tag.Values), MatchOptions: aws.StringSlice(f.Tag.MatchOptions)}
+	case f.CostCategory != nil:
+		if err := f.CostCategory.validate(); err != nil {
+			return nil, err
+		}
+		e.CostCategories = &costexplorer.CostCategoryValues{Key: aws.String(f.CostCategory.Key), Values: aws.StringSlice(f.CostCategory.Values), MatchOptions: aws.StringSlice(f.CostCategory.MatchOptions)}
+	case f.Not != nil:
+		not, err := f.Not.expression()
+		if err != nil {
+			return nil, err
+		}
+		e.Not = not
+	default:
+		filters, op := f.And, "and"
+		if f.Or != nil {
+			filters, op = f.Or, "or"
+		}
+		if len(filters) < 2 {
+			return nil, fmt.Errorf("filter %s needs at least two filters", op)
+		}
+		for i := range filters {
+			sub, err := filters[i].expression()
+			if err != nil {
+				return nil, err
+			}
+			if op == "and" {
+				e.And = append(e.And, sub)
+			} else {
+				e.Or = append(e.Or, sub)
+			}
+		}
+	}
+	return &e, nil
+}
+
+// validate checks the key and match options of a match.
+func (m *matchConfig) validate() error {
+	if m.Key == "" {
+		return fmt.Errorf("filter has no key")
+	}
+	for _, o := range m.MatchOptions {
+		if !contains(costexplorer.MatchOption_Values(), o) {
+			return fmt.Errorf("invalid match option %q, valid values are: %s", o, strings.Join(costexplorer.MatchOption_Values(), ", "))
+		}
+	}
+	return nil
+}
+
+// contains returns whether s is one of values.
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig reads and validates the configuration file.
+func loadConfig(file string) (*config, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseConfig(b)
+	if err == nil {
+		err = cfg.readFiles()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't load %s: %v", file, err)
+	}
+	return cfg, nil
+}
+
+// readFiles sets the secrets given by their _file variants to the content
+// of the files.
+func (c *config) readFiles() error {
+	for _, s := range []struct {
+		name  string
+		value *string
+		file  string
+	}{
+		{"aws.external_id", &c.AWS.ExternalID, c.AWS.ExternalIDFile},
+		{"notify.url", &c.Notify.URL, c.Notify.URLFile},
+		{"notify.basic_auth.password", &c.Notify.BasicAuth.Password, c.Notify.BasicAuth.PasswordFile},
+		{"digest.url", &c.Digest.URL, c.Digest.URLFile},
+		{"digest.basic_auth.password", &c.Digest.BasicAuth.Password, c.Digest.BasicAuth.PasswordFile},
+		{"push.url", &c.Push.URL, c.Push.URLFile},
+		{"push.basic_auth.password", &c.Push.BasicAuth.Password, c.Push.BasicAuth.PasswordFile},
+	} {
+		if s.file == "" {
+			continue
+		}
+		if *s.value != "" {
+			return fmt.Errorf("only one of %s and %s_file may be given", s.name, s.name)
+		}
+		value, err := readSecretFile(s.file)
+		if err != nil {
+			return fmt.Errorf("%s_file: %v", s.name, err)
+		}
+		*s.value = value
+	}
+	return nil
+}
+
+// secrets returns the secrets of the configuration by the names of the
+// flags they stand in for.
+func (c *config) secrets() map[string]string {
+	return map[string]string{
+		"aws.external-id":    c.AWS.ExternalID,
+		"notify.webhook-url": c.Notify.URL,
+		"digest.webhook-url": c.Digest.URL,
+		"push.webhook-url":   c.Push.URL,
+	}
+}
+
+// parseConfig parses and validates a YAML configuration.
+func parseConfig(b []byte) (*config, error) {
+	var cfg config
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// readSecret returns the value of the named secret flag, or the content of
+// the file given by its -file variant, so that secrets can be mounted from
+// files, e.g. Kubernetes Secrets, instead of being passed on the command
+// line.
+func readSecret(name, value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("only one of --%s and --%s-file may be given", name, name)
+	}
+	value, err := readSecretFile(file)
+	if err != nil {
+		return "", fmt.Errorf("--%s-file: %v", name, err)
+	}
+	return value, nil
+}
+
+// readSecretFile returns the content of a file holding a secret, without
+// surrounding whitespace.
+func readSecretFile(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(b))
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", file)
+	}
+	return value, nil
+}
+
+// configReloader reloads the configuration file on SIGHUP and, if enabled,
+// when the file changes.
+type configReloader struct {
+	file  string
+	apply func(*config) error
+	// last is the content of the file as last loaded.
+	last []byte
+}
+
+// newConfigReloader returns a reloader of the given file, which was loaded
+// at startup, passing reloaded configurations to apply.
+func newConfigReloader(file string, apply func(*config) error) *configReloader {
+	last, _ := ioutil.ReadFile(file)
+	return &configReloader{file: file, apply: apply, last: last}
+}
+
+// reload loads and applies the configuration file if it changed since it
+// was last loaded.
+func (r *configReloader) reload() error {
+	b, err := ioutil.ReadFile(r.file)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(b, r.last) {
+		return nil
+	}
+	cfg, err := parseConfig(b)
+	if err != nil {
+		return fmt.Errorf("can't load %s: %v", r.file, err)
+	}
+	if err := r.apply(cfg); err != nil {
+		return fmt.Errorf("can't apply %s: %v", r.file, err)
+	}
+	r.last = b
+	log.Infof("Reloaded configuration file %s", r.file)
+	return nil
+}
+
+// run reloads the configuration on SIGHUP and, with watch set, on changes
+// of the file. The directory of the file is watched, so that files replaced
+// by renaming, like the files of a Kubernetes ConfigMap, are picked up.
+func (r *configReloader) run(watch bool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var events chan fsnotify.Event
+	var errors chan error
+	if watch {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Errorf("Can't watch the configuration file: %v", err)
+		} else if err := w.Add(filepath.Dir(r.file)); err != nil {
+			log.Errorf("Can't watch the configuration file: %v", err)
+			w.Close()
+		} else {
+			defer w.Close()
+			events, errors = w.Events, w.Errors
+		}
+	}
+
+	for {
+		select {
+		case <-hup:
+		case <-events:
+		case err := <-errors:
+			log.Errorf("Error watching the configuration file: %v", err)
+			continue
+		}
+		if err := r.reload(); err != nil {
+			log.Errorf("Can't reload the configuration: %v", err)
+		}
+	}
+}
+
+// validate checks the configuration and fills in defaults.
+func (c *config) validate() error {
+	names := map[string]bool{}
+	for i := range c.Jobs {
+		j := &c.Jobs[i]
+		if j.Name == "" {
+			return fmt.Errorf("job %d has no name", i+1)
+		}
+		if names[j.Name] {
+			return fmt.Errorf("duplicate job %q", j.Name)
+		}
+		names[j.Name] = true
+
+		if j.Metric == "" {
+			j.Metric = sanitizeLabelName(j.Name)
+		}
+		if !model.IsValidMetricName(model.LabelValue(namespace + "_" + j.Metric)) {
+			return fmt.Errorf("job %q: invalid metric name %q", j.Name, j.Metric)
+		}
+		for k, m := range j.Metrics {
+			field, ok := prometheusMetrics.lookup(m)
+			if !ok {
+				return fmt.Errorf("job %q: invalid billing metric %q, valid values are: %s", j.Name, m, prometheusMetrics.usage())
+			}
+			j.Metrics[k] = prometheusMetrics[field].awsName
+		}
+		switch j.Granularity {
+		case "":
+			j.Granularity = costexplorer.GranularityDaily
+		case costexplorer.GranularityDaily, costexplorer.GranularityMonthly:
+		default:
+			return fmt.Errorf("job %q: invalid granularity %q, valid values are DAILY and MONTHLY", j.Name, j.Granularity)
+		}
+		if len(j.GroupBy) > 2 {
+			return fmt.Errorf("job %q: can't group by more than two keys", j.Name)
+		}
+		for k := range j.GroupBy {
+			g := &j.GroupBy[k]
+			switch g.Type {
+			case "":
+				g.Type = costexplorer.GroupDefinitionTypeDimension
+			case costexplorer.GroupDefinitionTypeDimension, costexplorer.GroupDefinitionTypeTag, costexplorer.GroupDefinitionTypeCostCategory:
+			default:
+				return fmt.Errorf("job %q: invalid group type %q", j.Name, g.Type)
+			}
+			if g.Key == "" {
+				return fmt.Errorf("job %q: group %d has no key", j.Name, k+1)
+			}
+		}
+		if j.Filter != nil {
+			filter, err := j.Filter.expression()
+			if err != nil {
+				return fmt.Errorf("job %q: %v", j.Name, err)
+			}
+			j.filter = filter
+		}
+		if j.Schedule != "" {
+			if _, err := parseCron(j.Schedule); err != nil {
+				return fmt.Errorf("job %q: %v", j.Name, err)
+			}
+		}
+		reserved := concatLabels(labelLayout{}.names(), "days")
+		for _, g := range j.GroupBy {
+			reserved = append(reserved, sanitizeLabelName(g.Key))
+		}
+		for l := range j.Labels {
+			if !model.LabelName(l).IsValid() || strings.HasPrefix(l, "__") {
+				return fmt.Errorf("job %q: invalid label name %q", j.Name, l)
+			}
+			for _, s := range reserved {
+				if l == s {
+					return fmt.Errorf("job %q: label %q is reserved", j.Name, l)
+				}
+			}
+		}
+	}
+	if len(c.Jobs) > 0 {
+		if err := checkJobMetrics(c.Jobs); err != nil {
+			return err
+		}
+	}
+	for _, w := range []struct {
+		name string
+		auth basicAuth
+	}{{"notify", c.Notify.BasicAuth}, {"digest", c.Digest.BasicAuth}, {"push", c.Push.BasicAuth}} {
+		if w.auth.Username == "" && (w.auth.Password != "" || w.auth.PasswordFile != "") {
+			return fmt.Errorf("%s.basic_auth has a password but no username", w.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigReloader(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("jobs: [{name: a}]\n")
+	f.Close()
+
+	e, err := NewExporter(nil, nil, exporterOptions{jobs: []jobConfig{{Name: "a", Metric: "a", Granularity: "DAILY"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := 0
+	r := newConfigReloader(f.Name(), func(c *config) error {
+		applied++
+		return e.reloadJobs(c.Jobs)
+	})
+	a := e.currentJobs()[0]
+
+	// An unchanged file isn't applied again.
+	if err := r.reload(); err != nil || applied != 0 {
+		t.Errorf("got %d reloads and error %v for an unchanged file", applied, err)
+	}
+
+	ioutil.WriteFile(f.Name(), []byte("jobs: [{name: a}, {name: b, granularity: MONTHLY}]\n"), 0644)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	jobs := e.currentJobs()
+	if len(jobs) != 2 || jobs[1].name != "b" {
+		t.Fatalf("got %d jobs after the reload, want a and b", len(jobs))
+	}
+	if jobs[0] != a {
+		t.Error("want the collector of the unchanged job a kept")
+	}
+
+	// An invalid file keeps the current jobs.
+	ioutil.WriteFile(f.Name(), []byte("jobs: [{granularity: HOURLY}]\n"), 0644)
+	if err := r.reload(); err == nil {
+		t.Error("expected an error for an invalid file")
+	}
+	if len(e.currentJobs()) != 2 {
+		t.Error("want the jobs kept after a failed reload")
+	}
+}
+
+func TestConfigSecretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"external-id": "id\n",
+		"password":    "secret\n",
+		"config.yml": fmt.Sprintf(`aws:
+  external_id_file: %s
+push:
+  url: https://example.com/push
+  basic_auth:
+    username: exporter
+    password_file: %s
+`, filepath.Join(dir, "external-id"), filepath.Join(dir, "password")),
+		"both.yml": `notify:
+  url: https://example.com/hook
+  url_file: /nonexistent
+`,
+		"username.yml": `digest:
+  basic_auth:
+    password: secret
+`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := loadConfig(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AWS.ExternalID != "id" || cfg.Push.BasicAuth.Password != "secret" {
+		t.Errorf("got external ID %q and password %q, want the content of their files", cfg.AWS.ExternalID, cfg.Push.BasicAuth.Password)
+	}
+	if s := cfg.secrets(); s["aws.external-id"] != "id" || s["push.webhook-url"] != "https://example.com/push" || s["notify.webhook-url"] != "" {
+		t.Errorf("got secrets %v", s)
+	}
+	for _, name := range []string{"both.yml", "username.yml"} {
+		if _, err := loadConfig(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var costCategoryLabelNames = []string{"account_id", "cost_category"}
+
+// costCategoriesCollector exports the definitions of the cost categories of
+// each target's account, so that changes to the categorization rules can be
+// detected.
+type costCategoriesCollector struct {
+	info           *prometheus.Desc
+	rules          *prometheus.Desc
+	values         *prometheus.Desc
+	effectiveStart *prometheus.Desc
+}
+
+func newCostCategoriesCollector(constLabels prometheus.Labels) *costCategoriesCollector {
+	return &costCategoriesCollector{
+		info: newInfoDesc("cost_category",
+			"Definition of the cost category.", concatLabels(costCategoryLabelNames, "arn", "default_value"), constLabels),
+		rules: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cost_category", "rules"),
+			"Number of rules of the cost category.", costCategoryLabelNames, constLabels),
+		values: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cost_category", "values"),
+			"Number of values of the cost category.", costCategoryLabelNames, constLabels),
+		effectiveStart: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cost_category", "effective_start_timestamp_seconds"),
+			"Time the current version of the cost category became effective, in seconds since the epoch.", costCategoryLabelNames, constLabels),
+	}
+}
+
+func (c *costCategoriesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	ch <- c.rules
+	ch <- c.values
+	ch <- c.effectiveStart
+}
+
+// update exports the cost categories in effect in the target's account.
+func (c *costCategoriesCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) error {
+	var categories []*costexplorer.CostCategoryReference
+	err := t.client.ListCostCategoryDefinitionsPagesWithContext(ctx, &costexplorer.ListCostCategoryDefinitionsInput{},
+		func(resp *costexplorer.ListCostCategoryDefinitionsOutput, lastPage bool) bool {
+			categories = append(categories, resp.CostCategoryReferences...)
+			return true
+		})
+	if err != nil {
+		return err
+	}
+	c.collect(ch, categories, accountID)
+	return nil
+}
+
+func (c *costCategoriesCollector) collect(ch chan<- prometheus.Metric, categories []*costexplorer.CostCategoryReference, accountID string) {
+	for _, cc := range categories {
+		labels := []string{accountID, aws.StringValue(cc.Name)}
+		ch <- newInfoMetric(c.info, concatLabels(labels, aws.StringValue(cc.CostCategoryArn), aws.StringValue(cc.DefaultValue))...)
+		ch <- prometheus.MustNewConstMetric(c.rules, prometheus.GaugeValue, float64(aws.Int64Value(cc.NumberOfRules)), labels...)
+		ch <- prometheus.MustNewConstMetric(c.values, prometheus.GaugeValue, float64(len(cc.Values)), labels...)
+		if start, err := time.Parse(time.RFC3339, aws.StringValue(cc.EffectiveStart)); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.effectiveStart, prometheus.GaugeValue, float64(start.Unix()), labels...)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCostCategories(t *testing.T) {
+	c := newCostCategoriesCollector(nil)
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) error {
+		c.collect(ch, []*costexplorer.CostCategoryReference{{
+			Name:            aws.String("team"),
+			CostCategoryArn: aws.String("arn:aws:ce::123456789012:costcategory/team"),
+			DefaultValue:    aws.String("unassigned"),
+			NumberOfRules:   aws.Int64(3),
+			Values:          aws.StringSlice([]string{"platform", "data"}),
+			EffectiveStart:  aws.String("2019-07-01T00:00:00Z"),
+		}}, "123456789012")
+		return nil
+	})
+
+	expected := `
+# HELP aws_billing_cost_category_effective_start_timestamp_seconds Time the current version of the cost category became effective, in seconds since the epoch.
+# TYPE aws_billing_cost_category_effective_start_timestamp_seconds gauge
+aws_billing_cost_category_effective_start_timestamp_seconds{account_id="123456789012",cost_category="team"} 1.5619392e+09
+# HELP aws_billing_cost_category_info Definition of the cost category.
+# TYPE aws_billing_cost_category_info gauge
+aws_billing_cost_category_info{account_id="123456789012",arn="arn:aws:ce::123456789012:costcategory/team",cost_category="team",default_value="unassigned"} 1
+# HELP aws_billing_cost_category_rules Number of rules of the cost category.
+# TYPE aws_billing_cost_category_rules gauge
+aws_billing_cost_category_rules{account_id="123456789012",cost_category="team"} 3
+# HELP aws_billing_cost_category_values Number of values of the cost category.
+# TYPE aws_billing_cost_category_values gauge
+aws_billing_cost_category_values{account_id="123456789012",cost_category="team"} 2
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
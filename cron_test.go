@@ -0,0 +1,51 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	from := time.Date(2019, 7, 1, 10, 20, 30, 0, time.UTC) // A Monday.
+	for _, c := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2019, 7, 1, 10, 21, 0, 0, time.UTC)},
+		{"15 */6 * * *", time.Date(2019, 7, 1, 12, 15, 0, 0, time.UTC)},
+		{"0 8-9 * * *", time.Date(2019, 7, 2, 8, 0, 0, 0, time.UTC)},
+		{"30 2 1,15 * *", time.Date(2019, 7, 15, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2019, 7, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2019, 7, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		s, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("%q: %v", c.expr, err)
+			continue
+		}
+		if got := s.next(from); !got.Equal(c.want) {
+			t.Errorf("%q: want next run at %v, got %v", c.expr, c.want, got)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ecbRatesURL        = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+	ecbRefreshInterval = 6 * time.Hour
+)
+
+// exchangeRates maps ISO 4217 currency codes to the value of one unit of a
+// common base currency in that currency.
+type exchangeRates map[string]float64
+
+// convert returns amount, given in currency from, expressed in currency to.
+// ok is false if the rate of either currency is unknown.
+func (r exchangeRates) convert(amount float64, from, to string) (float64, bool) {
+	fromRate, ok := r[from]
+	if !ok || fromRate == 0 {
+		return 0, false
+	}
+	toRate, ok := r[to]
+	if !ok {
+		return 0, false
+	}
+	return amount / fromRate * toRate, true
+}
+
+// fixedRate converts amounts from one currency into another at a static
+// exchange rate.
+type fixedRate struct {
+	from string
+	to   string
+	rate float64
+}
+
+// parseFixedRate parses an exchange rate given as FROM:TO=RATE, e.g.
+// USD:EUR=0.92.
+func parseFixedRate(s string) (*fixedRate, error) {
+	pair := strings.SplitN(s, "=", 2)
+	currencies := strings.SplitN(pair[0], ":", 2)
+	if len(pair) != 2 || len(currencies) != 2 || currencies[0] == "" || currencies[1] == "" {
+		return nil, fmt.Errorf("invalid exchange rate %q, expected FROM:TO=RATE", s)
+	}
+	rate, err := strconv.ParseFloat(pair[1], 64)
+	if err != nil || rate <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q: rate must be a positive number", s)
+	}
+	return &fixedRate{from: currencies[0], to: currencies[1], rate: rate}, nil
+}
+
+// apply converts amount if it is given in the source currency, returning the
+// new amount and unit. Other amounts are returned unchanged.
+func (r *fixedRate) apply(amount float64, unit string) (float64, string) {
+	if unit != r.from {
+		return amount, unit
+	}
+	return amount * r.rate, r.to
+}
+
+// rateSource provides exchange rates for the currency converter.
+type rateSource interface {
+	Rates() (exchangeRates, error)
+}
+
+// converter translates cost amounts into a target currency.
+type converter struct {
+	target string
+	source rateSource
+}
+
+// newConverter returns a converter to the target currency using the named
+// rate source, "ecb" or "file".
+func newConverter(target, source, ratesFile string) (*converter, error) {
+	switch source {
+	case "ecb":
+		return &converter{target: target, source: newECBRates(ecbRatesURL)}, nil
+	case "file":
+		rates, err := loadRatesFile(ratesFile)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := rates[target]; !ok {
+			return nil, fmt.Errorf("no rate for currency %s in %s", target, ratesFile)
+		}
+		return &converter{target: target, source: rates}, nil
+	default:
+		return nil, fmt.Errorf("unknown exchange rate source: %v", source)
+	}
+}
+
+// Rates returns the static rates themselves. It implements rateSource.
+func (r exchangeRates) Rates() (exchangeRates, error) {
+	return r, nil
+}
+
+// loadRatesFile reads static exchange rates from a JSON object mapping
+// currency codes to their rates against a common base, e.g.
+// {"USD": 1, "EUR": 0.92}.
+func loadRatesFile(file string) (exchangeRates, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rates := exchangeRates{}
+	if err := json.Unmarshal(b, &rates); err != nil {
+		return nil, fmt.Errorf("can't parse exchange rates file %s: %v", file, err)
+	}
+	return rates, nil
+}
+
+// ecbRates fetches the daily euro foreign exchange reference rates published
+// by the European Central Bank. Rates are cached, since they only change once
+// per working day, and the last known rates are kept if a refresh fails.
+type ecbRates struct {
+	url    string
+	client *http.Client
+
+	mutex   sync.Mutex
+	rates   exchangeRates
+	fetched time.Time
+}
+
+func newECBRates(url string) *ecbRates {
+	return &ecbRates{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Rates returns the cached rates, refreshing them when they are older than
+// ecbRefreshInterval. It implements rateSource.
+func (s *ecbRates) Rates() (exchangeRates, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.rates != nil && time.Since(s.fetched) < ecbRefreshInterval {
+		return s.rates, nil
+	}
+	rates, err := s.fetch()
+	if err != nil {
+		if s.rates != nil {
+			return s.rates, nil
+		}
+		return nil, err
+	}
+	s.rates, s.fetched = rates, time.Now()
+	return rates, nil
+}
+
+func (s *ecbRates) fetch() (exchangeRates, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status fetching exchange rates: %v", resp.Status)
+	}
+
+	var envelope struct {
+		Rates []struct {
+			Currency string  `xml:"currency,attr"`
+			Rate     float64 `xml:"rate,attr"`
+		} `xml:"Cube>Cube>Cube"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("can't parse exchange rates: %v", err)
+	}
+
+	rates := exchangeRates{"EUR": 1}
+	for _, r := range envelope.Rates {
+		rates[r.Currency] = r.Rate
+	}
+	return rates, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const ecbDaily = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2019-07-19">
+			<Cube currency="USD" rate="1.1217"/>
+			<Cube currency="GBP" rate="0.89773"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestECBRates(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ecbDaily))
+	}))
+	defer s.Close()
+
+	rates, err := newECBRates(s.URL).Rates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for currency, want := range map[string]float64{"EUR": 1, "USD": 1.1217, "GBP": 0.89773} {
+		if got := rates[currency]; got != want {
+			t.Errorf("rate for %s: want %v, got %v", currency, want, got)
+		}
+	}
+
+	got, ok := rates.convert(1.1217, "USD", "EUR")
+	if !ok || math.Abs(got-1) > 1e-9 {
+		t.Errorf("want 1 EUR, got %v (ok=%v)", got, ok)
+	}
+	if _, ok := rates.convert(1, "N/A", "EUR"); ok {
+		t.Errorf("expected conversion from unknown unit to fail")
+	}
+}
+
+func TestParseFixedRate(t *testing.T) {
+	r, err := parseFixedRate("USD:EUR=0.92")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount, unit := r.apply(100, "USD"); amount != 92 || unit != "EUR" {
+		t.Errorf("want 92 EUR, got %v %s", amount, unit)
+	}
+	if amount, unit := r.apply(3, "Hrs"); amount != 3 || unit != "Hrs" {
+		t.Errorf("want 3 Hrs unchanged, got %v %s", amount, unit)
+	}
+
+	for _, s := range []string{"", "USD", "USD=0.92", "USD:EUR", ":EUR=0.92", "USD:EUR=x", "USD:EUR=-1"} {
+		if _, err := parseFixedRate(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
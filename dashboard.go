@@ -0,0 +1,241 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dashboardConfig is the part of the exporter's configuration that shapes
+// the generated Grafana dashboard.
+type dashboardConfig struct {
+	metrics        []string
+	subsystem      string
+	constLabels    prometheus.Labels
+	comparePeriods bool
+	collectors     []string
+	presets        []string
+}
+
+type grafanaDashboard struct {
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	Tags          []string          `json:"tags"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Time          grafanaTime       `json:"time"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []grafanaPanel    `json:"panels"`
+}
+
+type grafanaTime struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string             `json:"name"`
+	Label      string             `json:"label,omitempty"`
+	Type       string             `json:"type"`
+	Query      string             `json:"query"`
+	Datasource *grafanaDatasource `json:"datasource,omitempty"`
+	Multi      bool               `json:"multi,omitempty"`
+	IncludeAll bool               `json:"includeAll,omitempty"`
+	Refresh    int                `json:"refresh,omitempty"`
+}
+
+type grafanaDatasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaPanel struct {
+	ID         int                `json:"id"`
+	Type       string             `json:"type"`
+	Title      string             `json:"title"`
+	Datasource *grafanaDatasource `json:"datasource"`
+	GridPos    grafanaGridPos     `json:"gridPos"`
+	Targets    []grafanaTarget    `json:"targets"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+var dashboardDatasource = &grafanaDatasource{Type: "prometheus", UID: "${datasource}"}
+
+// dashboardConfig returns the configuration of the dashboard charting the
+// metrics and collectors of the exporter, exported under the given
+// subsystem.
+func (e *Exporter) dashboardConfig(subsystem string) dashboardConfig {
+	cfg := dashboardConfig{
+		metrics:        e.selected,
+		subsystem:      subsystem,
+		constLabels:    e.constLabels,
+		comparePeriods: e.comparePeriods,
+		collectors:     e.optionalCollectors(),
+	}
+	for _, p := range e.presets {
+		cfg.presets = append(cfg.presets, p.name)
+	}
+	return cfg
+}
+
+// writeDashboard writes the dashboard to the given file, or to standard
+// output for -.
+func writeDashboard(out string, cfg dashboardConfig) error {
+	b, err := generateDashboard(cfg)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if out == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(out, b, 0644)
+}
+
+// generateDashboard returns a Grafana dashboard charting the metrics exported
+// with the given configuration.
+func generateDashboard(cfg dashboardConfig) ([]byte, error) {
+	labelNames := make([]string, 0, len(cfg.constLabels))
+	for name := range cfg.constLabels {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames)
+
+	d := grafanaDashboard{
+		Title:         "AWS Billing",
+		UID:           "aws-billing-exporter",
+		Tags:          []string{"aws", "billing"},
+		SchemaVersion: 36,
+		Time:          grafanaTime{From: "now-30d", To: "now"},
+	}
+	d.Templating.List = append(d.Templating.List, grafanaVariable{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"})
+	selectors := []string{`account_id=~"$account_id"`}
+	for _, name := range append([]string{"account_id"}, labelNames...) {
+		d.Templating.List = append(d.Templating.List, grafanaVariable{
+			Name:       name,
+			Type:       "query",
+			Query:      fmt.Sprintf("label_values(%s_up, %s)", namespace, name),
+			Datasource: dashboardDatasource,
+			Multi:      true,
+			IncludeAll: true,
+			Refresh:    2,
+		})
+		if name != "account_id" {
+			selectors = append(selectors, fmt.Sprintf(`%s=~"$%s"`, name, name))
+		}
+	}
+	selector := "{" + strings.Join(selectors, ",") + "}"
+
+	add := func(title, expr, legend string) {
+		i := len(d.Panels)
+		d.Panels = append(d.Panels, grafanaPanel{
+			ID:         i + 1,
+			Type:       "timeseries",
+			Title:      title,
+			Datasource: dashboardDatasource,
+			GridPos:    grafanaGridPos{H: 8, W: 12, X: i % 2 * 12, Y: i / 2 * 8},
+			Targets:    []grafanaTarget{{RefID: "A", Expr: expr, LegendFormat: legend}},
+		})
+	}
+
+	add("Exporter up", fmt.Sprintf("%s_up%s", namespace, selector), "{{account_id}}")
+	for _, awsName := range cfg.metrics {
+		field, ok := prometheusMetrics.lookup(awsName)
+		if !ok {
+			return nil, fmt.Errorf("invalid billing metric %q", awsName)
+		}
+		add(awsName, prometheus.BuildFQName(namespace, cfg.subsystem, prometheusMetrics[field].name)+selector, "{{account_id}} ({{unit}}{{currency}})")
+	}
+	add("Day over day change", prometheus.BuildFQName(namespace, cfg.subsystem, "day_over_day_change_percent")+selector, "{{account_id}} {{type}}")
+	if cfg.comparePeriods {
+		add("Period over period change", prometheus.BuildFQName(namespace, cfg.subsystem, "period_over_period_change_percent")+selector, "{{account_id}} {{type}} {{period}}")
+	}
+	for _, c := range cfg.collectors {
+		switch c {
+		case "budgets":
+			add("Budget actual spend", fmt.Sprintf("%s_budget_actual_spend%s", namespace, selector), "{{account_id}} {{budget_name}}")
+			add("Budget limit", fmt.Sprintf("%s_budget_limit%s", namespace, selector), "{{account_id}} {{budget_name}}")
+		case "forecast":
+			add("Forecasted cost", fmt.Sprintf("%s_forecast_cost%s", namespace, selector), "{{account_id}} ({{unit}}{{currency}})")
+		case "hourly":
+			add("Last hour", fmt.Sprintf("%s_hourly_last_hour%s", namespace, selector), "{{account_id}} {{type}} ({{unit}}{{currency}})")
+		case "compute-optimizer":
+			add("Compute Optimizer estimated monthly savings", fmt.Sprintf("%s_compute_optimizer_estimated_monthly_savings%s", namespace, selector), "{{account_id}} {{resource_type}} ({{unit}}{{currency}})")
+		case "reservations":
+			add("Reservation remaining days", fmt.Sprintf("%s_reservation_remaining_days%s", namespace, selector), "{{account_id}} {{service}} {{reservation_id}}")
+		case "savings-plans":
+			add("Savings plan commitment", fmt.Sprintf("%s_savings_plan_commitment%s", namespace, selector), "{{account_id}} {{savings_plan_id}} ({{unit}}{{currency}})")
+		case "cost-categories":
+			add("Cost category rules", fmt.Sprintf("%s_cost_category_rules%s", namespace, selector), "{{account_id}} {{cost_category}}")
+		case "cost-allocation-tags":
+			add("Active cost allocation tags", fmt.Sprintf("sum by (account_id) (%s_cost_allocation_tag_active%s)", namespace, selector), "{{account_id}}")
+		case "billing-conductor":
+			add("Billing group margin", fmt.Sprintf("%s_billing_group_margin%s", namespace, selector), "{{account_id}} {{billing_group}} ({{unit}}{{currency}})")
+		case "trusted-advisor":
+			add("Trusted Advisor estimated monthly savings", fmt.Sprintf("%s_trusted_advisor_estimated_monthly_savings%s", namespace, selector), "{{account_id}} {{check_name}}")
+		}
+	}
+
+	for _, name := range cfg.presets {
+		p, ok := presets[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid preset %q", name)
+		}
+		var labels, legend []string
+		for _, g := range p.groupBy {
+			labels = append(labels, g.label)
+			legend = append(legend, "{{"+g.label+"}}")
+		}
+		metric := fmt.Sprintf("%s_%s_last_day%s", namespace, strings.Replace(name, "-", "_", -1), selector)
+		add("Breakdown "+name, fmt.Sprintf("sum by (type, unit, currency, %s) (%s)", strings.Join(labels, ", "), metric), "{{type}} "+strings.Join(legend, " ")+" ({{unit}}{{currency}})")
+	}
+
+	if hasCollector(cfg.collectors, "budgets") && hasCollector(cfg.collectors, "forecast") {
+		add("Forecast to budget ratio", fmt.Sprintf("%s_forecast_to_budget_ratio%s", namespace, selector), "{{account_id}} {{budget_name}}")
+	}
+
+	return json.MarshalIndent(d, "", "  ")
+}
+
+func hasCollector(collectors []string, name string) bool {
+	for _, c := range collectors {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGenerateDashboard(t *testing.T) {
+	b, err := generateDashboard(dashboardConfig{
+		metrics:     []string{"BlendedCost"},
+		subsystem:   "billing",
+		constLabels: prometheus.Labels{"env": "prod"},
+		collectors:  []string{"budgets", "forecast"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d grafanaDashboard
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+
+	exprs := map[string]bool{}
+	for _, p := range d.Panels {
+		exprs[p.Targets[0].Expr] = true
+	}
+	for _, want := range []string{
+		`aws_billing_billing_blended_cost{account_id=~"$account_id",env=~"$env"}`,
+		`aws_billing_forecast_to_budget_ratio{account_id=~"$account_id",env=~"$env"}`,
+	} {
+		if !exprs[want] {
+			t.Errorf("want a panel for %s, got %v", want, exprs)
+		}
+	}
+	if len(d.Templating.List) != 3 || d.Templating.List[2].Name != "env" {
+		t.Errorf("want datasource, account_id and env variables, got %+v", d.Templating.List)
+	}
+
+	if _, err := generateDashboard(dashboardConfig{metrics: []string{"Blended"}}); err == nil {
+		t.Error("expected error for an invalid metric")
+	}
+}
+
+func TestDashboardConfig(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost,UnblendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter(nil, metrics, exporterOptions{subsystems: []string{"server"}, budgets: true, forecast: true, hourlyHours: 24, presets: []string{"tax"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := e.dashboardConfig("server")
+	if !reflect.DeepEqual(cfg.metrics, []string{"BlendedCost", "UnblendedCost"}) {
+		t.Errorf("want the selected metrics, got %v", cfg.metrics)
+	}
+	if !reflect.DeepEqual(cfg.collectors, []string{"budgets", "forecast", "hourly"}) {
+		t.Errorf("want the enabled collectors, got %v", cfg.collectors)
+	}
+	if !reflect.DeepEqual(cfg.presets, []string{"tax"}) {
+		t.Errorf("want the enabled presets, got %v", cfg.presets)
+	}
+}
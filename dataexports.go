@@ -0,0 +1,289 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/bcmdataexports"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// dataExportColumns are the columns of a CUR 2.0 export holding the billing
+// metrics. The amortized costs have no column of their own and can't be read
+// from an export.
+var dataExportColumns = map[string]string{
+	"BlendedCost":           "line_item_blended_cost",
+	"NetUnblendedCost":      "line_item_net_unblended_cost",
+	"NormalizedUsageAmount": "line_item_normalized_usage_amount",
+	"UnblendedCost":         "line_item_unblended_cost",
+	"UsageQuantity":         "line_item_usage_amount",
+}
+
+// fetchDataExport returns a function reading the given metrics over the query
+// window from the files delivered to S3 by the AWS Data Exports export with
+// the given ARN, as an alternative to Cost Explorer that makes no Cost
+// Explorer calls. The export must deliver gzipped CSV files and overwrite
+// them on every refresh. Its query selects the columns to read, see
+// dataExportColumns, along with line_item_usage_start_date,
+// line_item_usage_account_id and line_item_currency_code. Each target gets
+// the line items of its own account, so a single export of the management
+// account serves all its member accounts.
+func fetchDataExport(exportARN string, metrics []string, window windowConfig) (func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error), error) {
+	a, err := arn.Parse(exportARN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid export ARN %q: %v", exportARN, err)
+	}
+	r := &dataExportReader{arn: exportARN, region: a.Region, metrics: metrics, files: map[string]*dataExportFile{}}
+	return func(ctx context.Context, t *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		accountID, err := t.AccountID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		start, end := queryWindow(window)
+		files, err := r.read(ctx, t, start, end)
+		if err != nil {
+			return nil, err
+		}
+		return dataExportOutput(files, accountID, start, end, today()), nil
+	}, nil
+}
+
+// dataExportReader reads the files of an export, caching them by ETag so
+// that each version of a file is only downloaded once, however many targets
+// read it.
+type dataExportReader struct {
+	arn     string
+	region  string
+	metrics []string
+
+	mutex sync.Mutex
+	files map[string]*dataExportFile
+}
+
+// dataExportFile is an export file read into totals.
+type dataExportFile struct {
+	etag   string
+	totals *dataExportTotals
+}
+
+// read returns the totals of the export files of the billing periods
+// overlapping the window, downloading the files that changed since they
+// were last read.
+func (r *dataExportReader) read(ctx context.Context, t *target, start, end time.Time) ([]*dataExportTotals, error) {
+	resp, err := t.dataExports(r.region).GetExportWithContext(ctx, &bcmdataexports.GetExportInput{ExportArn: aws.String(r.arn)})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Export == nil || resp.Export.DestinationConfigurations == nil || resp.Export.DestinationConfigurations.S3Destination == nil {
+		return nil, fmt.Errorf("export %s has no S3 destination", r.arn)
+	}
+	dest := resp.Export.DestinationConfigurations.S3Destination
+	if c := dest.S3OutputConfigurations; c == nil ||
+		aws.StringValue(c.Format) != bcmdataexports.FormatOptionTextOrCsv ||
+		aws.StringValue(c.Compression) != bcmdataexports.CompressionOptionGzip ||
+		aws.StringValue(c.Overwrite) != bcmdataexports.OverwriteOptionOverwriteReport {
+		return nil, fmt.Errorf("export %s must deliver gzipped CSV files overwriting the previous ones", r.arn)
+	}
+
+	client := t.s3(aws.StringValue(dest.S3Region))
+	etags := map[string]string{}
+	var keys []string
+	for month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, start.Location()); month.Before(end); month = month.AddDate(0, 1, 0) {
+		prefix := path.Join(aws.StringValue(dest.S3Prefix), aws.StringValue(resp.Export.Name), "data", "BILLING_PERIOD="+month.Format("2006-01")) + "/"
+		err := client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{Bucket: dest.S3Bucket, Prefix: aws.String(prefix)},
+			func(page *s3.ListObjectsV2Output, _ bool) bool {
+				for _, o := range page.Contents {
+					if key := aws.StringValue(o.Key); strings.HasSuffix(key, ".csv.gz") {
+						keys = append(keys, key)
+						etags[key] = aws.StringValue(o.ETag)
+					}
+				}
+				return true
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var files []*dataExportTotals
+	for _, key := range keys {
+		f, ok := r.files[key]
+		if !ok || f.etag != etags[key] {
+			obj, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: dest.S3Bucket, Key: aws.String(key)})
+			if err != nil {
+				return nil, err
+			}
+			totals := newDataExportTotals(r.metrics)
+			err = totals.read(obj.Body)
+			obj.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("can't read s3://%s/%s: %v", aws.StringValue(dest.S3Bucket), key, err)
+			}
+			f = &dataExportFile{etag: etags[key], totals: totals}
+			r.files[key] = f
+		}
+		files = append(files, f.totals)
+	}
+	// Forget the files that were replaced or fell out of the window.
+	for key := range r.files {
+		if _, ok := etags[key]; !ok {
+			delete(r.files, key)
+		}
+	}
+	return files, nil
+}
+
+// dataExportTotals sums the billing metrics of an export file by account and
+// day.
+type dataExportTotals struct {
+	metrics []string
+	// accounts holds the totals by account, day and metric.
+	accounts map[string]map[string]map[string]float64
+	currency string
+}
+
+func newDataExportTotals(metrics []string) *dataExportTotals {
+	return &dataExportTotals{metrics: metrics, accounts: map[string]map[string]map[string]float64{}}
+}
+
+// read adds the line items of a gzipped CSV export file to the totals.
+func (d *dataExportTotals) read(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	cr := csv.NewReader(gz)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	dateColumn, ok := columns["line_item_usage_start_date"]
+	if !ok {
+		return fmt.Errorf("no line_item_usage_start_date column")
+	}
+	accountColumn, ok := columns["line_item_usage_account_id"]
+	if !ok {
+		return fmt.Errorf("no line_item_usage_account_id column")
+	}
+	currencyColumn, hasCurrency := columns["line_item_currency_code"]
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record[dateColumn]) < len(dateFormat) {
+			continue
+		}
+		day := record[dateColumn][:len(dateFormat)]
+		days := d.accounts[record[accountColumn]]
+		if days == nil {
+			days = map[string]map[string]float64{}
+			d.accounts[record[accountColumn]] = days
+		}
+		if days[day] == nil {
+			days[day] = map[string]float64{}
+		}
+		for _, m := range d.metrics {
+			i, ok := columns[dataExportColumns[m]]
+			if !ok {
+				continue
+			}
+			if f, err := strconv.ParseFloat(record[i], 64); err == nil {
+				days[day][m] += f
+			}
+		}
+		if hasCurrency && record[currencyColumn] != "" {
+			d.currency = record[currencyColumn]
+		}
+	}
+}
+
+// dataExportOutput returns the totals of the account in the files over the
+// window from start to end in the shape of a daily Cost Explorer response.
+// Days of the current month are estimated, as AWS may still adjust them.
+func dataExportOutput(files []*dataExportTotals, accountID string, start, end, now time.Time) *costexplorer.GetCostAndUsageOutput {
+	totals := map[string]map[string]float64{}
+	currency := ""
+	for _, f := range files {
+		for day, metrics := range f.accounts[accountID] {
+			d, err := time.ParseInLocation(dateFormat, day, start.Location())
+			if err != nil || d.Before(start) || !d.Before(end) {
+				continue
+			}
+			if totals[day] == nil {
+				totals[day] = map[string]float64{}
+			}
+			for m, v := range metrics {
+				totals[day][m] += v
+			}
+			if f.currency != "" {
+				currency = f.currency
+			}
+		}
+	}
+	var days []string
+	for day := range totals {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	out := &costexplorer.GetCostAndUsageOutput{}
+	for _, day := range days {
+		d, _ := time.ParseInLocation(dateFormat, day, start.Location())
+		r := &costexplorer.ResultByTime{
+			TimePeriod: &costexplorer.DateInterval{
+				Start: aws.String(day),
+				End:   aws.String(d.AddDate(0, 0, 1).Format(dateFormat)),
+			},
+			Total:     map[string]*costexplorer.MetricValue{},
+			Estimated: aws.Bool(d.Year() == now.Year() && d.Month() == now.Month()),
+		}
+		for m, f := range totals[day] {
+			unit := currency
+			if m == "UsageQuantity" || m == "NormalizedUsageAmount" {
+				unit = "N/A"
+			}
+			r.Total[m] = &costexplorer.MetricValue{Amount: aws.String(strconv.FormatFloat(f, 'f', -1, 64)), Unit: aws.String(unit)}
+		}
+		out.ResultsByTime = append(out.ResultsByTime, r)
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/bcmdataexports"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// gzipped returns the gzipped CSV export file with the given content.
+func gzipped(content string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(content))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestDataExportTotals(t *testing.T) {
+	d := newDataExportTotals([]string{"UnblendedCost", "UsageQuantity", "AmortizedCost"})
+	if err := d.read(bytes.NewReader(gzipped(`line_item_usage_start_date,line_item_usage_account_id,line_item_unblended_cost,line_item_usage_amount,line_item_currency_code
+2019-06-30T00:00:00Z,123456789012,7,1,USD
+2019-07-01T00:00:00Z,123456789012,1.5,2,USD
+2019-07-01T12:00:00Z,123456789012,2.5,3,USD
+2019-07-01T12:00:00Z,210987654321,16,3,USD
+2019-07-02T00:00:00Z,123456789012,4,1,USD
+2019-07-03T00:00:00Z,123456789012,8,1,USD
+`))); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2019, 7, 1, 0, 0, 0, 0, time.Local)
+	out := dataExportOutput([]*dataExportTotals{d}, "123456789012", start, start.AddDate(0, 0, 2), time.Date(2019, 7, 3, 12, 0, 0, 0, time.Local))
+
+	if len(out.ResultsByTime) != 2 {
+		t.Fatalf("got %d days, want 2", len(out.ResultsByTime))
+	}
+	for i, want := range []struct {
+		day, cost, usage string
+	}{{"2019-07-01", "4", "5"}, {"2019-07-02", "4", "1"}} {
+		r := out.ResultsByTime[i]
+		if got := aws.StringValue(r.TimePeriod.Start); got != want.day {
+			t.Errorf("day %d: got %s, want %s", i, got, want.day)
+		}
+		if got := aws.StringValue(r.Total["UnblendedCost"].Amount); got != want.cost {
+			t.Errorf("%s: got cost %s, want %s", want.day, got, want.cost)
+		}
+		if got := aws.StringValue(r.Total["UnblendedCost"].Unit); got != "USD" {
+			t.Errorf("%s: got unit %s, want USD", want.day, got)
+		}
+		if got := aws.StringValue(r.Total["UsageQuantity"].Amount); got != want.usage {
+			t.Errorf("%s: got usage %s, want %s", want.day, got, want.usage)
+		}
+		if _, ok := r.Total["AmortizedCost"]; ok {
+			t.Errorf("%s: got AmortizedCost, which exports have no column for", want.day)
+		}
+		if !aws.BoolValue(r.Estimated) {
+			t.Errorf("%s: not estimated", want.day)
+		}
+	}
+}
+
+func TestDataExportReader(t *testing.T) {
+	var regions []string
+	exports := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The region is part of the credential scope of the signature.
+		regions = append(regions, strings.Split(r.Header.Get("Authorization"), "/")[2])
+		w.Write([]byte(`{"Export": {"Name": "daily", "DestinationConfigurations": {"S3Destination": {
+			"S3Bucket": "billing", "S3Prefix": "cur", "S3Region": "eu-west-1",
+			"S3OutputConfigurations": {"Format": "TEXT_OR_CSV", "Compression": "GZIP", "Overwrite": "OVERWRITE_REPORT", "OutputType": "CUSTOM"}}}}}`))
+	}))
+	defer exports.Close()
+
+	etag := "1"
+	var downloads int
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("list-type") == "2" {
+			fmt.Fprintf(w, `<ListBucketResult><Contents><Key>cur/daily/data/BILLING_PERIOD=2019-07/daily-00001.csv.gz</Key><ETag>%s</ETag></Contents></ListBucketResult>`, etag)
+			return
+		}
+		downloads++
+		w.Write(gzipped(`line_item_usage_start_date,line_item_usage_account_id,line_item_unblended_cost,line_item_currency_code
+2019-07-01T00:00:00Z,123456789012,1.5,USD
+2019-07-01T00:00:00Z,210987654321,4,USD
+`))
+	}))
+	defer bucket.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+	}))
+	var targets []*target
+	for _, accountID := range []string{"123456789012", "210987654321"} {
+		targets = append(targets, &target{
+			accountID: accountID,
+			dataExports: func(region string) *bcmdataexports.BCMDataExports {
+				return bcmdataexports.New(sess, aws.NewConfig().WithRegion(region).WithEndpoint(exports.URL))
+			},
+			s3: func(region string) *s3.S3 {
+				return s3.New(sess, aws.NewConfig().WithRegion(region).WithEndpoint(bucket.URL).WithS3ForcePathStyle(true))
+			},
+		})
+	}
+
+	r := &dataExportReader{arn: "arn:aws:bcm-data-exports:eu-central-1:123456789012:export/daily-1", region: "eu-central-1", metrics: []string{"UnblendedCost"}, files: map[string]*dataExportFile{}}
+	start := time.Date(2019, 7, 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 2)
+	for i, want := range []struct {
+		etag      string
+		downloads int
+	}{{"1", 1}, {"1", 1}, {"2", 2}} {
+		etag = want.etag
+		for _, tg := range targets {
+			files, err := r.read(context.Background(), tg, start, end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := dataExportOutput(files, tg.accountID, start, end, start)
+			if len(out.ResultsByTime) != 1 {
+				t.Fatalf("%s: got %d days, want 1", tg.accountID, len(out.ResultsByTime))
+			}
+			cost := aws.StringValue(out.ResultsByTime[0].Total["UnblendedCost"].Amount)
+			if tg.accountID == "123456789012" && cost != "1.5" || tg.accountID == "210987654321" && cost != "4" {
+				t.Errorf("%s: got cost %s, want only the account's own line items", tg.accountID, cost)
+			}
+		}
+		if downloads != want.downloads {
+			t.Errorf("refresh %d: got %d downloads, want %d", i, downloads, want.downloads)
+		}
+	}
+	if len(regions) != 6 {
+		t.Errorf("got %d Data Exports calls, want 6", len(regions))
+	}
+	for _, region := range regions {
+		if region != "eu-central-1" {
+			t.Errorf("got Data Exports call in %s, want the region of the export ARN", region)
+		}
+	}
+}
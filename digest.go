@@ -0,0 +1,156 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// digest posts a cost summary to a Slack or Microsoft Teams incoming webhook
+// on a schedule. The totals, their day over day change and the top services
+// all come from the exporter's last scrape.
+type digest struct {
+	exporter *Exporter
+	url      string
+	top      int
+	client   *http.Client
+}
+
+// serviceCost is the spend of a service on a day.
+type serviceCost struct {
+	service string
+	amount  float64
+	unit    string
+}
+
+func newDigest(e *Exporter, url string, top int, auth basicAuth) *digest {
+	return &digest{
+		exporter: e,
+		url:      url,
+		top:      top,
+		client:   newWebhookClient(auth),
+	}
+}
+
+// run posts the digest at the times of the schedule.
+func (d *digest) run(schedule *cronSchedule) {
+	for {
+		next := schedule.next(time.Now())
+		if next.IsZero() {
+			log.Errorln("Digest schedule has no upcoming runs")
+			return
+		}
+		time.Sleep(time.Until(next))
+		if err := d.exporter.safely(d.post); err != nil {
+			log.Errorf("Can't post cost digest: %v", err)
+		}
+	}
+}
+
+// post sends the digest of the last scrape. Only the leader of an HA
+// deployment posts it.
+func (d *digest) post() error {
+	d.exporter.mutex.RLock()
+	snap := d.exporter.snapshot
+	d.exporter.mutex.RUnlock()
+	if snap == nil {
+		return fmt.Errorf("no billing data has been collected yet")
+	}
+	if !d.exporter.mayCall(0) {
+		return nil
+	}
+	return postJSON(d.client, d.url, map[string]string{"text": d.summary(snap)})
+}
+
+// summary returns the text of the digest. Slack and Teams both render it
+// from the text field of the webhook payload. Accounts missing from the
+// last scrape are listed at the end instead of failing the digest.
+func (d *digest) summary(snap *snapshot) string {
+	snap.mutex.Lock()
+	defer snap.mutex.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "AWS cost summary as of %s\n", snap.Time.Format("2006-01-02 15:04 MST"))
+	scraped := map[string]bool{}
+	for _, a := range snap.Accounts {
+		if len(a.Series) == 0 {
+			continue
+		}
+		scraped[a.AccountID] = true
+		fmt.Fprintf(&b, "\nAccount %s\n", a.AccountID)
+		for _, s := range a.Series {
+			fmt.Fprintf(&b, "%s on %s: %.2f %s", s.Type, s.Days[len(s.Days)-1], s.Latest(), s.Unit)
+			if n := len(s.Values); n > 1 && s.Values[n-2] != 0 {
+				fmt.Fprintf(&b, " (%+.1f%% day over day)", (s.Values[n-1]-s.Values[n-2])/s.Values[n-2]*100)
+			}
+			b.WriteString("\n")
+		}
+
+		if d.top <= 0 {
+			continue
+		}
+		s := a.Series[0]
+		services := d.topServices(a.Services, s.Type, s.Days[len(s.Days)-1])
+		if len(services) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "Top services by %s:\n", s.Type)
+		for _, sc := range services {
+			fmt.Fprintf(&b, "- %s: %.2f %s\n", sc.service, sc.amount, sc.unit)
+		}
+	}
+
+	var missing []string
+	for _, t := range d.exporter.targets {
+		accountID, err := t.AccountID(context.Background())
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("- unknown account: %v", err))
+			continue
+		}
+		if !scraped[accountID] {
+			missing = append(missing, fmt.Sprintf("- account %s", accountID))
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(&b, "\nNo billing data in the last scrape for:\n%s\n", strings.Join(missing, "\n"))
+	}
+	return b.String()
+}
+
+// topServices returns the services with the highest spend of the metric on
+// the given day, highest first.
+func (d *digest) topServices(services []*serviceSnapshot, metric, day string) []serviceCost {
+	var top []serviceCost
+	for _, svc := range services {
+		for _, s := range svc.Series {
+			n := len(s.Days)
+			if s.Type != metric || n == 0 || s.Days[n-1] != day || s.Values[n-1] == 0 {
+				continue
+			}
+			top = append(top, serviceCost{service: svc.Service, amount: s.Values[n-1], unit: s.Unit})
+		}
+	}
+	sort.Slice(top, func(i, j int) bool { return top[i].amount > top[j].amount })
+	if len(top) > d.top {
+		top = top[:d.top]
+	}
+	return top
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	var text string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		text = payload["text"]
+	}))
+	defer server.Close()
+
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDigest(e, server.URL, 1, basicAuth{})
+	if err := d.post(); err == nil {
+		t.Error("want error before the first scrape")
+	}
+
+	days := []string{"2019-07-01", "2019-07-02"}
+	e.snapshot = &snapshot{Accounts: []*accountSnapshot{{
+		AccountID: "123456789012",
+		Series:    []*costSeries{{Type: "UnblendedCost", Unit: "USD", Days: days, Values: []float64{100, 110}}},
+		Services: []*serviceSnapshot{
+			{Service: "Amazon EC2", Series: []*costSeries{{Type: "UnblendedCost", Unit: "USD", Days: days, Values: []float64{80, 20}}}},
+			{Service: "Amazon S3", Series: []*costSeries{{Type: "UnblendedCost", Unit: "USD", Days: days, Values: []float64{20, 90}}}},
+			{Service: "AWS Lambda", Series: []*costSeries{{Type: "UnblendedCost", Unit: "USD", Days: days[:1], Values: []float64{500}}}},
+		},
+	}}}
+	if err := d.post(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Account 123456789012", "UnblendedCost on 2019-07-02: 110.00 USD (+10.0% day over day)", "- Amazon S3: 90.00 USD"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("want %q in the digest, got:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "Amazon EC2") || strings.Contains(text, "AWS Lambda") {
+		t.Errorf("want only the top service of the latest day in the digest, got:\n%s", text)
+	}
+	if strings.Contains(text, "No billing data") {
+		t.Errorf("want no missing accounts in the digest, got:\n%s", text)
+	}
+
+	e.targets = append(e.targets, &target{accountID: "210987654321"})
+	if err := d.post(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Account 123456789012") || !strings.Contains(text, "No billing data in the last scrape for:\n- account 210987654321") {
+		t.Errorf("want the failing account reported in the digest, got:\n%s", text)
+	}
+}
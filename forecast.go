@@ -0,0 +1,98 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/budgets"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// forecastCollector exports the unblended cost AWS forecasts for the rest of
+// the current month.
+type forecastCollector struct {
+	cost *prometheus.Desc
+}
+
+func newForecastCollector(layout labelLayout, constLabels prometheus.Labels) *forecastCollector {
+	return &forecastCollector{
+		cost: prometheus.NewDesc(prometheus.BuildFQName(namespace, "forecast", "cost"),
+			"Unblended cost forecasted by AWS for the rest of the current month.", []string{"account_id", layout.currency()}, constLabels),
+	}
+}
+
+func (c *forecastCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cost
+}
+
+// update exports the forecast of the target's account and returns it.
+func (c *forecastCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) (*costexplorer.MetricValue, error) {
+	start := today()
+	end := time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, start.Location())
+	resp, err := t.client.GetCostForecastWithContext(ctx, &costexplorer.GetCostForecastInput{
+		Metric:      aws.String(costexplorer.MetricUnblendedCost),
+		Granularity: aws.String(costexplorer.GranularityMonthly),
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(start.Format(dateFormat)),
+			End:   aws.String(end.Format(dateFormat)),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Total == nil || resp.Total.Amount == nil {
+		return nil, nil
+	}
+	amount, err := strconv.ParseFloat(*resp.Total.Amount, 64)
+	if err != nil {
+		return nil, err
+	}
+	ch <- prometheus.MustNewConstMetric(c.cost, prometheus.GaugeValue, amount, accountID, aws.StringValue(resp.Total.Unit))
+	return resp.Total, nil
+}
+
+// forecastToBudgetRatio returns the month-end spend projected from the
+// budget's actual spend and the forecast for the rest of the month, relative
+// to the budget's limit. Since the forecast covers the whole account, only
+// monthly cost budgets without cost filters are considered.
+func forecastToBudgetRatio(b *budgets.Budget, forecast *costexplorer.MetricValue) (float64, bool) {
+	if aws.StringValue(b.BudgetType) != budgets.BudgetTypeCost || aws.StringValue(b.TimeUnit) != budgets.TimeUnitMonthly {
+		return 0, false
+	}
+	if len(b.CostFilters) > 0 || b.BudgetLimit == nil || b.CalculatedSpend == nil || forecast == nil || forecast.Amount == nil {
+		return 0, false
+	}
+	if aws.StringValue(b.BudgetLimit.Unit) != aws.StringValue(forecast.Unit) {
+		return 0, false
+	}
+	limit, ok := spendAmount(b.BudgetLimit)
+	if !ok || limit == 0 {
+		return 0, false
+	}
+	actual, ok := spendAmount(b.CalculatedSpend.ActualSpend)
+	if !ok {
+		return 0, false
+	}
+	remaining, err := strconv.ParseFloat(*forecast.Amount, 64)
+	if err != nil {
+		return 0, false
+	}
+	return (actual + remaining) / limit, true
+}
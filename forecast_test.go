@@ -0,0 +1,53 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/budgets"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+func TestForecastToBudgetRatio(t *testing.T) {
+	spend := func(amount string) *budgets.Spend {
+		return &budgets.Spend{Amount: aws.String(amount), Unit: aws.String("USD")}
+	}
+	budget := &budgets.Budget{
+		BudgetName:      aws.String("monthly"),
+		BudgetType:      aws.String(budgets.BudgetTypeCost),
+		TimeUnit:        aws.String(budgets.TimeUnitMonthly),
+		BudgetLimit:     spend("1000"),
+		CalculatedSpend: &budgets.CalculatedSpend{ActualSpend: spend("600")},
+	}
+	forecast := &costexplorer.MetricValue{Amount: aws.String("500"), Unit: aws.String("USD")}
+
+	if ratio, ok := forecastToBudgetRatio(budget, forecast); !ok || ratio != 1.1 {
+		t.Errorf("want ratio 1.1, got %v (ok=%v)", ratio, ok)
+	}
+
+	// Budgets with planned limits have no single limit.
+	planned := *budget
+	planned.BudgetLimit = nil
+	planned.PlannedBudgetLimits = map[string]*budgets.Spend{"1561939200": spend("1000")}
+	if _, ok := forecastToBudgetRatio(&planned, forecast); ok {
+		t.Errorf("expected no ratio for a budget with planned limits")
+	}
+
+	budget.CostFilters = map[string][]*string{"Service": {aws.String("Amazon Simple Storage Service")}}
+	if _, ok := forecastToBudgetRatio(budget, forecast); ok {
+		t.Errorf("expected no ratio for a filtered budget")
+	}
+}
@@ -1,10 +1,20 @@
 module github.com/abcdevops/aws_billing_exporter
 
 require (
-	github.com/aws/aws-sdk-go v1.20.20
-	github.com/prometheus/client_golang v1.0.0
-	github.com/prometheus/common v0.4.1
-	golang.org/x/net v0.0.0-20190110044637-be1c187aa6c6 // indirect
-	golang.org/x/text v0.3.0 // indirect
+	github.com/aws/aws-sdk-go v1.55.8
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/gogo/protobuf v1.1.1
+	github.com/golang/snappy v0.0.4
+	github.com/grpc-ecosystem/grpc-gateway v1.5.1 // indirect
+	github.com/prometheus/client_golang v1.11.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.26.0
+	github.com/prometheus/prometheus v2.5.0+incompatible
+	golang.org/x/net v0.0.0-20200625001655-4c5254603344
+	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
+	golang.org/x/text v0.3.2 // indirect
+	google.golang.org/genproto v0.0.0-20180817151627-c66870c02cf8 // indirect
+	google.golang.org/grpc v1.14.0 // indirect
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,98 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// graphiteSink sends metrics to a Carbon plaintext endpoint. Without tags,
+// the label values of a metric are appended to its path in the order of
+// their label names, with _ for empty values so that each label keeps its
+// position; with tags, the non-empty ones are sent as Graphite tags.
+type graphiteSink struct {
+	address string
+	prefix  string
+	tags    bool
+	timeout time.Duration
+}
+
+func newGraphiteSink(address, prefix string, tags bool) *graphiteSink {
+	return &graphiteSink{address: address, prefix: prefix, tags: tags, timeout: 30 * time.Second}
+}
+
+func (s *graphiteSink) name() string {
+	return "Graphite " + s.address
+}
+
+func (s *graphiteSink) push(families []*dto.MetricFamily, t time.Time) error {
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(conn)
+	for _, f := range families {
+		for _, m := range f.Metric {
+			value, ok := metricValue(m)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(w, "%s %s %d\n", s.path(f.GetName(), m.Label), strconv.FormatFloat(value, 'g', -1, 64), t.Unix())
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// path returns the Graphite path of a metric.
+func (s *graphiteSink) path(name string, labels []*dto.LabelPair) string {
+	path := s.prefix + name
+	for _, l := range labels {
+		switch {
+		case s.tags && l.GetValue() == "":
+			// Graphite tags can't be empty.
+		case s.tags:
+			path += ";" + graphiteTagNameInvalid.ReplaceAllString(l.GetName(), "_") + "=" + strings.TrimLeft(graphiteTagInvalid.ReplaceAllString(l.GetValue(), "_"), "~")
+		case l.GetValue() == "":
+			path += "._"
+		default:
+			path += "." + graphiteNodeInvalid.ReplaceAllString(l.GetValue(), "_")
+		}
+	}
+	return path
+}
+
+var (
+	// graphiteNodeInvalid matches characters replaced in path nodes.
+	graphiteNodeInvalid = regexp.MustCompile(`[^a-zA-Z0-9_:-]`)
+	// graphiteTagNameInvalid matches characters replaced in tag names.
+	graphiteTagNameInvalid = regexp.MustCompile(`[;!^=\s]`)
+	// graphiteTagInvalid matches characters replaced in tag values.
+	graphiteTagInvalid = regexp.MustCompile(`[;\s]`)
+)
@@ -0,0 +1,89 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestGraphiteSink(t *testing.T) {
+	desc := prometheus.NewDesc("aws_billing_blended_cost", "Blended cost.", []string{"type", "unit", "currency", "account_id"}, prometheus.Labels{"team": "data platform"})
+	families, err := gather([]prometheus.Metric{prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 12.5, "BlendedCost", "", "USD", "123456789012")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		tags bool
+		want string
+	}{
+		{false, "billing.aws_billing_blended_cost.123456789012.USD.data_platform.BlendedCost._ 12.5 1561939200\n"},
+		{true, "billing.aws_billing_blended_cost;account_id=123456789012;currency=USD;team=data_platform;type=BlendedCost 12.5 1561939200\n"},
+	} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := make(chan string)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				received <- err.Error()
+				return
+			}
+			b, _ := ioutil.ReadAll(conn)
+			conn.Close()
+			received <- string(b)
+		}()
+
+		s := newGraphiteSink(l.Addr().String(), "billing.", c.tags)
+		if err := s.push(families, time.Unix(1561939200, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-received; got != c.want {
+			t.Errorf("want %q, got %q", c.want, got)
+		}
+		l.Close()
+	}
+}
+
+func TestGraphitePath(t *testing.T) {
+	labels := []*dto.LabelPair{
+		{Name: aws.String("a"), Value: aws.String("x y")},
+		{Name: aws.String("b=c"), Value: aws.String("")},
+		{Name: aws.String("d"), Value: aws.String("~z")},
+	}
+	for _, c := range []struct {
+		tags bool
+		want string
+	}{
+		{false, "aws_billing_cost.x_y._._z"},
+		{true, "aws_billing_cost;a=x_y;d=z"},
+	} {
+		if got := (&graphiteSink{tags: c.tags}).path("aws_billing_cost", labels); got != c.want {
+			t.Errorf("want %q, got %q", c.want, got)
+		}
+	}
+	name := []*dto.LabelPair{{Name: aws.String("b=c;d"), Value: aws.String("1")}}
+	if got := (&graphiteSink{tags: true}).path("aws_billing_cost", name); got != "aws_billing_cost;b_c_d=1" {
+		t.Errorf("want the tag name sanitized, got %q", got)
+	}
+}
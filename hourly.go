@@ -0,0 +1,105 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// hourFormat is the format of hourly time periods in the cost and usage
+	// API.
+	hourFormat = "2006-01-02T15:04:05Z"
+
+	// maxHourlyHours is how far back AWS keeps hourly cost and usage data.
+	maxHourlyHours = 14 * 24
+)
+
+// hourlyCollector exports the billing metrics of the last hours at HOURLY
+// granularity. Hourly data must be enabled in the Cost Explorer preferences
+// of the account.
+type hourlyCollector struct {
+	metrics []string
+	hours   int
+	layout  labelLayout
+
+	lastHour *prometheus.Desc
+	window   *prometheus.Desc
+}
+
+func newHourlyCollector(metrics []string, hours int, layout labelLayout, constLabels prometheus.Labels) (*hourlyCollector, error) {
+	if hours < 1 || hours > maxHourlyHours {
+		return nil, fmt.Errorf("hourly window must be between 1 and %d hours, got %d", maxHourlyHours, hours)
+	}
+	return &hourlyCollector{
+		metrics: metrics,
+		hours:   hours,
+		layout:  layout,
+		lastHour: prometheus.NewDesc(prometheus.BuildFQName(namespace, "hourly", "last_hour"),
+			"Billing metric given by the type label for the last complete hour.", layout.names(), constLabels),
+		window: prometheus.NewDesc(prometheus.BuildFQName(namespace, "hourly", "window_total"),
+			"Total of the billing metric given by the type label over the hourly window.", layout.names(), constLabels),
+	}, nil
+}
+
+func (c *hourlyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lastHour
+	ch <- c.window
+}
+
+// update exports the hourly metrics of the target's account. Amounts are
+// parsed with parse, so that the exporter's fixed exchange rate applies.
+func (c *hourlyCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string, parse func(*costexplorer.MetricValue) (float64, string, bool)) error {
+	end := time.Now().UTC().Truncate(time.Hour)
+	start := end.Add(-time.Duration(c.hours) * time.Hour)
+	resp, err := t.client.GetCostAndUsageWithContext(ctx, &costexplorer.GetCostAndUsageInput{
+		Metrics:     aws.StringSlice(c.metrics),
+		Granularity: aws.String(costexplorer.GranularityHourly),
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(start.Format(hourFormat)),
+			End:   aws.String(end.Format(hourFormat)),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	results := resp.ResultsByTime
+	if len(results) == 0 {
+		return nil
+	}
+	for _, awsName := range c.metrics {
+		total, unit := 0.0, ""
+		for _, r := range results {
+			if f, u, ok := parse(r.Total[awsName]); ok {
+				total += f
+				unit = u
+			}
+		}
+		if unit == "" {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.window, prometheus.GaugeValue, total, c.layout.values(awsName, unit, accountID)...)
+		if f, u, ok := parse(results[len(results)-1].Total[awsName]); ok {
+			ch <- prometheus.MustNewConstMetric(c.lastHour, prometheus.GaugeValue, f, c.layout.values(awsName, u, accountID)...)
+		}
+	}
+	return nil
+}
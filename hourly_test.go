@@ -0,0 +1,103 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHourly(t *testing.T) {
+	var input costexplorer.GetCostAndUsageInput
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"ResultsByTime": [
+			{"TimePeriod": {"Start": "2019-07-01T10:00:00Z"}, "Total": {"UnblendedCost": {"Amount": "1.25", "Unit": "USD"}}},
+			{"TimePeriod": {"Start": "2019-07-01T11:00:00Z"}, "Total": {"UnblendedCost": {"Amount": "0.75", "Unit": "USD"}}}]}`))
+	}))
+	defer s.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+
+	c, err := newHourlyCollector([]string{"UnblendedCost"}, 2, labelLayout{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter(nil, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan prometheus.Metric, 2)
+	if err := c.update(context.Background(), ch, &target{client: costexplorer.New(sess)}, "123456789012", e.amount); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	if aws.StringValue(input.Granularity) != costexplorer.GranularityHourly {
+		t.Errorf("want HOURLY granularity, got %s", aws.StringValue(input.Granularity))
+	}
+	start, err := time.Parse(hourFormat, aws.StringValue(input.TimePeriod.Start))
+	if err != nil {
+		t.Fatal(err)
+	}
+	end, err := time.Parse(hourFormat, aws.StringValue(input.TimePeriod.End))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end.Sub(start) != 2*time.Hour || !end.Equal(end.Truncate(time.Hour)) {
+		t.Errorf("want a window of the last 2 complete hours, got %s to %s", start, end)
+	}
+
+	expected := `
+# HELP aws_billing_hourly_last_hour Billing metric given by the type label for the last complete hour.
+# TYPE aws_billing_hourly_last_hour gauge
+aws_billing_hourly_last_hour{account_id="123456789012",currency="USD",type="UnblendedCost",unit=""} 0.75
+# HELP aws_billing_hourly_window_total Total of the billing metric given by the type label over the hourly window.
+# TYPE aws_billing_hourly_window_total gauge
+aws_billing_hourly_window_total{account_id="123456789012",currency="USD",type="UnblendedCost",unit=""} 2
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHourlyWindow(t *testing.T) {
+	for _, hours := range []int{0, maxHourlyHours + 1} {
+		if _, err := newHourlyCollector([]string{"UnblendedCost"}, hours, labelLayout{}, nil); err == nil {
+			t.Errorf("want an error for a window of %d hours", hours)
+		}
+	}
+}
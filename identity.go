@@ -0,0 +1,59 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// accountInfoCollector exports the identity the exporter calls each target's
+// account with, so that dashboards and alerts can check that it is the
+// intended account and role.
+type accountInfoCollector struct {
+	info *prometheus.Desc
+}
+
+func newAccountInfoCollector(constLabels prometheus.Labels) *accountInfoCollector {
+	return &accountInfoCollector{
+		info: newInfoDesc("account",
+			"Caller identity of the exporter in the account as returned by STS.",
+			[]string{"account_id", "arn", "partition", "region"}, constLabels),
+	}
+}
+
+func (c *accountInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+}
+
+// update exports the caller identity of the target. STS calls are free, so
+// they are made on every refresh to catch credentials changing under a
+// running exporter.
+func (c *accountInfoCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) error {
+	identity, err := t.sts.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return err
+	}
+	callerARN := aws.StringValue(identity.Arn)
+	var partition string
+	if a, err := arn.Parse(callerARN); err == nil {
+		partition = a.Partition
+	}
+	ch <- newInfoMetric(c.info, accountID, callerARN, partition, aws.StringValue(t.sts.Config.Region))
+	return nil
+}
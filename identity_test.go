@@ -0,0 +1,54 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAccountInfo(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(callerIdentity))
+	}))
+	defer s.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("eu-west-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+
+	c := newAccountInfoCollector(nil)
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) error {
+		return c.update(context.Background(), ch, newTarget(sess, awsConfig{partition: "aws"}), "123456789012")
+	})
+
+	expected := `
+# HELP aws_billing_account_info Caller identity of the exporter in the account as returned by STS.
+# TYPE aws_billing_account_info gauge
+aws_billing_account_info{account_id="123456789012",arn="arn:aws:iam::123456789012:user/billing",partition="aws",region="eu-west-1"} 1
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
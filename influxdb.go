@@ -0,0 +1,93 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// influxDBSink writes metrics in the InfluxDB line protocol to the HTTP API
+// of InfluxDB 2. Each metric becomes a measurement with its non-empty labels
+// as tags and its value in the value field.
+type influxDBSink struct {
+	url    string
+	org    string
+	bucket string
+	token  string
+	client *http.Client
+}
+
+func newInfluxDBSink(url, org, bucket, token string) *influxDBSink {
+	return &influxDBSink{url: url, org: org, bucket: bucket, token: token, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *influxDBSink) name() string {
+	return "InfluxDB bucket " + s.bucket
+}
+
+func (s *influxDBSink) push(families []*dto.MetricFamily, t time.Time) error {
+	var b bytes.Buffer
+	for _, f := range families {
+		for _, m := range f.Metric {
+			value, ok := metricValue(m)
+			if !ok {
+				continue
+			}
+			b.WriteString(influxKeyEscaper.Replace(f.GetName()))
+			for _, l := range m.Label {
+				if l.GetValue() != "" {
+					fmt.Fprintf(&b, ",%s=%s", influxKeyEscaper.Replace(l.GetName()), influxKeyEscaper.Replace(l.GetValue()))
+				}
+			}
+			fmt.Fprintf(&b, " value=%s %d\n", strconv.FormatFloat(value, 'g', -1, 64), t.Unix())
+		}
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(s.url, "/") + "/api/v2/write")
+	if err != nil {
+		return err
+	}
+	u.RawQuery = url.Values{"org": {s.org}, "bucket": {s.bucket}, "precision": {"s"}}.Encode()
+	req, err := http.NewRequest(http.MethodPost, u.String(), &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// influxKeyEscaper escapes measurements, tag keys and tag values.
+var influxKeyEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
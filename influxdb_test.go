@@ -0,0 +1,51 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInfluxDBSink(t *testing.T) {
+	desc := prometheus.NewDesc("aws_billing_blended_cost", "Blended cost.", []string{"type", "unit", "currency", "account_id"}, prometheus.Labels{"team": "data platform"})
+	families, err := gather([]prometheus.Metric{prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 12.5, "BlendedCost", "", "USD", "123456789012")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body, query, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, query, auth = string(b), r.URL.RawQuery, r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := newInfluxDBSink(server.URL, "acme", "billing", "secret")
+	if err := s.push(families, time.Unix(1561939200, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "aws_billing_blended_cost,account_id=123456789012,currency=USD,team=data\\ platform,type=BlendedCost value=12.5 1561939200\n"; body != want {
+		t.Errorf("want line %q, got %q", want, body)
+	}
+	if query != "bucket=billing&org=acme&precision=s" || auth != "Token secret" {
+		t.Errorf("unexpected query %q or authorization %q", query, auth)
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// jobCollector exports the results of a query job of the configuration
+// file. Jobs with a schedule only query Cost Explorer at the scheduled times
+// and export the results of their last run in between.
+type jobCollector struct {
+	config      jobConfig
+	name        string
+	metrics     []string
+	granularity string
+	groupBy     []*costexplorer.GroupDefinition
+	filter      *costexplorer.Expression
+	schedule    *cronSchedule
+	layout      labelLayout
+	desc        *prometheus.Desc
+	// serviceNames shortens the values of service labels.
+	serviceNames serviceNames
+	// runRateDays, if positive, widens the query of a daily job to that
+	// many days to export the average daily value of each group as
+	// runRate.
+	runRateDays int
+	runRate     *prometheus.Desc
+
+	mutex sync.Mutex
+	// results are the metrics of the last run by account.
+	results map[string]jobResult
+}
+
+type jobResult struct {
+	time    time.Time
+	metrics []prometheus.Metric
+}
+
+// newJobCollector returns a collector for the given job, querying the given
+// billing metrics unless the job names its own. The labels of the job are
+// added to the constant labels.
+func newJobCollector(j jobConfig, metrics []string, layout labelLayout, constLabels prometheus.Labels) (*jobCollector, error) {
+	if len(j.Metrics) > 0 {
+		metrics = j.Metrics
+	}
+	c := &jobCollector{
+		config:      j,
+		name:        j.Name,
+		metrics:     metrics,
+		granularity: j.Granularity,
+		filter:      j.filter,
+		layout:      layout,
+		results:     map[string]jobResult{},
+	}
+	labels := prometheus.Labels{}
+	for l, v := range constLabels {
+		labels[l] = v
+	}
+	for l, v := range j.Labels {
+		if _, ok := labels[l]; ok {
+			return nil, fmt.Errorf("label %q is already a constant label of the exporter", l)
+		}
+		labels[l] = v
+	}
+	if j.Schedule != "" {
+		schedule, err := parseCron(j.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		c.schedule = schedule
+	}
+
+	var keys []string
+	for _, g := range j.GroupBy {
+		c.groupBy = append(c.groupBy, &costexplorer.GroupDefinition{Type: aws.String(g.Type), Key: aws.String(g.Key)})
+		keys = append(keys, g.Key)
+	}
+	reserved := concatLabels(layout.names(), "days")
+	for l := range labels {
+		reserved = append(reserved, l)
+	}
+	names := uniqueLabelNames(keys, reserved)
+	labelNames := layout.names()
+	for _, k := range keys {
+		labelNames = append(labelNames, names[k])
+	}
+
+	period := "the last complete day"
+	if c.granularity == costexplorer.GranularityMonthly {
+		period = "the month to date"
+	}
+	c.desc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", j.Metric),
+		"Billing metric given by the type label for "+period+", as queried by job "+j.Name+".", labelNames, labels)
+	runRateLabelNames := concatLabels(labelNames, "days")
+	c.runRate = prometheus.NewDesc(prometheus.BuildFQName(namespace, j.Metric, "daily_run_rate"),
+		"Average daily value of the billing metric given by the type label over the last complete days given by the days label, as queried by job "+j.Name+".", runRateLabelNames, labels)
+	return c, nil
+}
+
+// checkJobMetrics returns an error if a job exports a metric name of another
+// job, or of the exporter with every feature enabled under the default or an
+// empty subsystem, which would otherwise fail every scrape.
+func checkJobMetrics(jobs []jobConfig) error {
+	subsystems := []string{legacySubsystem, ""}
+	selected, err := filterServerMetrics("", labelLayout{}, nil, subsystems)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	e, err := NewExporter(nil, selected, exporterOptions{
+		subsystems:       subsystems,
+		converter:        &converter{},
+		comparePeriods:   true,
+		runRateDays:      1,
+		historyDays:      1,
+		projectMonthEnd:  true,
+		budgets:          true,
+		forecast:         true,
+		hourlyHours:      1,
+		trustedAdvisor:   true,
+		computeOptimizer: true,
+		reservations:     true,
+		savingsPlans:     true,
+		accountInfo:      true,
+		costCategories:   true,
+		tags:             true,
+		billingConductor: true,
+		tagKeys:          []string{"tag"},
+		categoryKeys:     []string{"category"},
+		presets:          names,
+	})
+	if err != nil {
+		return err
+	}
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(e); err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		c, err := newJobCollector(j, nil, labelLayout{}, nil)
+		if err != nil {
+			return fmt.Errorf("job %q: %v", j.Name, err)
+		}
+		c.runRateDays = 1
+		if err := registry.Register(jobDescriber{c}); err != nil {
+			return fmt.Errorf("job %q: metric %q is already exported by the exporter or another job", j.Name, j.Metric)
+		}
+	}
+	return nil
+}
+
+// jobDescriber describes the metrics of a job to a registry, which checks
+// them against the metrics registered before.
+type jobDescriber struct {
+	*jobCollector
+}
+
+func (jobDescriber) Collect(chan<- prometheus.Metric) {}
+
+// newJobCollectors returns the collectors of the given jobs. The collectors
+// of unchanged jobs are taken over from previous, keeping the results of
+// their last run.
+func newJobCollectors(jobs []jobConfig, previous []*jobCollector, metrics []string, layout labelLayout, constLabels prometheus.Labels, names serviceNames, runRateDays int) ([]*jobCollector, error) {
+	var jcs []*jobCollector
+	for _, j := range jobs {
+		var jc *jobCollector
+		for _, p := range previous {
+			if reflect.DeepEqual(p.config, j) {
+				jc = p
+				break
+			}
+		}
+		if jc == nil {
+			var err error
+			if jc, err = newJobCollector(j, metrics, layout, constLabels); err != nil {
+				return nil, fmt.Errorf("job %q: %v", j.Name, err)
+			}
+			jc.serviceNames = names
+			if jc.granularity == costexplorer.GranularityDaily {
+				jc.runRateDays = runRateDays
+			}
+		}
+		jcs = append(jcs, jc)
+	}
+	return jcs, nil
+}
+
+// checkJobs returns an error if the metric of a job collides with another
+// metric family of the exporter or another job, e.g. a job named up, which
+// Prometheus would refuse to gather.
+func (e *Exporter) checkJobs(jcs []*jobCollector) error {
+	r := prometheus.NewRegistry()
+	if err := r.Register(describer(e.describe)); err != nil {
+		return err
+	}
+	for _, j := range jcs {
+		if err := r.Register(describer(j.Describe)); err != nil {
+			return fmt.Errorf("job %q: metric %s collides with another metric", j.name, prometheus.BuildFQName(namespace, "", j.config.Metric))
+		}
+	}
+	return nil
+}
+
+// describer is a collector of no metrics with the given descriptions, used
+// to check them against a registry.
+type describer func(chan<- *prometheus.Desc)
+
+func (d describer) Describe(ch chan<- *prometheus.Desc) { d(ch) }
+
+func (d describer) Collect(chan<- prometheus.Metric) {}
+
+func (c *jobCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+	if c.runRateDays > 0 {
+		ch <- c.runRate
+	}
+}
+
+// update exports the results of the job for the target's account, querying
+// them if the job is due.
+func (c *jobCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string, parse func(*costexplorer.MetricValue) (float64, string, bool)) error {
+	now := time.Now()
+	c.mutex.Lock()
+	last, ok := c.results[accountID]
+	c.mutex.Unlock()
+	if ok && c.schedule != nil && now.Before(c.schedule.next(last.time)) {
+		for _, m := range last.metrics {
+			ch <- m
+		}
+		return nil
+	}
+
+	start, end := c.period(today())
+	if c.runRateDays > 1 {
+		start = end.AddDate(0, 0, -c.runRateDays)
+	}
+	results, err := c.query(ctx, t, &costexplorer.GetCostAndUsageInput{
+		Metrics:     aws.StringSlice(c.metrics),
+		Granularity: aws.String(c.granularity),
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(start.Format(dateFormat)),
+			End:   aws.String(end.Format(dateFormat)),
+		},
+		GroupBy: c.groupBy,
+		Filter:  c.filter,
+	})
+	if err != nil {
+		return err
+	}
+	var metrics []prometheus.Metric
+	if c.granularity == costexplorer.GranularityDaily {
+		metrics = c.collect(dayGroups(results, end.AddDate(0, 0, -1)), accountID, parse)
+	} else {
+		metrics = c.collect(dayGroups(results, start), accountID, parse)
+	}
+	if c.runRateDays > 0 {
+		metrics = append(metrics, c.export(c.runRate, averageGroups(results, c.metrics, c.runRateDays), accountID, parse, strconv.Itoa(c.runRateDays))...)
+	}
+	c.mutex.Lock()
+	c.results[accountID] = jobResult{time: now, metrics: metrics}
+	c.mutex.Unlock()
+	for _, m := range metrics {
+		ch <- m
+	}
+	return nil
+}
+
+// query returns the results of the query, with the totals of each result as
+// a single group without keys if the job isn't grouped.
+func (c *jobCollector) query(ctx context.Context, t *target, input *costexplorer.GetCostAndUsageInput) ([]*costexplorer.ResultByTime, error) {
+	if len(c.groupBy) > 0 {
+		return queryResults(ctx, t, input)
+	}
+	resp, err := t.client.GetCostAndUsageWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resp.ResultsByTime {
+		r.Groups = []*costexplorer.Group{{Metrics: r.Total}}
+	}
+	return resp.ResultsByTime, nil
+}
+
+// period returns the days queried on the given day: the last complete day,
+// or the month to date, which is the previous month on the first.
+func (c *jobCollector) period(today time.Time) (start, end time.Time) {
+	if c.granularity != costexplorer.GranularityMonthly {
+		return today.AddDate(0, 0, -1), today
+	}
+	start = time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	if !start.Before(today) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, today
+}
+
+// collect returns the metrics of the groups of a query.
+func (c *jobCollector) collect(groups []*costexplorer.Group, accountID string, parse func(*costexplorer.MetricValue) (float64, string, bool)) []prometheus.Metric {
+	return c.export(c.desc, groups, accountID, parse)
+}
+
+// export returns the metrics of the groups as desc, with the extra label
+// values following those of the group keys.
+func (c *jobCollector) export(desc *prometheus.Desc, groups []*costexplorer.Group, accountID string, parse func(*costexplorer.MetricValue) (float64, string, bool), extra ...string) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	for _, g := range groups {
+		keys := aws.StringValueSlice(g.Keys)
+		if len(keys) != len(c.groupBy) {
+			continue
+		}
+		for i, d := range c.groupBy {
+			switch {
+			case aws.StringValue(d.Type) != costexplorer.GroupDefinitionTypeDimension:
+				// Tag and cost category groups come as NAME$VALUE.
+				keys[i] = sanitizeLabelValue(strings.TrimPrefix(keys[i], aws.StringValue(d.Key)+"$"))
+			case aws.StringValue(d.Key) == costexplorer.DimensionService:
+				keys[i] = c.serviceNames.short(keys[i])
+			}
+		}
+		for _, awsName := range c.metrics {
+			f, unit, ok := parse(g.Metrics[awsName])
+			if !ok {
+				continue
+			}
+			labels := c.layout.values(awsName, unit, accountID, append(keys, extra...)...)
+			metrics = append(metrics, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, f, labels...))
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,217 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+jobs:
+- name: Lambda by region
+  metrics: [UnblendedCost]
+  group_by:
+  - key: REGION
+  - type: TAG
+    key: team
+  filter:
+    and:
+    - dimension:
+        key: SERVICE
+        values: [AWS Lambda]
+    - not:
+        tag:
+          key: team
+          match_options: [ABSENT]
+  schedule: "0 6 * * *"
+- name: monthly
+  metric: month_to_date
+  granularity: MONTHLY
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Jobs) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(cfg.Jobs))
+	}
+	j := cfg.Jobs[0]
+	if j.Metric != "lambda_by_region" || j.Granularity != costexplorer.GranularityDaily || j.GroupBy[0].Type != costexplorer.GroupDefinitionTypeDimension {
+		t.Errorf("unexpected defaults %+v", j)
+	}
+	if f := j.filter; f == nil || len(f.And) != 2 || aws.StringValue(f.And[0].Dimensions.Key) != "SERVICE" || aws.StringValue(f.And[0].Dimensions.Values[0]) != "AWS Lambda" ||
+		f.And[1].Not == nil || aws.StringValue(f.And[1].Not.Tags.Key) != "team" || aws.StringValue(f.And[1].Not.Tags.MatchOptions[0]) != "ABSENT" {
+		t.Errorf("unexpected filter %v", j.filter)
+	}
+
+	for _, invalid := range []string{
+		"jobs: [{metric: foo}]",
+		"jobs: [{name: a}, {name: a}]",
+		"jobs: [{name: a, metrics: [Cost]}]",
+		"jobs: [{name: a, granularity: HOURLY}]",
+		"jobs: [{name: a, group_by: [{key: A}, {key: B}, {key: C}]}]",
+		"jobs: [{name: a, group_by: [{type: LABEL, key: A}]}]",
+		"jobs: [{name: a, schedule: never}]",
+		"jobs: [{name: a, unknown: true}]",
+		"jobs: [{name: a, labels: {account_id: x}}]",
+		"jobs: [{name: a, labels: {view-name: x}}]",
+		"jobs: [{name: a, labels: {days: x}}]",
+		"jobs: [{name: a, group_by: [{type: TAG, key: team}], labels: {team: x}}]",
+		"jobs: [{name: a, metric: budget_limit}]",
+		"jobs: [{name: a, metric: server_unblended_cost}]",
+		"jobs: [{name: a, metric: cost}, {name: b, metric: cost_daily_run_rate}]",
+		"jobs: [{name: a, metric: cost}, {name: b, metric: cost}]",
+		"jobs: [{name: a, filter: {}}]",
+		"jobs: [{name: a, filter: {dimensions: {key: SERVICE, values: [AWS Lambda]}}}]",
+		"jobs: [{name: a, filter: {dimension: {key: LAMBDA, values: [x]}}}]",
+		"jobs: [{name: a, filter: {tag: {key: team}, cost_category: {key: team}}}]",
+		"jobs: [{name: a, filter: {and: [{tag: {key: team}}]}}]",
+		"jobs: [{name: a, filter: {tag: {key: team, match_options: [LIKE]}}}]",
+	} {
+		if _, err := parseConfig([]byte(invalid)); err == nil {
+			t.Errorf("%s: expected an error", invalid)
+		}
+	}
+	if _, err := parseConfig([]byte("jobs: [{name: a, group_by: [{type: TAG, key: days}]}]")); err != nil {
+		t.Errorf("want a group key named days renamed, got %v", err)
+	}
+}
+
+func TestJobCollector(t *testing.T) {
+	c, err := newJobCollector(jobConfig{
+		Name:        "by team",
+		Metric:      "team_cost",
+		Granularity: costexplorer.GranularityDaily,
+		GroupBy:     []jobGroup{{Type: costexplorer.GroupDefinitionTypeDimension, Key: "SERVICE"}, {Type: costexplorer.GroupDefinitionTypeTag, Key: "team"}},
+	}, []string{"UnblendedCost"}, labelLayout{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter(nil, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.serviceNames, err = newServiceNames(nil); err != nil {
+		t.Fatal(err)
+	}
+	metrics := metricSlice(c.collect([]*costexplorer.Group{
+		group("12", "Amazon Elastic Compute Cloud - Compute", "team$payments"),
+		group("3", "AWS Lambda", "team$"),
+	}, "123456789012", e.amount))
+	expected := `
+# HELP aws_billing_team_cost Billing metric given by the type label for the last complete day, as queried by job by team.
+# TYPE aws_billing_team_cost gauge
+aws_billing_team_cost{account_id="123456789012",currency="USD",service="ec2",team="payments",type="UnblendedCost",unit=""} 12
+aws_billing_team_cost{account_id="123456789012",currency="USD",service="lambda",team="",type="UnblendedCost",unit=""} 3
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJobLabels(t *testing.T) {
+	c, err := newJobCollector(jobConfig{Name: "services", Metric: "services", Granularity: costexplorer.GranularityDaily, Labels: map[string]string{"view": "per-service"}}, []string{"UnblendedCost"}, labelLayout{}, prometheus.Labels{"env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter(nil, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := metricSlice(c.collect([]*costexplorer.Group{group("7")}, "123456789012", e.amount))
+	expected := `
+# HELP aws_billing_services Billing metric given by the type label for the last complete day, as queried by job services.
+# TYPE aws_billing_services gauge
+aws_billing_services{account_id="123456789012",currency="USD",env="prod",type="UnblendedCost",unit="",view="per-service"} 7
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := newJobCollector(jobConfig{Name: "env", Labels: map[string]string{"env": "dev"}}, nil, labelLayout{}, prometheus.Labels{"env": "prod"}); err == nil {
+		t.Error("expected an error for a label of the exporter")
+	}
+}
+
+func TestJobPeriod(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.ParseInLocation(dateFormat, s, time.Local)
+		return d
+	}
+	for _, tc := range []struct {
+		granularity, today, start string
+	}{
+		{costexplorer.GranularityDaily, "2024-03-01", "2024-02-29"},
+		{costexplorer.GranularityMonthly, "2024-03-15", "2024-03-01"},
+		{costexplorer.GranularityMonthly, "2024-03-01", "2024-02-01"},
+	} {
+		c := &jobCollector{granularity: tc.granularity}
+		start, end := c.period(day(tc.today))
+		if start.Format(dateFormat) != tc.start || end.Format(dateFormat) != tc.today {
+			t.Errorf("%s on %s: got %s to %s, want %s to %s", tc.granularity, tc.today, start.Format(dateFormat), end.Format(dateFormat), tc.start, tc.today)
+		}
+	}
+}
+
+func TestJobSchedule(t *testing.T) {
+	c, err := newJobCollector(jobConfig{Name: "daily", Metric: "daily", Granularity: costexplorer.GranularityDaily, Schedule: "0 6 * * *"}, []string{"UnblendedCost"}, labelLayout{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, c.layout.values("UnblendedCost", "USD", "123456789012")...)
+	c.results["123456789012"] = jobResult{time: time.Now(), metrics: []prometheus.Metric{m}}
+
+	// The job isn't due, so the cached results are exported without calling
+	// Cost Explorer on the nil client.
+	ch := make(chan prometheus.Metric, 1)
+	if err := c.update(context.Background(), ch, &target{}, "123456789012", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(ch) != 1 {
+		t.Errorf("got %d metrics, want the cached one", len(ch))
+	}
+}
+
+func TestJobMetricCollision(t *testing.T) {
+	for _, tc := range []struct {
+		jobs []jobConfig
+		ok   bool
+	}{
+		{[]jobConfig{{Name: "services", Metric: "services", Granularity: costexplorer.GranularityDaily}}, true},
+		{[]jobConfig{{Name: "up", Metric: "up", Granularity: costexplorer.GranularityDaily}}, false},
+		{[]jobConfig{{Name: "a", Metric: "exporter_total_scrapes", Granularity: costexplorer.GranularityDaily}}, false},
+	} {
+		e, err := NewExporter(nil, nil, exporterOptions{jobs: tc.jobs})
+		if (err == nil) != tc.ok {
+			t.Errorf("job metric %s: got error %v", tc.jobs[0].Metric, err)
+		}
+		if err != nil || !tc.ok {
+			continue
+		}
+		if err := e.reloadJobs([]jobConfig{{Name: "up", Metric: "up", Granularity: costexplorer.GranularityDaily}}); err == nil {
+			t.Error("want error reloading a job named up")
+		}
+		if len(e.currentJobs()) != 1 || e.currentJobs()[0].name != "services" {
+			t.Error("want the jobs kept after a failed reload")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// kafkaSink publishes the metrics of every refresh as JSON records to a
+// Kafka topic through the REST Proxy API, which Confluent REST Proxy and the
+// Redpanda HTTP Proxy implement, so that no Kafka client is needed. Records
+// are keyed by account, keeping the records of an account in one partition.
+type kafkaSink struct {
+	url    string
+	topic  string
+	client *http.Client
+}
+
+func newKafkaSink(url, topic string) *kafkaSink {
+	return &kafkaSink{url: url, topic: topic, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *kafkaSink) name() string {
+	return "Kafka topic " + s.topic
+}
+
+type kafkaRecord struct {
+	Key   string       `json:"key,omitempty"`
+	Value metricRecord `json:"value"`
+}
+
+// kafkaResponse is the response of the REST proxy, with an offset or an
+// error for every record.
+type kafkaResponse struct {
+	Offsets []struct {
+		ErrorCode int    `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (s *kafkaSink) push(families []*dto.MetricFamily, t time.Time) error {
+	var req struct {
+		Records []kafkaRecord `json:"records"`
+	}
+	for _, r := range metricRecords(families, t) {
+		req.Records = append(req.Records, kafkaRecord{Key: r.Labels["account_id"], Value: r})
+	}
+	if len(req.Records) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(strings.TrimSuffix(s.url, "/")+"/topics/"+url.PathEscape(s.topic), "application/vnd.kafka.json.v2+json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Kafka REST proxy returned %s", resp.Status)
+	}
+	var out kafkaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("can't parse Kafka REST proxy response: %v", err)
+	}
+	failed := 0
+	for _, o := range out.Offsets {
+		if o.ErrorCode != 0 {
+			if failed++; failed == 1 {
+				err = fmt.Errorf("%s", o.Error)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d records not published: %v", failed, len(req.Records), err)
+	}
+	return nil
+}
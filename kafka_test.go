@@ -0,0 +1,73 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestKafkaSink(t *testing.T) {
+	desc := prometheus.NewDesc("aws_billing_blended_cost", "Blended cost.", []string{"type", "unit", "currency", "account_id"}, nil)
+	families, err := gather([]prometheus.Metric{
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 12.5, "BlendedCost", "", "USD", "123456789012"),
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "BlendedCost", "", "USD", "210987654321"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var path, contentType string
+	var req struct {
+		Records []kafkaRecord `json:"records"`
+	}
+	response := `{"offsets":[{"partition":0,"offset":1},{"partition":1,"offset":7}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	s := newKafkaSink(server.URL, "billing")
+	now := time.Unix(1561939200, 0).UTC()
+	if err := s.push(families, now); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/topics/billing" || contentType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("unexpected path %q or content type %q", path, contentType)
+	}
+	if len(req.Records) != 2 {
+		t.Fatalf("want 2 records, got %d", len(req.Records))
+	}
+	r := req.Records[0]
+	if r.Key != "123456789012" || r.Value.Name != "aws_billing_blended_cost" || r.Value.Value != 12.5 || !r.Value.Time.Equal(now) || r.Value.Labels["currency"] != "USD" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if _, ok := r.Value.Labels["unit"]; ok {
+		t.Errorf("want empty labels omitted, got %v", r.Value.Labels)
+	}
+
+	response = `{"offsets":[{"partition":0,"offset":2},{"error_code":50002,"error":"Kafka error"}]}`
+	if err := s.push(families, now); err == nil {
+		t.Error("want an error for records that weren't published")
+	}
+}
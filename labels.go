@@ -0,0 +1,117 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// concatLabels returns a new slice of the label names or values followed by
+// the extra ones. Unlike append, it never shares the backing array of
+// labels, which are often package-level slices.
+func concatLabels(labels []string, extra ...string) []string {
+	l := make([]string, 0, len(labels)+len(extra))
+	return append(append(l, labels...), extra...)
+}
+
+// labelMapping records the label a tag key or cost category name is
+// exported as.
+type labelMapping struct {
+	metric string
+	label  string
+	source string
+}
+
+// tagAllowlist are the tag keys allowed to be exported. A nil allowlist
+// allows all keys.
+type tagAllowlist map[string]bool
+
+func newTagAllowlist(keys []string) tagAllowlist {
+	if len(keys) == 0 {
+		return nil
+	}
+	a := tagAllowlist{}
+	for _, k := range keys {
+		a[k] = true
+	}
+	return a
+}
+
+// allows returns whether the given tag key may be exported.
+func (a tagAllowlist) allows(key string) bool {
+	return a == nil || a[key]
+}
+
+// sanitizeLabelName turns a tag key or cost category name into a valid
+// Prometheus label name: lowercase ASCII letters, digits and underscores,
+// with runs of other characters, including non-ASCII ones, replaced by a
+// single underscore, e.g. "Cost Center" becomes cost_center.
+func sanitizeLabelName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case !strings.HasSuffix(b.String(), "_"):
+			b.WriteByte('_')
+		}
+	}
+	name := strings.Trim(b.String(), "_")
+	switch {
+	case name == "":
+		return "label"
+	case name[0] >= '0' && name[0] <= '9':
+		return "_" + name
+	}
+	return name
+}
+
+// sanitizeLabelValue replaces invalid UTF-8 in a tag or cost category value.
+func sanitizeLabelValue(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, "�")
+}
+
+// uniqueLabelNames returns the label name of each source, sanitized and
+// distinct from the reserved names and each other. Sources are assigned in
+// sorted order and collisions get the suffix _2, _3 and so on, so that the
+// names are the same on every start.
+func uniqueLabelNames(sources, reserved []string) map[string]string {
+	taken := map[string]bool{}
+	for _, r := range reserved {
+		taken[r] = true
+	}
+	sorted := append([]string{}, sources...)
+	sort.Strings(sorted)
+
+	names := make(map[string]string, len(sources))
+	for _, s := range sorted {
+		if _, ok := names[s]; ok {
+			continue
+		}
+		base := sanitizeLabelName(s)
+		name := base
+		for i := 2; taken[name]; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		taken[name] = true
+		names[s] = name
+	}
+	return names
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSanitizeLabelName(t *testing.T) {
+	for s, want := range map[string]string{
+		"team":              "team",
+		"Cost Center":       "cost_center",
+		"aws:createdBy":     "aws_createdby",
+		"Kostenstelle/Höhe": "kostenstelle_h_he",
+		"2fa":               "_2fa",
+		"--":                "label",
+	} {
+		if got := sanitizeLabelName(s); got != want {
+			t.Errorf("%q: got %q, want %q", s, got, want)
+		}
+	}
+	if got := sanitizeLabelValue("caf\xe9"); got != "caf�" {
+		t.Errorf("got %q for invalid UTF-8", got)
+	}
+}
+
+func TestConcatLabels(t *testing.T) {
+	base := make([]string, 1, 4)
+	base[0] = "account_id"
+	state := concatLabels(base, "state")
+	currency := concatLabels(base, "currency")
+	if strings.Join(state, ",") != "account_id,state" || strings.Join(currency, ",") != "account_id,currency" {
+		t.Errorf("want independent label names, got %q and %q", state, currency)
+	}
+}
+
+func TestUniqueLabelNames(t *testing.T) {
+	names := uniqueLabelNames([]string{"cost-center", "Cost Center", "type"}, labelLayout{}.names())
+	want := map[string]string{"Cost Center": "cost_center", "cost-center": "cost_center_2", "type": "type_2"}
+	for s, name := range want {
+		if names[s] != name {
+			t.Errorf("%q: got %q, want %q", s, names[s], name)
+		}
+	}
+}
+
+func TestTagCollector(t *testing.T) {
+	c, mappings, err := newTagCollector(costexplorer.GroupDefinitionTypeTag, []string{"team", "Cost Center", "team"}, []string{"UnblendedCost"}, labelLayout{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 2 || mappings[1].label != "cost_center" || mappings[1].metric != "aws_billing_tag_last_day" {
+		t.Errorf("unexpected mappings %+v", mappings)
+	}
+	e, err := NewExporter(nil, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan prometheus.Metric, 2)
+	c.collect(ch, []*costexplorer.Group{group("60", "team$payments", "Cost Center$4711"), group("20", "team$", "Cost Center$")}, "123456789012", e.amount)
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	expected := `
+# HELP aws_billing_tag_last_day Billing metric given by the type label for the last complete day, by tag team and Cost Center.
+# TYPE aws_billing_tag_last_day gauge
+aws_billing_tag_last_day{account_id="123456789012",cost_center="",currency="USD",team="",type="UnblendedCost",unit=""} 20
+aws_billing_tag_last_day{account_id="123456789012",cost_center="4711",currency="USD",team="payments",type="UnblendedCost",unit=""} 60
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<html>
+<head>
+<title>AWS Billing Exporter</title>
+<style>
+body { font-family: sans-serif; }
+th, td { padding: 0.2em 1em 0.2em 0; text-align: left; vertical-align: top; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>AWS Billing Exporter</h1>
+<p>{{.Version}}</p>
+<ul>
+<li><a href="{{.MetricsPath}}">Metrics</a></li>
+<li><a href="/ui">Costs</a></li>
+</ul>
+<h2>Configuration</h2>
+<table>
+<tr><th>Metrics</th><td>{{.Metrics}}</td></tr>
+<tr><th>Period comparison</th><td>{{if .ComparePeriods}}enabled{{else}}disabled{{end}}</td></tr>
+<tr><th>Collectors</th><td>{{if .Collectors}}{{.Collectors}}{{else}}none{{end}}</td></tr>
+<tr><th>Accounts</th><td>{{range .Accounts}}{{.}}<br>{{end}}</td></tr>
+</table>
+<h2>Status</h2>
+<table>
+<tr><th>Last refresh</th><td>{{if .LastRefresh.IsZero}}never{{else}}{{.LastRefresh.Format "2006-01-02 15:04:05 MST"}}{{end}}</td></tr>
+<tr><th>Last error</th><td>{{if .LastError}}<span class="error">{{.LastError}}</span> at {{.LastErrorTime.Format "2006-01-02 15:04:05 MST"}}{{else}}none{{end}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// landingPage is the data rendered on the landing page.
+type landingPage struct {
+	Version        string
+	MetricsPath    string
+	Metrics        string
+	ComparePeriods bool
+	Collectors     string
+	Accounts       []string
+	LastRefresh    time.Time
+	LastError      string
+	LastErrorTime  time.Time
+}
+
+// collectors returns the names of the optional collectors enabled in the
+// exporter.
+func (e *Exporter) collectors() []string {
+	names := e.optionalCollectors()
+	if e.fetchServices != nil {
+		names = append(names, "service breakdown")
+	}
+	for _, p := range e.presets {
+		names = append(names, "preset "+p.name)
+	}
+	for _, b := range e.tagBreakdowns {
+		names = append(names, strings.Replace(b.name, "-", " ", -1)+" "+strings.Join(b.keys(), ", "))
+	}
+	for _, j := range e.currentJobs() {
+		names = append(names, "job "+j.name)
+	}
+	return names
+}
+
+// optionalCollectors returns the names of the enabled optional collectors,
+// as given by their --collector flags.
+func (e *Exporter) optionalCollectors() []string {
+	var names []string
+	if e.budgets != nil {
+		names = append(names, "budgets")
+	}
+	if e.forecast != nil {
+		names = append(names, "forecast")
+	}
+	if e.hourly != nil {
+		names = append(names, "hourly")
+	}
+	if e.trustedAdvisor != nil {
+		names = append(names, "trusted-advisor")
+	}
+	if e.computeOptimizer != nil {
+		names = append(names, "compute-optimizer")
+	}
+	if e.reservations != nil {
+		names = append(names, "reservations")
+	}
+	if e.savingsPlans != nil {
+		names = append(names, "savings-plans")
+	}
+	if e.accountInfo != nil {
+		names = append(names, "account-info")
+	}
+	if e.costCategories != nil {
+		names = append(names, "cost-categories")
+	}
+	if e.tags != nil {
+		names = append(names, "cost-allocation-tags")
+	}
+	if e.billingConductor != nil {
+		names = append(names, "billing-conductor")
+	}
+	return names
+}
+
+// landingHandler serves a page describing the exporter's configuration and
+// status. extraCollectors are enabled collectors registered separately from
+// the exporter.
+func (e *Exporter) landingHandler(metricsPath string, extraCollectors []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		page := landingPage{
+			Version:        version.Info(),
+			MetricsPath:    metricsPath,
+			Metrics:        strings.Join(e.selected, ", "),
+			ComparePeriods: e.comparePeriods,
+			Collectors:     strings.Join(append(e.collectors(), extraCollectors...), ", "),
+		}
+		for _, t := range e.targets {
+			t.mutex.Lock()
+			accountID := t.accountID
+			t.mutex.Unlock()
+			if accountID == "" {
+				accountID = "own credentials, account not resolved yet"
+			}
+			page.Accounts = append(page.Accounts, accountID)
+		}
+		e.mutex.RLock()
+		page.LastRefresh, page.LastError, page.LastErrorTime = e.cacheTime, e.lastError, e.lastErrorTime
+		e.mutex.RUnlock()
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingTemplate.Execute(w, page); err != nil {
+			log.Errorf("Can't render the landing page: %v", err)
+		}
+	})
+}
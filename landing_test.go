@@ -0,0 +1,68 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+func TestLandingPage(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost,UnblendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	budgets, shutdown := budgetsClient(`{"Budgets": []}`)
+	defer shutdown()
+	e, err := NewExporter([]*target{{accountID: "123456789012", budgets: budgets}}, metrics, exporterOptions{
+		subsystems: []string{"server"},
+		budgets:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return nil, errors.New("throttled")
+	}
+	e.refresh()
+	// A target using the exporter's own credentials whose account ID hasn't
+	// been looked up yet.
+	e.targets = append(e.targets, &target{})
+
+	w := httptest.NewRecorder()
+	e.landingHandler("/metrics", []string{"account-alias"}).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`href="/metrics"`,
+		"BlendedCost, UnblendedCost",
+		"budgets, account-alias",
+		"123456789012<br>own credentials",
+		"Can&#39;t scrape AWS Billing data of account 123456789012: throttled",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q on the landing page, got:\n%s", want, body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	e.landingHandler("/metrics", nil).ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != 404 {
+		t.Errorf("want 404 for unknown paths, got %d", w.Code)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/prometheus/common/log"
+)
+
+// elector decides whether this replica is the leader of an HA deployment.
+// Only the leader calls the paid Cost Explorer APIs; standbys serve the
+// metrics of their last scrape as leader.
+type elector interface {
+	IsLeader() bool
+}
+
+// dynamoLock is an elector holding a lease on an item of a DynamoDB table.
+// The table must have a string partition key named lock_id.
+type dynamoLock struct {
+	client   *dynamodb.DynamoDB
+	table    string
+	name     string
+	identity string
+	lease    time.Duration
+
+	mutex   sync.Mutex
+	expires time.Time
+}
+
+func newDynamoLock(sess *session.Session, table, name, identity string, lease time.Duration) *dynamoLock {
+	return &dynamoLock{
+		client:   dynamodb.New(sess),
+		table:    table,
+		name:     name,
+		identity: identity,
+		lease:    lease,
+	}
+}
+
+// run tries to acquire or renew the lease every third of its duration. Each
+// attempt is run by safely, so that a panic doesn't stop the renewals.
+func (l *dynamoLock) run(safely func(func() error) error) {
+	for {
+		safely(func() error {
+			l.renew()
+			return nil
+		})
+		time.Sleep(l.lease / 3)
+	}
+}
+
+// renew acquires the lease if it is free or expired, or extends it if it is
+// already held by this replica. Times are stored in milliseconds since the
+// epoch, so that short leases don't lose up to a second to rounding.
+func (l *dynamoLock) renew() {
+	now := time.Now()
+	expires := now.Add(l.lease)
+	_, err := l.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"lock_id": {S: aws.String(l.name)},
+			"owner":   {S: aws.String(l.identity)},
+			"expires": {N: aws.String(strconv.FormatInt(unixMilli(expires), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(lock_id) OR #owner = :owner OR #expires < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner":   aws.String("owner"),
+			"#expires": aws.String("expires"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(l.identity)},
+			":now":   {N: aws.String(strconv.FormatInt(unixMilli(now), 10))},
+		},
+	})
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+			log.Errorf("Can't renew leader lease %s: %v", l.name, err)
+		}
+		return
+	}
+	if !l.isLeader(now) {
+		log.Infof("Acquired leader lease %s as %s", l.name, l.identity)
+	}
+	l.expires = expires
+}
+
+// IsLeader returns whether this replica holds an unexpired lease. It
+// implements elector.
+func (l *dynamoLock) IsLeader() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.isLeader(time.Now())
+}
+
+func (l *dynamoLock) isLeader(now time.Time) bool {
+	return now.Before(l.expires)
+}
+
+// unixMilli returns t in milliseconds since the epoch.
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type staticElector bool
+
+func (l *staticElector) IsLeader() bool { return bool(*l) }
+
+func TestStandbyServesCache(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader := staticElector(true)
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{
+		subsystems: []string{"server"},
+		elector:    &leader,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		calls++
+		if calls > 1 {
+			return nil, errors.New("standby must not call Cost Explorer")
+		}
+		return costAndUsage("100"), nil
+	}
+
+	expected := `
+# HELP aws_billing_server_blended_cost This cost metric reflects the average cost of usage across the consolidated billing family.
+# TYPE aws_billing_server_blended_cost gauge
+aws_billing_server_blended_cost{account_id="123456789012",currency="USD",type="BlendedCost",unit=""} 100
+# HELP aws_billing_exporter_leader Whether this replica is the elected leader calling the Cost Explorer APIs.
+# TYPE aws_billing_exporter_leader gauge
+aws_billing_exporter_leader %s
+`
+	names := []string{"aws_billing_server_blended_cost", "aws_billing_exporter_leader"}
+	if err := testutil.CollectAndCompare(e, strings.NewReader(strings.Replace(expected, "%s", "1", 1)), names...); err != nil {
+		t.Error(err)
+	}
+
+	leader = false
+	if err := testutil.CollectAndCompare(e, strings.NewReader(strings.Replace(expected, "%s", "0", 1)), names...); err != nil {
+		t.Error(err)
+	}
+	if calls != 1 {
+		t.Errorf("want 1 Cost Explorer call, got %d", calls)
+	}
+}
+
+func TestDynamoLockMilliseconds(t *testing.T) {
+	var input dynamodb.PutItemInput
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer s.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+
+	l := newDynamoLock(sess, "locks", "aws_billing_exporter", "replica-1", 1500*time.Millisecond)
+	before := time.Now()
+	l.renew()
+	if !l.IsLeader() {
+		t.Fatal("want the lease acquired")
+	}
+	expires, err := strconv.ParseInt(aws.StringValue(input.Item["expires"].N), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Duration(expires-unixMilli(before)) * time.Millisecond; d < 1500*time.Millisecond || d > 2500*time.Millisecond {
+		t.Errorf("want the lease to expire in 1.5s, got %s", d)
+	}
+}
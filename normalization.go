@@ -0,0 +1,68 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// normalizationFactors are the normalization factors of instance sizes, in
+// which Amazon EC2 reports NormalizedUsageAmount and applies size-flexible
+// reservations. Bare metal sizes are left out, as their factor depends on the
+// instance family.
+var normalizationFactors = map[string]float64{
+	"nano":      0.25,
+	"micro":     0.5,
+	"small":     1,
+	"medium":    2,
+	"large":     4,
+	"xlarge":    8,
+	"2xlarge":   16,
+	"3xlarge":   24,
+	"4xlarge":   32,
+	"6xlarge":   48,
+	"8xlarge":   64,
+	"9xlarge":   72,
+	"10xlarge":  80,
+	"12xlarge":  96,
+	"16xlarge":  128,
+	"18xlarge":  144,
+	"24xlarge":  192,
+	"32xlarge":  256,
+	"48xlarge":  384,
+	"56xlarge":  448,
+	"112xlarge": 896,
+}
+
+func newNormalizationFactorDesc(constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "instance_size", "normalization_factor"),
+		"Normalization factor of the instance size given by the size label, in which NormalizedUsageAmount is reported.",
+		[]string{"size"}, constLabels)
+}
+
+// collectNormalizationFactors exports the normalization factors of all
+// instance sizes, so that usage by instance type can be converted into
+// normalized units in PromQL.
+func collectNormalizationFactors(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	sizes := make([]string, 0, len(normalizationFactors))
+	for size := range normalizationFactors {
+		sizes = append(sizes, size)
+	}
+	sort.Strings(sizes)
+	for _, size := range sizes {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, normalizationFactors[size], size)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNormalizationFactors(t *testing.T) {
+	for _, c := range []struct {
+		filter string
+		want   int
+	}{
+		{"BlendedCost", 0},
+		{"BlendedCost,NormalizedUsageAmount", len(normalizationFactors)},
+	} {
+		metrics, err := filterServerMetrics(c.filter, labelLayout{}, nil, []string{"server"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := NewExporter(nil, metrics, exporterOptions{subsystems: []string{"server"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := testutil.CollectAndCount(e, "aws_billing_instance_size_normalization_factor"); n != c.want {
+			t.Errorf("%s: want %d normalization factors, got %d", c.filter, c.want, n)
+		}
+	}
+	if normalizationFactors["2xlarge"] != 2*normalizationFactors["xlarge"] {
+		t.Error("want a 2xlarge to be twice an xlarge")
+	}
+}
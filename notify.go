@@ -0,0 +1,412 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/prometheus/common/log"
+)
+
+// threshold is a spend threshold notified about when crossed. It is given
+// as PERIOD:METRIC[@ACCOUNT][/SERVICE]>AMOUNT, where PERIOD is daily for the
+// last complete day, mtd for the month to date or forecast for the month to
+// date plus the AWS forecast for the rest of the month, e.g.
+// mtd:UnblendedCost@123456789012/Amazon Simple Storage Service>1000.
+type threshold struct {
+	spec      string
+	period    string
+	metric    string
+	accountID string
+	service   string
+	amount    float64
+}
+
+func parseThreshold(s string) (*threshold, error) {
+	gt := strings.LastIndex(s, ">")
+	colon := strings.Index(s, ":")
+	if gt < 0 || colon < 0 || colon > gt {
+		return nil, fmt.Errorf("invalid threshold %q, want PERIOD:METRIC[@ACCOUNT][/SERVICE]>AMOUNT", s)
+	}
+	t := &threshold{spec: s, period: s[:colon]}
+	if t.period != "daily" && t.period != "mtd" && t.period != "forecast" {
+		return nil, fmt.Errorf("invalid period %q in threshold %q, valid values are daily, mtd and forecast", t.period, s)
+	}
+	amount, err := strconv.ParseFloat(s[gt+1:], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount in threshold %q: %v", s, err)
+	}
+	t.amount = amount
+
+	scope := s[colon+1 : gt]
+	if i := strings.Index(scope, "/"); i >= 0 {
+		t.service, scope = scope[i+1:], scope[:i]
+	}
+	if i := strings.Index(scope, "@"); i >= 0 {
+		t.accountID, scope = scope[i+1:], scope[:i]
+	}
+	field, ok := prometheusMetrics.lookup(scope)
+	if !ok {
+		return nil, fmt.Errorf("invalid billing metric %q in threshold %q, valid values are: %s", scope, s, prometheusMetrics.usage())
+	}
+	t.metric = prometheusMetrics[field].awsName
+	return t, nil
+}
+
+// forecastMetric returns the name of the threshold's metric in the cost
+// forecast API, e.g. UNBLENDED_COST for UnblendedCost.
+func (t *threshold) forecastMetric() string {
+	var b strings.Builder
+	for i, r := range t.metric {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
+
+// notification is the JSON payload posted to the webhook when a threshold is
+// crossed.
+type notification struct {
+	Threshold string  `json:"threshold"`
+	Period    string  `json:"period"`
+	Start     string  `json:"start"`
+	Metric    string  `json:"metric"`
+	AccountID string  `json:"account_id"`
+	Service   string  `json:"service,omitempty"`
+	Amount    float64 `json:"amount"`
+	Limit     float64 `json:"limit"`
+	Unit      string  `json:"unit"`
+}
+
+// notificationSink delivers notifications.
+type notificationSink interface {
+	send(notification) error
+}
+
+// webhookSink posts notifications as JSON to a URL.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string, auth basicAuth) *webhookSink {
+	return &webhookSink{url: url, client: newWebhookClient(auth)}
+}
+
+func (s *webhookSink) send(payload notification) error {
+	return postJSON(s.client, s.url, payload)
+}
+
+// newWebhookClient returns a client for posting to a webhook with the given
+// basic-auth credentials.
+func newWebhookClient(auth basicAuth) *http.Client {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if auth.Username != "" {
+		client.Transport = basicAuthTransport{auth: auth, next: http.DefaultTransport}
+	}
+	return client
+}
+
+// basicAuthTransport sends basic-auth credentials with every request.
+type basicAuthTransport struct {
+	auth basicAuth
+	next http.RoundTripper
+}
+
+func (t basicAuthTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.SetBasicAuth(t.auth.Username, t.auth.Password)
+	return t.next.RoundTrip(r)
+}
+
+// postJSON posts v as JSON to a webhook URL.
+func postJSON(client *http.Client, url string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// snsSink publishes notifications as JSON to an SNS topic.
+type snsSink struct {
+	topicARN string
+	client   *sns.SNS
+}
+
+// newSNSSink returns a sink publishing to the given topic, using the region
+// of the topic.
+func newSNSSink(sess *session.Session, topicARN string) (*snsSink, error) {
+	a, err := arn.Parse(topicARN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SNS topic ARN %q: %v", topicARN, err)
+	}
+	return &snsSink{
+		topicARN: topicARN,
+		client:   sns.New(sess, aws.NewConfig().WithRegion(a.Region)),
+	}, nil
+}
+
+func (s *snsSink) send(payload notification) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	subject := "AWS spend threshold crossed: " + payload.Threshold
+	if len(subject) > 100 {
+		subject = subject[:100]
+	}
+	_, err = s.client.Publish(&sns.PublishInput{
+		TopicArn: aws.String(s.topicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(string(b)),
+	})
+	return err
+}
+
+// notifier periodically evaluates spend thresholds for the exporter's
+// targets and sends a notification to its sinks once per period in which a
+// threshold is crossed.
+type notifier struct {
+	exporter   *Exporter
+	thresholds []*threshold
+	sinks      []notificationSink
+	fetch      func(t *target, start, end time.Time) ([]*costexplorer.ResultByTime, error)
+	forecast   func(t *target, th *threshold, start, end time.Time) (float64, error)
+
+	// notified maps thresholds and accounts to the start of the period they
+	// were last notified for.
+	notified map[string]string
+}
+
+func newNotifier(e *Exporter, specs []string, sinks []notificationSink) (*notifier, error) {
+	n := &notifier{
+		exporter: e,
+		sinks:    sinks,
+		notified: map[string]string{},
+	}
+	n.fetch = n.query
+	n.forecast = n.queryForecast
+	for _, s := range specs {
+		t, err := parseThreshold(s)
+		if err != nil {
+			return nil, err
+		}
+		n.thresholds = append(n.thresholds, t)
+	}
+	return n, nil
+}
+
+// run evaluates the thresholds at the given interval.
+func (n *notifier) run(interval time.Duration) {
+	for {
+		n.evaluate(today())
+		time.Sleep(interval)
+	}
+}
+
+// evaluate queries the spend of each target up to the given day, and
+// notifies about the thresholds it crosses. Queries count against the
+// exporter's call budget and are only made by the leader of an HA
+// deployment.
+func (n *notifier) evaluate(end time.Time) {
+	calls := 1
+	for _, th := range n.thresholds {
+		if th.period == "forecast" {
+			calls++
+		}
+	}
+	if !n.exporter.mayCall(calls * len(n.exporter.targets)) {
+		return
+	}
+	monthStart := time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, end.Location())
+	start := monthStart
+	if !start.Before(end) {
+		// On the first of the month, only the previous day is complete.
+		start = end.AddDate(0, 0, -1)
+	}
+
+	for _, t := range n.exporter.targets {
+		accountID, err := t.AccountID(context.Background())
+		if err != nil {
+			log.Errorf("Can't get AWS account ID: %v", err)
+			continue
+		}
+		results, err := n.fetch(t, start, end)
+		if err != nil {
+			log.Errorf("Can't query spend of account %s for notifications: %v", accountID, err)
+			continue
+		}
+		if len(results) == 0 {
+			continue
+		}
+		for _, th := range n.thresholds {
+			if th.accountID != "" && th.accountID != accountID {
+				continue
+			}
+			periodStart, amount, unit := n.spend(th, results, monthStart)
+			if th.period == "forecast" {
+				remaining, err := n.forecast(t, th, end, monthStart.AddDate(0, 1, 0))
+				if err != nil {
+					log.Errorf("Can't query cost forecast of account %s for notifications: %v", accountID, err)
+					continue
+				}
+				amount += remaining
+			}
+			key := th.spec + "\xff" + accountID
+			if amount <= th.amount || n.notified[key] == periodStart {
+				continue
+			}
+			err := n.send(notification{
+				Threshold: th.spec,
+				Period:    th.period,
+				Start:     periodStart,
+				Metric:    th.metric,
+				AccountID: accountID,
+				Service:   th.service,
+				Amount:    amount,
+				Limit:     th.amount,
+				Unit:      unit,
+			})
+			if err != nil {
+				log.Errorf("Can't send notification for threshold %s of account %s: %v", th.spec, accountID, err)
+				continue
+			}
+			n.notified[key] = periodStart
+		}
+	}
+}
+
+// query returns the daily spend of the target by service.
+func (n *notifier) query(t *target, start, end time.Time) ([]*costexplorer.ResultByTime, error) {
+	metrics := map[string]bool{}
+	for _, th := range n.thresholds {
+		metrics[th.metric] = true
+	}
+	input := &costexplorer.GetCostAndUsageInput{
+		Granularity: aws.String(costexplorer.GranularityDaily),
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(start.Format(dateFormat)),
+			End:   aws.String(end.Format(dateFormat)),
+		},
+		GroupBy: []*costexplorer.GroupDefinition{{
+			Type: aws.String(costexplorer.GroupDefinitionTypeDimension),
+			Key:  aws.String(costexplorer.DimensionService),
+		}},
+	}
+	for m := range metrics {
+		input.Metrics = append(input.Metrics, aws.String(m))
+	}
+
+	var results []*costexplorer.ResultByTime
+	for {
+		resp, err := t.client.GetCostAndUsage(input)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, resp.ResultsByTime...)
+		if aws.StringValue(resp.NextPageToken) == "" {
+			return results, nil
+		}
+		input.NextPageToken = resp.NextPageToken
+	}
+}
+
+// spend returns the start of the threshold's current period and the spend in
+// it. Results of a day may be split across pages.
+func (n *notifier) spend(th *threshold, results []*costexplorer.ResultByTime, monthStart time.Time) (periodStart string, amount float64, unit string) {
+	periodStart = monthStart.Format(dateFormat)
+	if th.period == "daily" {
+		periodStart = aws.StringValue(results[len(results)-1].TimePeriod.Start)
+	}
+	for _, r := range results {
+		if aws.StringValue(r.TimePeriod.Start) < periodStart {
+			continue
+		}
+		for _, g := range r.Groups {
+			if th.service != "" && (len(g.Keys) == 0 || aws.StringValue(g.Keys[0]) != th.service) {
+				continue
+			}
+			if f, u, ok := n.exporter.amount(g.Metrics[th.metric]); ok {
+				amount += f
+				unit = u
+			}
+		}
+	}
+	return periodStart, amount, unit
+}
+
+// queryForecast returns the cost forecast for the threshold's metric and
+// service between the given days.
+func (n *notifier) queryForecast(t *target, th *threshold, start, end time.Time) (float64, error) {
+	input := &costexplorer.GetCostForecastInput{
+		Metric:      aws.String(th.forecastMetric()),
+		Granularity: aws.String(costexplorer.GranularityMonthly),
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(start.Format(dateFormat)),
+			End:   aws.String(end.Format(dateFormat)),
+		},
+	}
+	if th.service != "" {
+		input.Filter = &costexplorer.Expression{Dimensions: &costexplorer.DimensionValues{
+			Key:    aws.String(costexplorer.DimensionService),
+			Values: aws.StringSlice([]string{th.service}),
+		}}
+	}
+	resp, err := t.client.GetCostForecast(input)
+	if err != nil {
+		return 0, err
+	}
+	f, _, ok := n.exporter.amount(resp.Total)
+	if !ok {
+		return 0, fmt.Errorf("no forecast returned")
+	}
+	return f, nil
+}
+
+// send delivers the notification to all sinks. It fails if any sink fails.
+func (n *notifier) send(payload notification) error {
+	var errs []string
+	for _, s := range n.sinks {
+		if err := s.send(payload); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+func TestParseThreshold(t *testing.T) {
+	th, err := parseThreshold("mtd:UnblendedCost@123456789012/Amazon Simple Storage Service>1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := threshold{
+		spec:      "mtd:UnblendedCost@123456789012/Amazon Simple Storage Service>1000",
+		period:    "mtd",
+		metric:    "UnblendedCost",
+		accountID: "123456789012",
+		service:   "Amazon Simple Storage Service",
+		amount:    1000,
+	}
+	if *th != want {
+		t.Errorf("want %+v, got %+v", want, *th)
+	}
+
+	for _, s := range []string{"", "daily:UnblendedCost", "weekly:UnblendedCost>1", "daily:Unblended>1", "daily:UnblendedCost>x"} {
+		if _, err := parseThreshold(s); err == nil {
+			t.Errorf("expected error for threshold %q", s)
+		}
+	}
+}
+
+// dailyByService returns daily results starting on the given day, with the
+// unblended cost of each service.
+func dailyByService(start time.Time, days ...map[string]string) []*costexplorer.ResultByTime {
+	var results []*costexplorer.ResultByTime
+	for i, services := range days {
+		r := &costexplorer.ResultByTime{TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(start.AddDate(0, 0, i).Format(dateFormat)),
+			End:   aws.String(start.AddDate(0, 0, i+1).Format(dateFormat)),
+		}}
+		for service, amount := range services {
+			r.Groups = append(r.Groups, &costexplorer.Group{
+				Keys:    aws.StringSlice([]string{service}),
+				Metrics: map[string]*costexplorer.MetricValue{"UnblendedCost": {Amount: aws.String(amount), Unit: aws.String("USD")}},
+			})
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestNotifier(t *testing.T) {
+	var received []notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		received = append(received, n)
+	}))
+	defer server.Close()
+
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := newNotifier(e, []string{
+		"daily:UnblendedCost>100",
+		"mtd:UnblendedCost/Amazon S3>150",
+		"mtd:UnblendedCost@210987654321>1",
+		"forecast:UnblendedCost>250",
+	}, []notificationSink{newWebhookSink(server.URL, basicAuth{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	monthStart := time.Date(2019, 7, 1, 0, 0, 0, 0, time.Local)
+	n.fetch = func(*target, time.Time, time.Time) ([]*costexplorer.ResultByTime, error) {
+		return dailyByService(monthStart,
+			map[string]string{"Amazon S3": "80", "Amazon EC2": "10"},
+			map[string]string{"Amazon S3": "90", "Amazon EC2": "20"},
+		), nil
+	}
+	n.forecast = func(_ *target, th *threshold, start, end time.Time) (float64, error) {
+		if th.forecastMetric() != "UNBLENDED_COST" || start.Day() != 3 || end.Month() != time.August {
+			t.Errorf("unexpected forecast query for %s from %s to %s", th.forecastMetric(), start, end)
+		}
+		return 100, nil
+	}
+
+	end := monthStart.AddDate(0, 0, 2)
+	n.evaluate(end)
+	n.evaluate(end)
+	if len(received) != 3 {
+		t.Fatalf("want 3 notifications, got %+v", received)
+	}
+	if r := received[0]; r.Period != "daily" || r.Start != "2019-07-02" || r.Amount != 110 || r.Unit != "USD" {
+		t.Errorf("unexpected daily notification %+v", r)
+	}
+	if r := received[1]; r.Period != "mtd" || r.Start != "2019-07-01" || r.Service != "Amazon S3" || r.Amount != 170 {
+		t.Errorf("unexpected month to date notification %+v", r)
+	}
+	if r := received[2]; r.Period != "forecast" || r.Start != "2019-07-01" || r.Amount != 300 {
+		t.Errorf("unexpected forecast notification %+v", r)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+const dateFormat = "2006-01-02"
+
+// dateRange is a range of days, including start and excluding end.
+type dateRange struct {
+	start time.Time
+	end   time.Time
+}
+
+func (r dateRange) contains(day time.Time) bool {
+	return !day.Before(r.start) && day.Before(r.end)
+}
+
+// windowConfig selects the days the cost and usage query covers besides the
+// last two complete days needed for day-over-day changes.
+type windowConfig struct {
+	// comparePeriods covers the previous windows of all period
+	// comparisons.
+	comparePeriods bool
+	// runRateDays covers that many days for the run rate.
+	runRateDays int
+	// historyDays covers that many days for the history.
+	historyDays int
+	// monthToDate covers the month of the last complete day.
+	monthToDate bool
+}
+
+// comparison is a pair of equally long date ranges whose totals are compared.
+type comparison struct {
+	period   string
+	current  dateRange
+	previous dateRange
+}
+
+// comparisons returns the periods compared on the given day: the last seven
+// complete days against the seven days before, and the month to date against
+// the same days of the previous month. The month is skipped on its first day,
+// when there is no complete day to compare yet.
+func comparisons(today time.Time) []comparison {
+	week := comparison{
+		period:   "week",
+		current:  dateRange{today.AddDate(0, 0, -7), today},
+		previous: dateRange{today.AddDate(0, 0, -14), today.AddDate(0, 0, -7)},
+	}
+
+	monthStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	if !today.After(monthStart) {
+		return []comparison{week}
+	}
+	days := today.Day() - 1
+	previousStart := monthStart.AddDate(0, -1, 0)
+	previousEnd := previousStart.AddDate(0, 0, days)
+	if previousEnd.After(monthStart) {
+		previousEnd = monthStart
+	}
+	month := comparison{
+		period:   "month",
+		current:  dateRange{monthStart, today},
+		previous: dateRange{previousStart, previousEnd},
+	}
+	return []comparison{week, month}
+}
+
+// comparisonStart returns the first day needed to compute all comparisons on
+// the given day.
+func comparisonStart(today time.Time) time.Time {
+	start := today
+	for _, c := range comparisons(today) {
+		if c.previous.start.Before(start) {
+			start = c.previous.start
+		}
+	}
+	return start
+}
+
+// periodTotals sums the daily values of the named metric over both ranges of
+// the comparison, using parse to read the values.
+func periodTotals(results []*costexplorer.ResultByTime, c comparison, awsName string, parse func(*costexplorer.MetricValue) (float64, string, bool)) (current, previous float64, unit string) {
+	for _, r := range results {
+		if r.TimePeriod == nil {
+			continue
+		}
+		day, err := time.ParseInLocation(dateFormat, aws.StringValue(r.TimePeriod.Start), c.current.end.Location())
+		if err != nil {
+			continue
+		}
+		amount, u, ok := parse(r.Total[awsName])
+		if !ok {
+			continue
+		}
+		switch {
+		case c.current.contains(day):
+			current += amount
+			unit = u
+		case c.previous.contains(day):
+			previous += amount
+		}
+	}
+	return current, previous, unit
+}
+
+// averageGroups returns the groups of the results with the average daily
+// value of each metric over the given number of days. Days without a group
+// count as zero.
+func averageGroups(results []*costexplorer.ResultByTime, metrics []string, days int) []*costexplorer.Group {
+	type total struct {
+		amount float64
+		unit   string
+	}
+	var groups []*costexplorer.Group
+	totals := map[string]map[string]*total{}
+	for _, r := range results {
+		for _, g := range r.Groups {
+			key := strings.Join(aws.StringValueSlice(g.Keys), "\x00")
+			t, ok := totals[key]
+			if !ok {
+				t = map[string]*total{}
+				totals[key] = t
+				groups = append(groups, &costexplorer.Group{Keys: g.Keys, Metrics: map[string]*costexplorer.MetricValue{}})
+			}
+			for _, m := range metrics {
+				v := g.Metrics[m]
+				if v == nil {
+					continue
+				}
+				f, err := strconv.ParseFloat(aws.StringValue(v.Amount), 64)
+				if err != nil {
+					continue
+				}
+				if t[m] == nil {
+					t[m] = &total{}
+				}
+				t[m].amount += f
+				t[m].unit = aws.StringValue(v.Unit)
+			}
+		}
+	}
+	for _, g := range groups {
+		for m, t := range totals[strings.Join(aws.StringValueSlice(g.Keys), "\x00")] {
+			g.Metrics[m] = &costexplorer.MetricValue{
+				Amount: aws.String(strconv.FormatFloat(t.amount/float64(days), 'f', -1, 64)),
+				Unit:   aws.String(t.unit),
+			}
+		}
+	}
+	return groups
+}
+
+// monthStart returns the first day of the month of the given day.
+func monthStart(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+}
+
+// today returns the current day at midnight in the local time zone.
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComparisons(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(dateFormat, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	for _, tc := range []struct {
+		today          string
+		monthPrevStart string
+		monthPrevEnd   string
+	}{
+		{"2019-07-20", "2019-06-01", "2019-06-20"},
+		{"2019-03-31", "2019-02-01", "2019-03-01"},
+		{"2019-07-01", "", ""},
+	} {
+		cs := comparisons(day(tc.today))
+		if !cs[0].previous.start.Equal(day(tc.today).AddDate(0, 0, -14)) {
+			t.Errorf("%s: unexpected week comparison %+v", tc.today, cs[0])
+		}
+		if tc.monthPrevStart == "" {
+			if len(cs) != 1 {
+				t.Errorf("%s: expected no month comparison, got %+v", tc.today, cs)
+			}
+			continue
+		}
+		month := cs[1].previous
+		if !month.start.Equal(day(tc.monthPrevStart)) || !month.end.Equal(day(tc.monthPrevEnd)) {
+			t.Errorf("%s: want previous month %s to %s, got %s to %s", tc.today,
+				tc.monthPrevStart, tc.monthPrevEnd, month.start.Format(dateFormat), month.end.Format(dateFormat))
+		}
+	}
+
+	// The month to date is one hour short of ten days after the spring
+	// forward, which must still count as ten days.
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	cs := comparisons(time.Date(2024, time.March, 11, 0, 0, 0, 0, loc))
+	if end := cs[1].previous.end; !end.Equal(time.Date(2024, time.February, 11, 0, 0, 0, 0, loc)) {
+		t.Errorf("want the previous month to end on 2024-02-11 across daylight saving time, got %s", end.Format(dateFormat))
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/prometheus/common/log"
+)
+
+// permission is an IAM action the exporter calls, with the feature it is
+// called for.
+type permission struct {
+	Action  string `json:"action"`
+	Feature string `json:"feature"`
+}
+
+// permissionResult is the outcome of simulating a permission.
+type permissionResult struct {
+	permission
+	Allowed  bool   `json:"allowed"`
+	Decision string `json:"decision"`
+}
+
+// permissionCheck is the result of checking the permissions of an account.
+type permissionCheck struct {
+	AccountID string `json:"account_id"`
+	Principal string `json:"principal,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Warning explains why the permissions of the principal weren't
+	// checked, e.g. because the policy simulator doesn't support it.
+	Warning     string             `json:"warning,omitempty"`
+	Permissions []permissionResult `json:"permissions,omitempty"`
+}
+
+// permissions returns the IAM actions called in each account by the enabled
+// features of the exporter, followed by extra ones of features registered
+// separately from it.
+func (e *Exporter) permissions(extra []permission) []permission {
+	var perms []permission
+	add := func(feature string, actions ...string) {
+		for _, a := range actions {
+			perms = append(perms, permission{Action: a, Feature: feature})
+		}
+	}
+	if e.dataExports {
+		add("data-exports", "bcm-data-exports:GetExport", "s3:ListBucket", "s3:GetObject")
+	} else {
+		add("costusage", "ce:GetCostAndUsage")
+	}
+	add("dimensions-api", "ce:GetDimensionValues")
+	if e.budgets != nil {
+		add("budgets", "budgets:ViewBudget")
+	}
+	if e.forecast != nil {
+		add("forecast", "ce:GetCostForecast")
+	}
+	if e.hourly != nil {
+		add("hourly", "ce:GetCostAndUsage")
+	}
+	if e.trustedAdvisor != nil {
+		add("trusted-advisor", "support:DescribeTrustedAdvisorChecks", "support:DescribeTrustedAdvisorCheckSummaries")
+	}
+	if e.computeOptimizer != nil {
+		add("compute-optimizer", "compute-optimizer:GetRecommendationSummaries")
+	}
+	if e.reservations != nil {
+		add("reservations", "ec2:DescribeReservedInstances", "rds:DescribeReservedDBInstances", "elasticache:DescribeReservedCacheNodes")
+	}
+	if e.savingsPlans != nil {
+		add("savings-plans", "savingsplans:DescribeSavingsPlans")
+	}
+	if e.costCategories != nil {
+		add("cost-categories", "ce:ListCostCategoryDefinitions")
+	}
+	if e.tags != nil {
+		add("cost-allocation-tags", "ce:ListCostAllocationTags")
+	}
+	if e.billingConductor != nil {
+		add("billing-conductor", "billingconductor:ListBillingGroups", "billingconductor:ListBillingGroupCostReports", "billingconductor:ListCustomLineItems")
+	}
+	if len(e.presets) > 0 || len(e.tagBreakdowns) > 0 || len(e.currentJobs()) > 0 {
+		add("breakdowns", "ce:GetCostAndUsage")
+	}
+	for _, b := range e.tagBreakdowns {
+		if len(b.groupBy) <= maxGroupBy {
+			continue
+		}
+		if b.groupType == costexplorer.GroupDefinitionTypeCostCategory {
+			add("cost-category-values", "ce:GetCostCategories")
+		} else {
+			add("tag-values", "ce:GetTags")
+		}
+	}
+	return append(perms, extra...)
+}
+
+// permcheckHandler serves the permissions of the principal of each account
+// as JSON, simulated with the IAM policy simulator, so that missing
+// permissions show up before the first scrape fails. The simulation itself
+// needs iam:SimulatePrincipalPolicy, and iam:GetRole for assumed roles, and
+// doesn't account for service control policies or permission boundaries.
+func (e *Exporter) permcheckHandler(extra []permission) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perms := e.permissions(extra)
+		checks := make([]permissionCheck, 0, len(e.targets))
+		for _, t := range e.targets {
+			c := permissionCheck{}
+			var err error
+			if c.AccountID, err = t.AccountID(r.Context()); err != nil {
+				c.Error = err.Error()
+			} else if err = t.checkPermissions(r.Context(), &c, perms); err != nil {
+				c.Error = err.Error()
+			}
+			checks = append(checks, c)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(checks); err != nil {
+			log.Errorf("Can't write permission check: %v", err)
+		}
+	})
+}
+
+// checkPermissions simulates the given permissions for the principal of the
+// target and records its ARN and the results in c. Principals the policy
+// simulator doesn't support are skipped with a warning.
+func (t *target) checkPermissions(ctx context.Context, c *permissionCheck, perms []permission) error {
+	identity, err := t.sts.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return fmt.Errorf("can't get caller identity: %v", err)
+	}
+	c.Principal = aws.StringValue(identity.Arn)
+	principal, err := t.principalARN(ctx, c.Principal)
+	if err != nil {
+		return err
+	}
+	if principal == "" {
+		c.Warning = fmt.Sprintf("the IAM policy simulator doesn't support the principal %s, its permissions are not checked", c.Principal)
+		log.Warnf("Not checking the permissions of account %s: %s", c.AccountID, c.Warning)
+		return nil
+	}
+	c.Principal = principal
+
+	var actions []string
+	for _, p := range perms {
+		actions = append(actions, p.Action)
+	}
+	decisions := map[string]string{}
+	err = t.iam.SimulatePrincipalPolicyPagesWithContext(ctx, &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(principal),
+		ActionNames:     aws.StringSlice(actions),
+	}, func(page *iam.SimulatePolicyResponse, lastPage bool) bool {
+		for _, r := range page.EvaluationResults {
+			decisions[aws.StringValue(r.EvalActionName)] = aws.StringValue(r.EvalDecision)
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("can't simulate the policies of %s: %v", principal, err)
+	}
+
+	c.Permissions = make([]permissionResult, 0, len(perms))
+	for _, p := range perms {
+		d := decisions[p.Action]
+		c.Permissions = append(c.Permissions, permissionResult{permission: p, Allowed: d == iam.PolicyEvaluationDecisionTypeAllowed, Decision: d})
+	}
+	return nil
+}
+
+// principalARN returns the ARN of the IAM user or role behind a caller
+// identity. Assumed role sessions map to their role, which is looked up with
+// iam:GetRole since its path isn't part of the session ARN. It returns an
+// empty ARN for principals the policy simulator doesn't support, federated
+// users and the root user.
+func (t *target) principalARN(ctx context.Context, callerARN string) (string, error) {
+	a, err := arn.Parse(callerARN)
+	if err != nil {
+		return "", fmt.Errorf("invalid caller ARN %q: %v", callerARN, err)
+	}
+	parts := strings.Split(a.Resource, "/")
+	switch {
+	case a.Service == "iam" && a.Resource == "root":
+		return "", nil
+	case a.Service != "sts":
+		return callerARN, nil
+	case len(parts) >= 2 && parts[0] == "assumed-role":
+		role, err := t.iam.GetRoleWithContext(ctx, &iam.GetRoleInput{RoleName: aws.String(parts[1])})
+		if err != nil {
+			return "", fmt.Errorf("can't get role %s of %s: %v", parts[1], callerARN, err)
+		}
+		return aws.StringValue(role.Role.Arn), nil
+	default:
+		return "", nil
+	}
+}
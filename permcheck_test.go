@@ -0,0 +1,152 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const simulatePolicy = `<SimulatePrincipalPolicyResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/">
+<SimulatePrincipalPolicyResult>
+<IsTruncated>false</IsTruncated>
+<EvaluationResults>
+<member><EvalActionName>ce:GetCostAndUsage</EvalActionName><EvalDecision>allowed</EvalDecision><EvalResourceName>*</EvalResourceName></member>
+<member><EvalActionName>ce:GetDimensionValues</EvalActionName><EvalDecision>allowed</EvalDecision><EvalResourceName>*</EvalResourceName></member>
+<member><EvalActionName>budgets:ViewBudget</EvalActionName><EvalDecision>implicitDeny</EvalDecision><EvalResourceName>*</EvalResourceName></member>
+</EvaluationResults>
+</SimulatePrincipalPolicyResult>
+</SimulatePrincipalPolicyResponse>`
+
+func TestPermcheckHandler(t *testing.T) {
+	var source string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("Action") {
+		case "GetCallerIdentity":
+			w.Write([]byte(callerIdentity))
+		case "SimulatePrincipalPolicy":
+			source = r.Form.Get("PolicySourceArn")
+			w.Write([]byte(simulatePolicy))
+		default:
+			http.Error(w, "unexpected action", http.StatusBadRequest)
+		}
+	}))
+	defer s.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+	tg := newTarget(sess, awsConfig{partition: "aws"})
+	tg.accountID = "123456789012"
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{tg}, metrics, exporterOptions{subsystems: []string{"server"}, budgets: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	e.permcheckHandler(nil).ServeHTTP(w, httptest.NewRequest("GET", "/-/permcheck", nil))
+	var checks []permissionCheck
+	if err := json.Unmarshal(w.Body.Bytes(), &checks); err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 1 || checks[0].Error != "" {
+		t.Fatalf("unexpected checks %+v", checks)
+	}
+	if source != "arn:aws:iam::123456789012:user/billing" || checks[0].Principal != source {
+		t.Errorf("want the policies of the caller simulated, got %q", source)
+	}
+	want := map[string]bool{"ce:GetCostAndUsage": true, "ce:GetDimensionValues": true, "budgets:ViewBudget": false}
+	if len(checks[0].Permissions) != len(want) {
+		t.Fatalf("want %d permissions, got %+v", len(want), checks[0].Permissions)
+	}
+	for _, p := range checks[0].Permissions {
+		if allowed, ok := want[p.Action]; !ok || allowed != p.Allowed {
+			t.Errorf("unexpected result %+v", p)
+		}
+	}
+}
+
+func TestPrincipalARN(t *testing.T) {
+	var roles []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "GetRole" {
+			http.Error(w, "unexpected action", http.StatusBadRequest)
+			return
+		}
+		roles = append(roles, r.Form.Get("RoleName"))
+		fmt.Fprintf(w, getRole, r.Form.Get("RoleName"))
+	}))
+	defer s.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+	tg := newTarget(sess, awsConfig{partition: "aws"})
+
+	for _, c := range []struct {
+		caller, want string
+	}{
+		{"arn:aws:iam::123456789012:user/billing", "arn:aws:iam::123456789012:user/billing"},
+		{"arn:aws:sts::123456789012:assumed-role/billing/aws_billing_exporter", "arn:aws:iam::123456789012:role/exporters/billing"},
+		{"arn:aws:sts::123456789012:federated-user/billing", ""},
+		{"arn:aws:iam::123456789012:root", ""},
+	} {
+		if got, err := tg.principalARN(context.Background(), c.caller); err != nil || got != c.want {
+			t.Errorf("principalARN(%q) = %q, %v, want %q", c.caller, got, err, c.want)
+		}
+	}
+	if len(roles) != 1 || roles[0] != "billing" {
+		t.Errorf("want the assumed role looked up, got %q", roles)
+	}
+
+	federated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(federatedIdentity))
+	}))
+	defer federated.Close()
+	tg.sts = sts.New(session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(federated.URL),
+	})))
+	c := permissionCheck{AccountID: "123456789012"}
+	if err := tg.checkPermissions(context.Background(), &c, nil); err != nil || c.Warning == "" || c.Permissions != nil {
+		t.Errorf("want the federated user skipped with a warning, got %+v, %v", c, err)
+	}
+}
+
+const getRole = `<GetRoleResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/">
+<GetRoleResult><Role><Path>/exporters/</Path><RoleName>%[1]s</RoleName><Arn>arn:aws:iam::123456789012:role/exporters/%[1]s</Arn><RoleId>AROAEXAMPLE</RoleId><CreateDate>2019-07-01T00:00:00Z</CreateDate></Role></GetRoleResult>
+</GetRoleResponse>`
+
+const federatedIdentity = `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+<GetCallerIdentityResult><Arn>arn:aws:sts::123456789012:federated-user/billing</Arn><UserId>123456789012:billing</UserId><Account>123456789012</Account></GetCallerIdentityResult>
+</GetCallerIdentityResponse>`
@@ -0,0 +1,573 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// preset is a predefined cost and usage breakdown: a query grouped by up to
+// two dimensions and optionally filtered, exported with the group keys as
+// labels.
+type preset struct {
+	help    string
+	groupBy []presetGroup
+	filter  *costexplorer.Expression
+	// keep, if not nil, selects the groups to export, for values the cost
+	// and usage API can't filter on.
+	keep func(keys []string) bool
+	// negate exports amounts with the opposite sign, e.g. credits, which
+	// are negative costs, as the positive amount consumed.
+	negate bool
+	// groupType is the type of the groups, tags or cost categories named
+	// by their dimension, or dimensions if empty.
+	groupType string
+	// monthToDate also exports the month to date, with one more query, for
+	// amounts that are checked per month, e.g. discounts and credits.
+	monthToDate bool
+}
+
+// maxGroupBy is the number of keys Cost Explorer groups by at most in one
+// query.
+const maxGroupBy = 2
+
+// presetGroup is a dimension a preset groups by, and the label its values
+// are exported as.
+type presetGroup struct {
+	dimension string
+	label     string
+}
+
+// presets are the available breakdowns by name.
+var presets = map[string]*preset{
+	"marketplace": {
+		help: "by billing entity and legal entity, separating AWS Marketplace and reseller charges from native AWS spend",
+		groupBy: []presetGroup{
+			{costexplorer.DimensionBillingEntity, "billing_entity"},
+			{costexplorer.DimensionLegalEntityName, "legal_entity"},
+		},
+	},
+	"spot": {
+		help: "of Spot Instances by instance type and region",
+		groupBy: []presetGroup{
+			{costexplorer.DimensionInstanceType, "instance_type"},
+			{costexplorer.DimensionRegion, "region"},
+		},
+		filter: dimensionFilter(costexplorer.DimensionPurchaseType, "Spot Instances"),
+	},
+	"data-transfer": {
+		help:    "of data transfer by usage type group, e.g. EC2: Data Transfer - Inter AZ",
+		groupBy: []presetGroup{{costexplorer.DimensionUsageTypeGroup, "usage_type_group"}},
+		keep:    keyContains("Data Transfer"),
+	},
+	"discounts": {
+		help:        "of discounts by record type, e.g. Enterprise Discount Program Discount",
+		groupBy:     []presetGroup{{costexplorer.DimensionRecordType, "record_type"}},
+		filter:      dimensionFilter(costexplorer.DimensionRecordType, discountRecordTypes...),
+		monthToDate: true,
+	},
+	"tax": {
+		help: "of tax by legal entity and service",
+		groupBy: []presetGroup{
+			{costexplorer.DimensionLegalEntityName, "legal_entity"},
+			{costexplorer.DimensionService, "service"},
+		},
+		filter: dimensionFilter(costexplorer.DimensionRecordType, "Tax"),
+	},
+	"credits": {
+		help:        "of credits consumed by service, as a positive amount",
+		groupBy:     []presetGroup{{costexplorer.DimensionService, "service"}},
+		filter:      dimensionFilter(costexplorer.DimensionRecordType, "Credit"),
+		negate:      true,
+		monthToDate: true,
+	},
+	"availability-zone": {
+		help: "by availability zone and service",
+		groupBy: []presetGroup{
+			{costexplorer.DimensionAz, "availability_zone"},
+			{costexplorer.DimensionService, "service"},
+		},
+	},
+	"operation": {
+		help: "by service and API operation, e.g. RunInstances",
+		groupBy: []presetGroup{
+			{costexplorer.DimensionService, "service"},
+			{costexplorer.DimensionOperation, "operation"},
+		},
+	},
+	"platform": {
+		help: "by platform, e.g. Windows or Linux/UNIX, and service",
+		groupBy: []presetGroup{
+			{costexplorer.DimensionPlatform, "platform"},
+			{costexplorer.DimensionService, "service"},
+		},
+	},
+	"database-engine": {
+		help:    "of Amazon RDS by database engine",
+		groupBy: []presetGroup{{costexplorer.DimensionDatabaseEngine, "database_engine"}},
+		filter:  dimensionFilter(costexplorer.DimensionService, "Amazon Relational Database Service"),
+	},
+	"tenancy": {
+		help:    "of Amazon EC2 instances by tenancy, e.g. Shared or Dedicated",
+		groupBy: []presetGroup{{costexplorer.DimensionTenancy, "tenancy"}},
+		filter:  dimensionFilter(costexplorer.DimensionService, "Amazon Elastic Compute Cloud - Compute"),
+	},
+	"instance-family": {
+		help: "of Amazon EC2 instances by instance family and region, e.g. to sum NormalizedUsageAmount for size-flexible reservations",
+		groupBy: []presetGroup{
+			{costexplorer.DimensionInstanceTypeFamily, "instance_family"},
+			{costexplorer.DimensionRegion, "region"},
+		},
+		filter: dimensionFilter(costexplorer.DimensionService, "Amazon Elastic Compute Cloud - Compute"),
+	},
+	"deployment-option": {
+		help: "by deployment option, e.g. Single-AZ or Multi-AZ, and service",
+		groupBy: []presetGroup{
+			{costexplorer.DimensionDeploymentOption, "deployment_option"},
+			{costexplorer.DimensionService, "service"},
+		},
+		keep: keyContains("AZ"),
+	},
+	"savings-plan": {
+		help:    "by savings plan ARN, for usage covered by savings plans",
+		groupBy: []presetGroup{{costexplorer.DimensionSavingsPlanArn, "savings_plan_arn"}},
+		keep:    keyContains(":savingsplan/"),
+	},
+	"usage-type-group": {
+		help: "by service and usage type group, e.g. EC2: Running Hours",
+		groupBy: []presetGroup{
+			{costexplorer.DimensionService, "service"},
+			{costexplorer.DimensionUsageTypeGroup, "usage_type_group"},
+		},
+	},
+}
+
+// discountRecordTypes are the record types of negotiated and bundled
+// discounts.
+var discountRecordTypes = []string{
+	"Discount",
+	"BundledDiscount",
+	"Bundled Discount",
+	"EdpDiscount",
+	"Enterprise Discount Program Discount",
+	"PrivateRateDiscount",
+	"Private Rate Discount",
+}
+
+// keyContains returns a function keeping the groups whose first key contains
+// the given string.
+func keyContains(s string) func(keys []string) bool {
+	return func(keys []string) bool {
+		return strings.Contains(keys[0], s)
+	}
+}
+
+// dimensionFilter returns an expression matching the given values of a
+// dimension.
+func dimensionFilter(dimension string, values ...string) *costexplorer.Expression {
+	return &costexplorer.Expression{Dimensions: &costexplorer.DimensionValues{
+		Key:    aws.String(dimension),
+		Values: aws.StringSlice(values),
+	}}
+}
+
+// presetNames returns the names of the available presets.
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// presetCollector exports the selected billing metrics of the last complete
+// day broken down as defined by a preset.
+type presetCollector struct {
+	*preset
+	name    string
+	metrics []string
+	layout  labelLayout
+	lastDay *prometheus.Desc
+	// serviceNames shortens the values of service labels.
+	serviceNames serviceNames
+	// runRateDays, if positive, widens the query to that many days to
+	// export the average daily value of each group as runRate.
+	runRateDays int
+	runRate     *prometheus.Desc
+	// month exports the month to date if the preset has monthToDate set.
+	month *prometheus.Desc
+	// lastCalls is the number of Cost Explorer calls of the last update,
+	// which varies with the values of the keys beyond maxGroupBy.
+	lastCalls int32
+}
+
+func newPresetCollector(name string, metrics []string, layout labelLayout, constLabels prometheus.Labels) (*presetCollector, error) {
+	p, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid preset %q, valid values are: %s", name, strings.Join(presetNames(), ", "))
+	}
+	return newBreakdownCollector(name, p, metrics, layout, constLabels), nil
+}
+
+// newTagCollector returns a collector breaking the metrics down by the given
+// tag keys or cost categories, as given by groupType, each exported under a
+// label sanitized from its name, so that every series carries a value for
+// each of them. Cost Explorer groups by at most two keys: further keys are
+// covered by one query per combination of their values, see query. It also
+// returns the mapping of the names to the labels.
+func newTagCollector(groupType string, keys, metrics []string, layout labelLayout, constLabels prometheus.Labels) (*presetCollector, []labelMapping, error) {
+	name, help := "tag", "by tag "
+	if groupType == costexplorer.GroupDefinitionTypeCostCategory {
+		name, help = "cost-category", "by cost category "
+	}
+	reserved := layout.names()
+	for l := range constLabels {
+		reserved = append(reserved, l)
+	}
+	labels := uniqueLabelNames(keys, reserved)
+
+	p := &preset{groupType: groupType}
+	var unique []string
+	for _, k := range keys {
+		if _, ok := labels[k]; !ok {
+			continue
+		}
+		p.groupBy = append(p.groupBy, presetGroup{k, labels[k]})
+		unique = append(unique, k)
+		delete(labels, k)
+	}
+	if len(unique) > 1 {
+		help += strings.Join(unique[:len(unique)-1], ", ") + " and "
+	}
+	p.help = help + unique[len(unique)-1]
+
+	c := newBreakdownCollector(name, p, metrics, layout, constLabels)
+	var mappings []labelMapping
+	for _, g := range p.groupBy {
+		mappings = append(mappings, labelMapping{metric: prometheus.BuildFQName(namespace, strings.Replace(name, "-", "_", -1), "last_day"), label: g.label, source: g.dimension})
+	}
+	return c, mappings, nil
+}
+
+func newBreakdownCollector(name string, p *preset, metrics []string, layout labelLayout, constLabels prometheus.Labels) *presetCollector {
+	labelNames := layout.names()
+	for _, g := range p.groupBy {
+		labelNames = append(labelNames, g.label)
+	}
+	runRateLabelNames := concatLabels(labelNames, "days")
+	subsystem := strings.Replace(name, "-", "_", -1)
+	return &presetCollector{
+		preset:  p,
+		name:    name,
+		metrics: metrics,
+		layout:  layout,
+		lastDay: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "last_day"),
+			"Billing metric given by the type label for the last complete day, "+p.help+".", labelNames, constLabels),
+		runRate: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "daily_run_rate"),
+			"Average daily value of the billing metric given by the type label over the last complete days given by the days label, "+p.help+".", runRateLabelNames, constLabels),
+		month: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "month_to_date"),
+			"Billing metric given by the type label for the month to date, "+p.help+".", labelNames, constLabels),
+	}
+}
+
+// calls returns the number of Cost Explorer calls of an update. With keys
+// beyond maxGroupBy, it is that of the last update, or the least possible
+// before the first.
+func (c *presetCollector) calls() int {
+	if n := atomic.LoadInt32(&c.lastCalls); n > 0 {
+		return int(n)
+	}
+	calls := 1
+	if c.monthToDate {
+		calls = 2
+	}
+	if len(c.groupBy) > maxGroupBy {
+		calls += len(c.groupBy) - maxGroupBy
+	}
+	return calls
+}
+
+// keys returns the dimensions, tag keys or cost categories of the breakdown.
+func (c *presetCollector) keys() []string {
+	keys := make([]string, len(c.groupBy))
+	for i, g := range c.groupBy {
+		keys[i] = g.dimension
+	}
+	return keys
+}
+
+func (c *presetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lastDay
+	if c.runRateDays > 0 {
+		ch <- c.runRate
+	}
+	if c.monthToDate {
+		ch <- c.month
+	}
+}
+
+// update exports the breakdown of the target's account. Amounts are parsed
+// with parse, so that the exporter's fixed exchange rate applies.
+func (c *presetCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string, parse func(*costexplorer.MetricValue) (float64, string, bool)) error {
+	end := today()
+	days := 1
+	if c.runRateDays > days {
+		days = c.runRateDays
+	}
+	input := &costexplorer.GetCostAndUsageInput{
+		Metrics:     aws.StringSlice(c.metrics),
+		Granularity: aws.String(costexplorer.GranularityDaily),
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(end.AddDate(0, 0, -days).Format(dateFormat)),
+			End:   aws.String(end.Format(dateFormat)),
+		},
+		Filter: c.filter,
+	}
+	for i, g := range c.groupBy {
+		if i == maxGroupBy {
+			break
+		}
+		input.GroupBy = append(input.GroupBy, &costexplorer.GroupDefinition{
+			Type: aws.String(c.definitionType()),
+			Key:  aws.String(g.dimension),
+		})
+	}
+
+	calls := 0
+	if len(c.groupBy) > maxGroupBy {
+		defer func() {
+			atomic.StoreInt32(&c.lastCalls, int32(calls))
+		}()
+	}
+	results, err := c.query(ctx, t, input, &calls)
+	if err != nil {
+		return err
+	}
+	c.collect(ch, dayGroups(results, end.AddDate(0, 0, -1)), accountID, parse)
+	if c.runRateDays > 0 {
+		c.export(ch, c.runRate, averageGroups(results, c.metrics, c.runRateDays), accountID, parse, strconv.Itoa(c.runRateDays))
+	}
+	if !c.monthToDate {
+		return nil
+	}
+
+	// The month to date is the previous month on the first.
+	start := monthStart(end.AddDate(0, 0, -1))
+	input.Granularity = aws.String(costexplorer.GranularityMonthly)
+	input.TimePeriod.Start = aws.String(start.Format(dateFormat))
+	input.NextPageToken = nil
+	if results, err = c.query(ctx, t, input, &calls); err != nil {
+		return err
+	}
+	c.export(ch, c.month, dayGroups(results, start), accountID, parse)
+	return nil
+}
+
+// definitionType returns the type of the groups of the breakdown.
+func (c *presetCollector) definitionType() string {
+	if c.groupType == "" {
+		return costexplorer.GroupDefinitionTypeDimension
+	}
+	return c.groupType
+}
+
+// query returns the results of the breakdown, grouped by the first
+// maxGroupBy keys as given by input, counting its calls. The keys beyond are
+// covered by one query per combination of their values in the time period,
+// filtered on those values, whose groups are then extended by the values.
+func (c *presetCollector) query(ctx context.Context, t *target, input *costexplorer.GetCostAndUsageInput, calls *int) ([]*costexplorer.ResultByTime, error) {
+	if len(c.groupBy) <= maxGroupBy {
+		*calls++
+		return queryResults(ctx, t, input)
+	}
+	extra := c.groupBy[maxGroupBy:]
+	combinations := [][]string{nil}
+	for _, g := range extra {
+		values, err := c.values(ctx, t, input.TimePeriod, g.dimension)
+		if err != nil {
+			return nil, err
+		}
+		*calls++
+		var next [][]string
+		for _, combination := range combinations {
+			for _, v := range values {
+				next = append(next, concatLabels(combination, v))
+			}
+		}
+		combinations = next
+	}
+
+	var all []*costexplorer.ResultByTime
+	for _, combination := range combinations {
+		filtered := *input
+		filtered.NextPageToken = nil
+		var filters []*costexplorer.Expression
+		if input.Filter != nil {
+			filters = append(filters, input.Filter)
+		}
+		for i, v := range combination {
+			filters = append(filters, c.valueFilter(extra[i].dimension, v))
+		}
+		if len(filters) == 1 {
+			filtered.Filter = filters[0]
+		} else {
+			filtered.Filter = &costexplorer.Expression{And: filters}
+		}
+		results, err := queryResults(ctx, t, &filtered)
+		if err != nil {
+			return nil, err
+		}
+		*calls++
+		for _, r := range results {
+			for _, g := range r.Groups {
+				for i, v := range combination {
+					g.Keys = append(g.Keys, aws.String(extra[i].dimension+"$"+v))
+				}
+			}
+		}
+		all = append(all, results...)
+	}
+	return all, nil
+}
+
+// values returns the values of a tag key or cost category in the time
+// period, including the empty value of resources without one.
+func (c *presetCollector) values(ctx context.Context, t *target, period *costexplorer.DateInterval, key string) ([]string, error) {
+	values := []string{""}
+	add := func(vs []*string) {
+		for _, v := range aws.StringValueSlice(vs) {
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	if c.groupType == costexplorer.GroupDefinitionTypeCostCategory {
+		input := &costexplorer.GetCostCategoriesInput{TimePeriod: period, CostCategoryName: aws.String(key)}
+		for {
+			resp, err := t.client.GetCostCategoriesWithContext(ctx, input)
+			if err != nil {
+				return nil, err
+			}
+			add(resp.CostCategoryValues)
+			if aws.StringValue(resp.NextPageToken) == "" {
+				return values, nil
+			}
+			input.NextPageToken = resp.NextPageToken
+		}
+	}
+	input := &costexplorer.GetTagsInput{TimePeriod: period, TagKey: aws.String(key)}
+	for {
+		resp, err := t.client.GetTagsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		add(resp.Tags)
+		if aws.StringValue(resp.NextPageToken) == "" {
+			return values, nil
+		}
+		input.NextPageToken = resp.NextPageToken
+	}
+}
+
+// valueFilter returns the filter on a value of a tag key or cost category,
+// the empty value matching resources without one.
+func (c *presetCollector) valueFilter(key, value string) *costexplorer.Expression {
+	values, match := []*string{aws.String(value)}, costexplorer.MatchOptionEquals
+	if value == "" {
+		values, match = nil, costexplorer.MatchOptionAbsent
+	}
+	if c.groupType == costexplorer.GroupDefinitionTypeCostCategory {
+		return &costexplorer.Expression{CostCategories: &costexplorer.CostCategoryValues{
+			Key: aws.String(key), Values: values, MatchOptions: aws.StringSlice([]string{match}),
+		}}
+	}
+	return &costexplorer.Expression{Tags: &costexplorer.TagValues{
+		Key: aws.String(key), Values: values, MatchOptions: aws.StringSlice([]string{match}),
+	}}
+}
+
+// queryResults returns the results of all pages of a cost and usage query.
+// The groups of a day may be split across several results.
+func queryResults(ctx context.Context, t *target, input *costexplorer.GetCostAndUsageInput) ([]*costexplorer.ResultByTime, error) {
+	var results []*costexplorer.ResultByTime
+	for {
+		resp, err := t.client.GetCostAndUsageWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, resp.ResultsByTime...)
+		if aws.StringValue(resp.NextPageToken) == "" {
+			return results, nil
+		}
+		input.NextPageToken = resp.NextPageToken
+	}
+}
+
+// dayGroups returns the groups of the results of the given day.
+func dayGroups(results []*costexplorer.ResultByTime, day time.Time) []*costexplorer.Group {
+	var groups []*costexplorer.Group
+	for _, r := range results {
+		if resultDay(r) == day.Format(dateFormat) {
+			groups = append(groups, r.Groups...)
+		}
+	}
+	return groups
+}
+
+// collect exports the metrics of the groups of a breakdown.
+func (c *presetCollector) collect(ch chan<- prometheus.Metric, groups []*costexplorer.Group, accountID string, parse func(*costexplorer.MetricValue) (float64, string, bool)) {
+	c.export(ch, c.lastDay, groups, accountID, parse)
+}
+
+// export exports the metrics of the groups as desc, with the extra label
+// values following those of the group keys.
+func (c *presetCollector) export(ch chan<- prometheus.Metric, desc *prometheus.Desc, groups []*costexplorer.Group, accountID string, parse func(*costexplorer.MetricValue) (float64, string, bool), extra ...string) {
+	for _, g := range groups {
+		keys := aws.StringValueSlice(g.Keys)
+		if len(keys) != len(c.groupBy) || c.keep != nil && !c.keep(keys) {
+			continue
+		}
+		for i, g := range c.groupBy {
+			switch {
+			case c.groupType != "":
+				// Tag and cost category keys come as NAME$VALUE.
+				keys[i] = sanitizeLabelValue(strings.TrimPrefix(keys[i], g.dimension+"$"))
+			case g.dimension == costexplorer.DimensionService:
+				keys[i] = c.serviceNames.short(keys[i])
+			}
+		}
+		for _, awsName := range c.metrics {
+			f, unit, ok := parse(g.Metrics[awsName])
+			if !ok {
+				continue
+			}
+			if c.negate {
+				f = -f
+			}
+			labels := c.layout.values(awsName, unit, accountID, append(keys, extra...)...)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, f, labels...)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// presetMetrics returns the metrics a preset collector exports for the
+// given groups.
+func presetMetrics(t *testing.T, name string, groups ...*costexplorer.Group) prometheus.Collector {
+	c, err := newPresetCollector(name, []string{"UnblendedCost"}, labelLayout{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter(nil, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan prometheus.Metric, len(groups))
+	c.collect(ch, groups, "123456789012", e.amount)
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func group(amount string, keys ...string) *costexplorer.Group {
+	return &costexplorer.Group{
+		Keys:    aws.StringSlice(keys),
+		Metrics: map[string]*costexplorer.MetricValue{"UnblendedCost": {Amount: aws.String(amount), Unit: aws.String("USD")}},
+	}
+}
+
+func TestPresets(t *testing.T) {
+	metrics := presetMetrics(t, "marketplace",
+		group("100", "AWS", "Amazon Web Services, Inc."),
+		group("25", "AWS Marketplace", "Example Software LLC"),
+	)
+	expected := `
+# HELP aws_billing_marketplace_last_day Billing metric given by the type label for the last complete day, by billing entity and legal entity, separating AWS Marketplace and reseller charges from native AWS spend.
+# TYPE aws_billing_marketplace_last_day gauge
+aws_billing_marketplace_last_day{account_id="123456789012",billing_entity="AWS",currency="USD",legal_entity="Amazon Web Services, Inc.",type="UnblendedCost",unit=""} 100
+aws_billing_marketplace_last_day{account_id="123456789012",billing_entity="AWS Marketplace",currency="USD",legal_entity="Example Software LLC",type="UnblendedCost",unit=""} 25
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "spot", group("12.5", "m5.large", "eu-west-1"))
+	expected = `
+# HELP aws_billing_spot_last_day Billing metric given by the type label for the last complete day, of Spot Instances by instance type and region.
+# TYPE aws_billing_spot_last_day gauge
+aws_billing_spot_last_day{account_id="123456789012",currency="USD",instance_type="m5.large",region="eu-west-1",type="UnblendedCost",unit=""} 12.5
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if f := presets["spot"].filter; aws.StringValue(f.Dimensions.Key) != "PURCHASE_TYPE" {
+		t.Errorf("unexpected spot filter %v", f)
+	}
+
+	metrics = presetMetrics(t, "data-transfer",
+		group("3", "EC2: Data Transfer - Inter AZ"),
+		group("50", "EC2: Running Hours"),
+	)
+	expected = `
+# HELP aws_billing_data_transfer_last_day Billing metric given by the type label for the last complete day, of data transfer by usage type group, e.g. EC2: Data Transfer - Inter AZ.
+# TYPE aws_billing_data_transfer_last_day gauge
+aws_billing_data_transfer_last_day{account_id="123456789012",currency="USD",type="UnblendedCost",unit="",usage_type_group="EC2: Data Transfer - Inter AZ"} 3
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "discounts",
+		group("-40", "Enterprise Discount Program Discount"),
+		group("-2", "Bundled Discount"),
+	)
+	expected = `
+# HELP aws_billing_discounts_last_day Billing metric given by the type label for the last complete day, of discounts by record type, e.g. Enterprise Discount Program Discount.
+# TYPE aws_billing_discounts_last_day gauge
+aws_billing_discounts_last_day{account_id="123456789012",currency="USD",record_type="Bundled Discount",type="UnblendedCost",unit=""} -2
+aws_billing_discounts_last_day{account_id="123456789012",currency="USD",record_type="Enterprise Discount Program Discount",type="UnblendedCost",unit=""} -40
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	if f := presets["tax"].filter; aws.StringValue(f.Dimensions.Key) != "RECORD_TYPE" || aws.StringValue(f.Dimensions.Values[0]) != "Tax" {
+		t.Errorf("unexpected tax filter %v", f)
+	}
+	if f := presets["discounts"].filter; aws.StringValue(f.Dimensions.Key) != "RECORD_TYPE" || !contains(aws.StringValueSlice(f.Dimensions.Values), "BundledDiscount") || contains(aws.StringValueSlice(f.Dimensions.Values), "Usage") {
+		t.Errorf("unexpected discounts filter %v", f)
+	}
+
+	metrics = presetMetrics(t, "credits", group("-7.5", "Amazon Elastic Compute Cloud - Compute"))
+	expected = `
+# HELP aws_billing_credits_last_day Billing metric given by the type label for the last complete day, of credits consumed by service, as a positive amount.
+# TYPE aws_billing_credits_last_day gauge
+aws_billing_credits_last_day{account_id="123456789012",currency="USD",service="Amazon Elastic Compute Cloud - Compute",type="UnblendedCost",unit=""} 7.5
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "availability-zone",
+		group("30", "eu-west-1a", "Amazon Elastic Compute Cloud - Compute"),
+		group("10", "eu-west-1b", "Amazon Elastic Compute Cloud - Compute"),
+	)
+	expected = `
+# HELP aws_billing_availability_zone_last_day Billing metric given by the type label for the last complete day, by availability zone and service.
+# TYPE aws_billing_availability_zone_last_day gauge
+aws_billing_availability_zone_last_day{account_id="123456789012",availability_zone="eu-west-1a",currency="USD",service="Amazon Elastic Compute Cloud - Compute",type="UnblendedCost",unit=""} 30
+aws_billing_availability_zone_last_day{account_id="123456789012",availability_zone="eu-west-1b",currency="USD",service="Amazon Elastic Compute Cloud - Compute",type="UnblendedCost",unit=""} 10
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "operation", group("4.2", "Amazon Simple Storage Service", "PutObject"))
+	expected = `
+# HELP aws_billing_operation_last_day Billing metric given by the type label for the last complete day, by service and API operation, e.g. RunInstances.
+# TYPE aws_billing_operation_last_day gauge
+aws_billing_operation_last_day{account_id="123456789012",currency="USD",operation="PutObject",service="Amazon Simple Storage Service",type="UnblendedCost",unit=""} 4.2
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "platform",
+		group("48", "Windows", "Amazon Elastic Compute Cloud - Compute"),
+		group("20", "Linux/UNIX", "Amazon Elastic Compute Cloud - Compute"),
+	)
+	expected = `
+# HELP aws_billing_platform_last_day Billing metric given by the type label for the last complete day, by platform, e.g. Windows or Linux/UNIX, and service.
+# TYPE aws_billing_platform_last_day gauge
+aws_billing_platform_last_day{account_id="123456789012",currency="USD",platform="Linux/UNIX",service="Amazon Elastic Compute Cloud - Compute",type="UnblendedCost",unit=""} 20
+aws_billing_platform_last_day{account_id="123456789012",currency="USD",platform="Windows",service="Amazon Elastic Compute Cloud - Compute",type="UnblendedCost",unit=""} 48
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "database-engine", group("15", "Aurora PostgreSQL"), group("9", "Oracle"))
+	expected = `
+# HELP aws_billing_database_engine_last_day Billing metric given by the type label for the last complete day, of Amazon RDS by database engine.
+# TYPE aws_billing_database_engine_last_day gauge
+aws_billing_database_engine_last_day{account_id="123456789012",currency="USD",database_engine="Aurora PostgreSQL",type="UnblendedCost",unit=""} 15
+aws_billing_database_engine_last_day{account_id="123456789012",currency="USD",database_engine="Oracle",type="UnblendedCost",unit=""} 9
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "tenancy", group("70", "Shared"), group("30", "Dedicated"))
+	expected = `
+# HELP aws_billing_tenancy_last_day Billing metric given by the type label for the last complete day, of Amazon EC2 instances by tenancy, e.g. Shared or Dedicated.
+# TYPE aws_billing_tenancy_last_day gauge
+aws_billing_tenancy_last_day{account_id="123456789012",currency="USD",tenancy="Dedicated",type="UnblendedCost",unit=""} 30
+aws_billing_tenancy_last_day{account_id="123456789012",currency="USD",tenancy="Shared",type="UnblendedCost",unit=""} 70
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "deployment-option",
+		group("12", "Multi-AZ", "Amazon Relational Database Service"),
+		group("6", "Single-AZ", "Amazon Relational Database Service"),
+		group("90", "NoDeploymentOption", "Amazon Elastic Compute Cloud - Compute"),
+	)
+	expected = `
+# HELP aws_billing_deployment_option_last_day Billing metric given by the type label for the last complete day, by deployment option, e.g. Single-AZ or Multi-AZ, and service.
+# TYPE aws_billing_deployment_option_last_day gauge
+aws_billing_deployment_option_last_day{account_id="123456789012",currency="USD",deployment_option="Multi-AZ",service="Amazon Relational Database Service",type="UnblendedCost",unit=""} 12
+aws_billing_deployment_option_last_day{account_id="123456789012",currency="USD",deployment_option="Single-AZ",service="Amazon Relational Database Service",type="UnblendedCost",unit=""} 6
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "savings-plan",
+		group("24", "arn:aws:savingsplans::123456789012:savingsplan/1a2b3c4d"),
+		group("100", "NoSavingsPlanArn"),
+	)
+	expected = `
+# HELP aws_billing_savings_plan_last_day Billing metric given by the type label for the last complete day, by savings plan ARN, for usage covered by savings plans.
+# TYPE aws_billing_savings_plan_last_day gauge
+aws_billing_savings_plan_last_day{account_id="123456789012",currency="USD",savings_plan_arn="arn:aws:savingsplans::123456789012:savingsplan/1a2b3c4d",type="UnblendedCost",unit=""} 24
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	metrics = presetMetrics(t, "usage-type-group", group("50", "Amazon Elastic Compute Cloud - Compute", "EC2: Running Hours"))
+	expected = `
+# HELP aws_billing_usage_type_group_last_day Billing metric given by the type label for the last complete day, by service and usage type group, e.g. EC2: Running Hours.
+# TYPE aws_billing_usage_type_group_last_day gauge
+aws_billing_usage_type_group_last_day{account_id="123456789012",currency="USD",service="Amazon Elastic Compute Cloud - Compute",type="UnblendedCost",unit="",usage_type_group="EC2: Running Hours"} 50
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := newPresetCollector("unknown", nil, labelLayout{}, nil); err == nil {
+		t.Error("expected error for an unknown preset")
+	}
+}
+
+func TestPresetRunRate(t *testing.T) {
+	end := today()
+	day := func(n int) string { return end.AddDate(0, 0, -n).Format(dateFormat) }
+	var requests []string
+	ce := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(b))
+		if len(requests) == 1 {
+			fmt.Fprintf(w, `{"ResultsByTime": [
+{"TimePeriod": {"Start": %q}, "Groups": [{"Keys": ["MySQL"], "Metrics": {"UnblendedCost": {"Amount": "-30", "Unit": "USD"}}}]},
+{"TimePeriod": {"Start": %q}, "Groups": []}], "NextPageToken": "2"}`, day(3), day(2))
+			return
+		}
+		fmt.Fprintf(w, `{"ResultsByTime": [{"TimePeriod": {"Start": %q}, "Groups": [{"Keys": ["MySQL"], "Metrics": {"UnblendedCost": {"Amount": "-15", "Unit": "USD"}}}]}]}`, day(1))
+	}))
+	defer ce.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ce.URL),
+	}))
+
+	c, err := newPresetCollector("database-engine", []string{"UnblendedCost"}, labelLayout{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.runRateDays = 3
+	e, err := NewExporter(nil, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan prometheus.Metric, 2)
+	if err := c.update(context.Background(), ch, &target{client: costexplorer.New(sess)}, "123456789012", e.amount); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	if !strings.Contains(requests[0], `"Start":"`+day(3)+`"`) {
+		t.Errorf("want the query widened to the run rate days, got %s", requests[0])
+	}
+	expected := `
+# HELP aws_billing_database_engine_daily_run_rate Average daily value of the billing metric given by the type label over the last complete days given by the days label, ` + presets["database-engine"].help + `.
+# TYPE aws_billing_database_engine_daily_run_rate gauge
+aws_billing_database_engine_daily_run_rate{account_id="123456789012",currency="USD",database_engine="MySQL",days="3",type="UnblendedCost",unit=""} -15
+# HELP aws_billing_database_engine_last_day Billing metric given by the type label for the last complete day, ` + presets["database-engine"].help + `.
+# TYPE aws_billing_database_engine_last_day gauge
+aws_billing_database_engine_last_day{account_id="123456789012",currency="USD",database_engine="MySQL",type="UnblendedCost",unit=""} -15
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTagCollectorMoreKeys(t *testing.T) {
+	yesterday := today().AddDate(0, 0, -1).Format(dateFormat)
+	var queries []string
+	ce := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if strings.HasSuffix(r.Header.Get("X-Amz-Target"), ".GetTags") {
+			if !strings.Contains(string(b), `"TagKey":"app"`) {
+				t.Errorf("want the values of the third key listed, got %s", b)
+			}
+			fmt.Fprint(w, `{"Tags": ["web", "api"]}`)
+			return
+		}
+		queries = append(queries, string(b))
+		amount := "5"
+		switch {
+		case strings.Contains(string(b), `"Values":["web"]`):
+			amount = "10"
+		case strings.Contains(string(b), `"Values":["api"]`):
+			amount = "20"
+		}
+		fmt.Fprintf(w, `{"ResultsByTime": [{"TimePeriod": {"Start": %q}, "Groups": [{"Keys": ["team$payments", "env$prod"], "Metrics": {"UnblendedCost": {"Amount": %q, "Unit": "USD"}}}]}]}`, yesterday, amount)
+	}))
+	defer ce.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ce.URL),
+	}))
+
+	c, _, err := newTagCollector(costexplorer.GroupDefinitionTypeTag, []string{"team", "env", "app"}, []string{"UnblendedCost"}, labelLayout{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter(nil, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) error {
+		return c.update(context.Background(), ch, &target{client: costexplorer.New(sess)}, "123456789012", e.amount)
+	})
+	if len(queries) != 3 || c.calls() != 4 {
+		t.Errorf("want one query per value of the third key, got %d queries and %d calls", len(queries), c.calls())
+	}
+	for _, q := range queries {
+		if strings.Count(q, `"Key":`) != 3 || !strings.Contains(q, `"Key":"app"`) {
+			t.Errorf("want a query grouped by two keys and filtered on the third, got %s", q)
+		}
+	}
+	expected := `
+# HELP aws_billing_tag_last_day Billing metric given by the type label for the last complete day, by tag team, env and app.
+# TYPE aws_billing_tag_last_day gauge
+aws_billing_tag_last_day{account_id="123456789012",app="",currency="USD",env="prod",team="payments",type="UnblendedCost",unit=""} 5
+aws_billing_tag_last_day{account_id="123456789012",app="api",currency="USD",env="prod",team="payments",type="UnblendedCost",unit=""} 20
+aws_billing_tag_last_day{account_id="123456789012",app="web",currency="USD",env="prod",team="payments",type="UnblendedCost",unit=""} 10
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPresetMonthToDate(t *testing.T) {
+	end := today()
+	yesterday := end.AddDate(0, 0, -1).Format(dateFormat)
+	month := monthStart(end.AddDate(0, 0, -1)).Format(dateFormat)
+	var requests []string
+	ce := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(b))
+		start, amount := yesterday, "-7.5"
+		if strings.Contains(string(b), `"Granularity":"MONTHLY"`) {
+			start, amount = month, "-120"
+		}
+		fmt.Fprintf(w, `{"ResultsByTime": [{"TimePeriod": {"Start": %q}, "Groups": [{"Keys": ["AWS Lambda"], "Metrics": {"UnblendedCost": {"Amount": %q, "Unit": "USD"}}}]}]}`, start, amount)
+	}))
+	defer ce.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ce.URL),
+	}))
+
+	c, err := newPresetCollector("credits", []string{"UnblendedCost"}, labelLayout{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter(nil, nil, exporterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan prometheus.Metric, 2)
+	if err := c.update(context.Background(), ch, &target{client: costexplorer.New(sess)}, "123456789012", e.amount); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	if len(requests) != c.calls() || !strings.Contains(requests[1], `"Start":"`+month+`"`) {
+		t.Errorf("want a second query for the month to date, got %q", requests)
+	}
+	expected := `
+# HELP aws_billing_credits_last_day Billing metric given by the type label for the last complete day, of credits consumed by service, as a positive amount.
+# TYPE aws_billing_credits_last_day gauge
+aws_billing_credits_last_day{account_id="123456789012",currency="USD",service="AWS Lambda",type="UnblendedCost",unit=""} 7.5
+# HELP aws_billing_credits_month_to_date Billing metric given by the type label for the month to date, of credits consumed by service, as a positive amount.
+# TYPE aws_billing_credits_month_to_date gauge
+aws_billing_credits_month_to_date{account_id="123456789012",currency="USD",service="AWS Lambda",type="UnblendedCost",unit=""} 120
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pushSink posts the metrics of every refresh as a JSON document to a URL.
+// With a secret, the body is signed with HMAC-SHA256 in the
+// X-Signature-256 header as sha256=<hex digest>, so that the receiver can
+// verify it came from the exporter.
+type pushSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// pushPayload is the document posted by pushSink.
+type pushPayload struct {
+	Time    time.Time      `json:"time"`
+	Metrics []metricRecord `json:"metrics"`
+}
+
+func newPushSink(url, secret string, auth basicAuth) *pushSink {
+	return &pushSink{url: url, secret: secret, client: newWebhookClient(auth)}
+}
+
+func (s *pushSink) name() string {
+	return "webhook"
+}
+
+func (s *pushSink) push(families []*dto.MetricFamily, t time.Time) error {
+	b, err := json.Marshal(pushPayload{Time: t, Metrics: metricRecords(families, t)})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		req.Header.Set("X-Signature-256", "sha256="+signature(s.secret, b))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// signature returns the hex encoded HMAC-SHA256 of body with the secret.
+func signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPushSink(t *testing.T) {
+	desc := prometheus.NewDesc("aws_billing_blended_cost", "Blended cost.", []string{"type", "unit", "currency", "account_id"}, nil)
+	families, err := gather([]prometheus.Metric{prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 12.5, "BlendedCost", "", "USD", "123456789012")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body []byte
+	var sig, user, password string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		sig = r.Header.Get("X-Signature-256")
+		user, password, _ = r.BasicAuth()
+	}))
+	defer server.Close()
+
+	now := time.Unix(1561939200, 0).UTC()
+	if err := newPushSink(server.URL, "secret", basicAuth{Username: "exporter", Password: "pw"}).push(families, now); err != nil {
+		t.Fatal(err)
+	}
+	if user != "exporter" || password != "pw" {
+		t.Errorf("want basic-auth credentials, got %q and %q", user, password)
+	}
+	if want := "sha256=" + signature("secret", body); sig != want {
+		t.Errorf("want signature %q, got %q", want, sig)
+	}
+	var p pushPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Time.Equal(now) || len(p.Metrics) != 1 || p.Metrics[0].Labels["account_id"] != "123456789012" || p.Metrics[0].Value != 12.5 {
+		t.Errorf("unexpected payload %s", body)
+	}
+
+	// The digest of "{}" with the key "secret".
+	if got := signature("secret", []byte("{}")); got != "77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13" {
+		t.Errorf("unexpected signature %q", got)
+	}
+
+	if err := newPushSink(server.URL, "", basicAuth{}).push(families, now); err != nil || sig != "" {
+		t.Errorf("want no signature without a secret, got %q", sig)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// callBudget is a token bucket limiting the number of paid Cost Explorer
+// calls. It holds up to a day's worth of calls and refills continuously at
+// the daily cap.
+type callBudget struct {
+	mutex  sync.Mutex
+	cap    float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newCallBudget(perDay int) *callBudget {
+	b := &callBudget{
+		cap:    float64(perDay),
+		tokens: float64(perDay),
+		now:    time.Now,
+	}
+	b.last = b.now()
+	return b
+}
+
+// take takes n calls from the budget and returns true, or returns false
+// leaving the budget untouched if fewer than n calls are left.
+func (b *callBudget) take(n int) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Hours() / 24 * b.cap
+	if b.tokens > b.cap {
+		b.tokens = b.cap
+	}
+	b.last = now
+
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
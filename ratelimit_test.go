@@ -0,0 +1,46 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallBudget(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	b := newCallBudget(24)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	if !b.take(20) {
+		t.Fatal("want 20 of 24 calls allowed")
+	}
+	if b.take(5) {
+		t.Fatal("want 5 calls denied with 4 left")
+	}
+	if !b.take(4) {
+		t.Fatal("want the 4 remaining calls allowed")
+	}
+
+	now = now.Add(3 * time.Hour)
+	if !b.take(3) || b.take(1) {
+		t.Error("want 3 calls refilled after 3 hours")
+	}
+
+	now = now.Add(72 * time.Hour)
+	if !b.take(24) || b.take(1) {
+		t.Error("want the budget capped at the daily limit")
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elasticache"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var reservationLabelNames = []string{"account_id", "region", "service", "reservation_id", "instance_type"}
+
+// reservation is an active EC2, RDS or ElastiCache reservation.
+type reservation struct {
+	region       string
+	service      string
+	id           string
+	instanceType string
+	end          time.Time
+
+	count         int64
+	scope         string
+	paymentOption string
+	// offeringClass is the offering class of EC2 reservations, standard
+	// or convertible; RDS and ElastiCache reservations have none.
+	offeringClass string
+}
+
+// reservationsCollector exports the inventory and expiration of the active
+// reservations in the configured regions of each target, so that coverage
+// can be joined with what is owned and expiring reservations renewed before
+// coverage drops. Reservations are regional resources: those in other
+// regions are not seen.
+type reservationsCollector struct {
+	// regions to list reservations in; the region of the target if empty.
+	regions []string
+
+	info          *prometheus.Desc
+	offeringClass *prometheus.Desc
+	count         *prometheus.Desc
+	expiration    *prometheus.Desc
+	remaining     *prometheus.Desc
+}
+
+func newReservationsCollector(regions []string, constLabels prometheus.Labels) *reservationsCollector {
+	return &reservationsCollector{
+		regions: regions,
+		info: newInfoDesc("reservation",
+			"Terms of the reservation.", concatLabels(reservationLabelNames, "scope", "payment_option"), constLabels),
+		offeringClass: newInfoDesc("reservation_offering_class",
+			"Offering class of the EC2 reservation, standard or convertible.", concatLabels(reservationLabelNames, "offering_class"), constLabels),
+		count: prometheus.NewDesc(prometheus.BuildFQName(namespace, "reservation", "instances"),
+			"Number of instances or nodes reserved.", reservationLabelNames, constLabels),
+		expiration: prometheus.NewDesc(prometheus.BuildFQName(namespace, "reservation", "expiration_timestamp_seconds"),
+			"Time the reservation expires, in seconds since the epoch.", reservationLabelNames, constLabels),
+		remaining: prometheus.NewDesc(prometheus.BuildFQName(namespace, "reservation", "remaining_days"),
+			"Number of days until the reservation expires.", reservationLabelNames, constLabels),
+	}
+}
+
+func (c *reservationsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	ch <- c.offeringClass
+	ch <- c.count
+	ch <- c.expiration
+	ch <- c.remaining
+}
+
+// update exports the active reservations of the target's account.
+func (c *reservationsCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) error {
+	regions := c.regions
+	if len(regions) == 0 {
+		regions = []string{t.region}
+	}
+	var reservations []reservation
+	for _, region := range regions {
+		r, err := listReservations(ctx, t, region)
+		if err != nil {
+			return fmt.Errorf("%s: %v", region, err)
+		}
+		reservations = append(reservations, r...)
+	}
+	c.collect(ch, reservations, accountID, time.Now())
+	return nil
+}
+
+func (c *reservationsCollector) collect(ch chan<- prometheus.Metric, reservations []reservation, accountID string, now time.Time) {
+	for _, r := range reservations {
+		labels := []string{accountID, r.region, r.service, r.id, r.instanceType}
+		ch <- newInfoMetric(c.info, concatLabels(labels, r.scope, r.paymentOption)...)
+		if r.offeringClass != "" {
+			ch <- newInfoMetric(c.offeringClass, concatLabels(labels, r.offeringClass)...)
+		}
+		ch <- prometheus.MustNewConstMetric(c.count, prometheus.GaugeValue, float64(r.count), labels...)
+		ch <- prometheus.MustNewConstMetric(c.expiration, prometheus.GaugeValue, float64(r.end.Unix()), labels...)
+		ch <- prometheus.MustNewConstMetric(c.remaining, prometheus.GaugeValue, r.end.Sub(now).Hours()/24, labels...)
+	}
+}
+
+// listReservations returns the active EC2, RDS and ElastiCache reservations
+// of the target in the region.
+func listReservations(ctx context.Context, t *target, region string) ([]reservation, error) {
+	var reservations []reservation
+
+	ri, err := t.ec2(region).DescribeReservedInstancesWithContext(ctx, &ec2.DescribeReservedInstancesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("state"), Values: aws.StringSlice([]string{ec2.ReservedInstanceStateActive})}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ri.ReservedInstances {
+		reservations = append(reservations, reservation{
+			region:       region,
+			service:      "ec2",
+			id:           aws.StringValue(r.ReservedInstancesId),
+			instanceType: aws.StringValue(r.InstanceType),
+			end:          aws.TimeValue(r.End),
+
+			count:         aws.Int64Value(r.InstanceCount),
+			scope:         aws.StringValue(r.Scope),
+			offeringClass: aws.StringValue(r.OfferingClass),
+			paymentOption: aws.StringValue(r.OfferingType),
+		})
+	}
+
+	err = t.rds(region).DescribeReservedDBInstancesPagesWithContext(ctx, &rds.DescribeReservedDBInstancesInput{},
+		func(resp *rds.DescribeReservedDBInstancesOutput, lastPage bool) bool {
+			for _, r := range resp.ReservedDBInstances {
+				if aws.StringValue(r.State) != "active" {
+					continue
+				}
+				reservations = append(reservations, reservation{
+					region:       region,
+					service:      "rds",
+					id:           aws.StringValue(r.ReservedDBInstanceId),
+					instanceType: aws.StringValue(r.DBInstanceClass),
+					end:          aws.TimeValue(r.StartTime).Add(time.Duration(aws.Int64Value(r.Duration)) * time.Second),
+
+					count:         aws.Int64Value(r.DBInstanceCount),
+					scope:         ec2.ScopeRegion,
+					paymentOption: aws.StringValue(r.OfferingType),
+				})
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.elastiCache(region).DescribeReservedCacheNodesPagesWithContext(ctx, &elasticache.DescribeReservedCacheNodesInput{},
+		func(resp *elasticache.DescribeReservedCacheNodesOutput, lastPage bool) bool {
+			for _, r := range resp.ReservedCacheNodes {
+				if aws.StringValue(r.State) != "active" {
+					continue
+				}
+				reservations = append(reservations, reservation{
+					region:       region,
+					service:      "elasticache",
+					id:           aws.StringValue(r.ReservedCacheNodeId),
+					instanceType: aws.StringValue(r.CacheNodeType),
+					end:          aws.TimeValue(r.StartTime).Add(time.Duration(aws.Int64Value(r.Duration)) * time.Second),
+
+					count:         aws.Int64Value(r.CacheNodeCount),
+					scope:         ec2.ScopeRegion,
+					paymentOption: aws.StringValue(r.OfferingType),
+				})
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReservations(t *testing.T) {
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	c := newReservationsCollector(nil, nil)
+	ch := make(chan prometheus.Metric, 4)
+	c.collect(ch, []reservation{{
+		region:       "eu-west-1",
+		service:      "rds",
+		id:           "ri-2019-01-01",
+		instanceType: "db.r5.large",
+		end:          now.AddDate(0, 0, 30),
+
+		count:         2,
+		scope:         "Region",
+		paymentOption: "All Upfront",
+	}}, "123456789012", now)
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	expected := `
+# HELP aws_billing_reservation_expiration_timestamp_seconds Time the reservation expires, in seconds since the epoch.
+# TYPE aws_billing_reservation_expiration_timestamp_seconds gauge
+aws_billing_reservation_expiration_timestamp_seconds{account_id="123456789012",instance_type="db.r5.large",region="eu-west-1",reservation_id="ri-2019-01-01",service="rds"} 1.5645312e+09
+# HELP aws_billing_reservation_info Terms of the reservation.
+# TYPE aws_billing_reservation_info gauge
+aws_billing_reservation_info{account_id="123456789012",instance_type="db.r5.large",payment_option="All Upfront",region="eu-west-1",reservation_id="ri-2019-01-01",scope="Region",service="rds"} 1
+# HELP aws_billing_reservation_instances Number of instances or nodes reserved.
+# TYPE aws_billing_reservation_instances gauge
+aws_billing_reservation_instances{account_id="123456789012",instance_type="db.r5.large",region="eu-west-1",reservation_id="ri-2019-01-01",service="rds"} 2
+# HELP aws_billing_reservation_remaining_days Number of days until the reservation expires.
+# TYPE aws_billing_reservation_remaining_days gauge
+aws_billing_reservation_remaining_days{account_id="123456789012",instance_type="db.r5.large",region="eu-west-1",reservation_id="ri-2019-01-01",service="rds"} 30
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReservationRegions(t *testing.T) {
+	scope := regexp.MustCompile(`Credential=[^/]+/[^/]+/([^/]+)/`)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		region := scope.FindStringSubmatch(r.Header.Get("Authorization"))[1]
+		switch r.Form.Get("Action") {
+		case "DescribeReservedInstances":
+			fmt.Fprintf(w, `<DescribeReservedInstancesResponse><reservedInstancesSet><item>
+				<reservedInstancesId>ri-%s</reservedInstancesId><instanceType>m5.large</instanceType><instanceCount>1</instanceCount>
+				<scope>Region</scope><offeringClass>standard</offeringClass><offeringType>All Upfront</offeringType><end>2030-01-01T00:00:00Z</end>
+			</item></reservedInstancesSet></DescribeReservedInstancesResponse>`, region)
+		case "DescribeReservedDBInstances":
+			w.Write([]byte(`<DescribeReservedDBInstancesResponse><DescribeReservedDBInstancesResult><ReservedDBInstances/></DescribeReservedDBInstancesResult></DescribeReservedDBInstancesResponse>`))
+		case "DescribeReservedCacheNodes":
+			w.Write([]byte(`<DescribeReservedCacheNodesResponse><DescribeReservedCacheNodesResult><ReservedCacheNodes/></DescribeReservedCacheNodesResult></DescribeReservedCacheNodesResponse>`))
+		default:
+			http.Error(w, r.Form.Get("Action"), http.StatusBadRequest)
+		}
+	}))
+	defer s.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+
+	c := newReservationsCollector([]string{"eu-west-1", "us-west-2"}, nil)
+	ch := make(chan prometheus.Metric, 10)
+	if err := c.update(context.Background(), ch, newTarget(sess, awsConfig{partition: "aws"}), "123456789012"); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	expected := `
+# HELP aws_billing_reservation_instances Number of instances or nodes reserved.
+# TYPE aws_billing_reservation_instances gauge
+aws_billing_reservation_instances{account_id="123456789012",instance_type="m5.large",region="eu-west-1",reservation_id="ri-eu-west-1",service="ec2"} 1
+aws_billing_reservation_instances{account_id="123456789012",instance_type="m5.large",region="us-west-2",reservation_id="ri-us-west-2",service="ec2"} 1
+# HELP aws_billing_reservation_offering_class_info Offering class of the EC2 reservation, standard or convertible.
+# TYPE aws_billing_reservation_offering_class_info gauge
+aws_billing_reservation_offering_class_info{account_id="123456789012",instance_type="m5.large",offering_class="standard",region="eu-west-1",reservation_id="ri-eu-west-1",service="ec2"} 1
+aws_billing_reservation_offering_class_info{account_id="123456789012",instance_type="m5.large",offering_class="standard",region="us-west-2",reservation_id="ri-us-west-2",service="ec2"} 1
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected), "aws_billing_reservation_instances", "aws_billing_reservation_offering_class_info"); err != nil {
+		t.Error(err)
+	}
+}
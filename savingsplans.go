@@ -0,0 +1,121 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var savingsPlanLabelNames = []string{"account_id", "savings_plan_id", "savings_plan_type"}
+
+// savingsPlanStates are the states of the savings plans exported, all but
+// those of plans that ended.
+var savingsPlanStates = []string{
+	savingsplans.SavingsPlanStatePaymentPending,
+	savingsplans.SavingsPlanStatePaymentFailed,
+	savingsplans.SavingsPlanStateActive,
+	savingsplans.SavingsPlanStateQueued,
+	savingsplans.SavingsPlanStatePendingReturn,
+}
+
+// savingsPlansCollector exports the inventory, commitment, term and state of
+// the savings plans of each target's account, so that expiring plans and
+// plans pending payment are visible.
+type savingsPlansCollector struct {
+	info       *prometheus.Desc
+	term       *prometheus.Desc
+	commitment *prometheus.Desc
+	start      *prometheus.Desc
+	end        *prometheus.Desc
+	state      *prometheus.Desc
+}
+
+func newSavingsPlansCollector(layout labelLayout, constLabels prometheus.Labels) *savingsPlansCollector {
+	return &savingsPlansCollector{
+		info: newInfoDesc("savings_plan",
+			"Terms of the savings plan. The instance family and region only apply to EC2 Instance Savings Plans.",
+			concatLabels(savingsPlanLabelNames, "payment_option", "ec2_instance_family", "region"), constLabels),
+		term: prometheus.NewDesc(prometheus.BuildFQName(namespace, "savings_plan", "term_seconds"),
+			"Duration of the savings plan term.", savingsPlanLabelNames, constLabels),
+		commitment: prometheus.NewDesc(prometheus.BuildFQName(namespace, "savings_plan", "commitment"),
+			"Hourly commitment of the savings plan.", concatLabels(savingsPlanLabelNames, layout.currency()), constLabels),
+		start: prometheus.NewDesc(prometheus.BuildFQName(namespace, "savings_plan", "start_timestamp_seconds"),
+			"Time the savings plan starts, in seconds since the epoch.", savingsPlanLabelNames, constLabels),
+		end: prometheus.NewDesc(prometheus.BuildFQName(namespace, "savings_plan", "end_timestamp_seconds"),
+			"Time the savings plan ends, in seconds since the epoch.", savingsPlanLabelNames, constLabels),
+		state: prometheus.NewDesc(prometheus.BuildFQName(namespace, "savings_plan", "state"),
+			"Whether the savings plan is in the state given by the state label.", concatLabels(savingsPlanLabelNames, "state"), constLabels),
+	}
+}
+
+func (c *savingsPlansCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	ch <- c.term
+	ch <- c.commitment
+	ch <- c.start
+	ch <- c.end
+	ch <- c.state
+}
+
+// update exports the savings plans of the target's account.
+func (c *savingsPlansCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) error {
+	var plans []*savingsplans.SavingsPlan
+	input := &savingsplans.DescribeSavingsPlansInput{States: aws.StringSlice(savingsPlanStates)}
+	for {
+		resp, err := t.savingsPlans.DescribeSavingsPlansWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		plans = append(plans, resp.SavingsPlans...)
+		if aws.StringValue(resp.NextToken) == "" {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+	c.collect(ch, plans, accountID)
+	return nil
+}
+
+func (c *savingsPlansCollector) collect(ch chan<- prometheus.Metric, plans []*savingsplans.SavingsPlan, accountID string) {
+	for _, p := range plans {
+		labels := []string{accountID, aws.StringValue(p.SavingsPlanId), aws.StringValue(p.SavingsPlanType)}
+		ch <- newInfoMetric(c.info,
+			concatLabels(labels, aws.StringValue(p.PaymentOption), aws.StringValue(p.Ec2InstanceFamily), aws.StringValue(p.Region))...)
+		if p.TermDurationInSeconds != nil {
+			ch <- prometheus.MustNewConstMetric(c.term, prometheus.GaugeValue, float64(*p.TermDurationInSeconds), labels...)
+		}
+		if commitment, err := strconv.ParseFloat(aws.StringValue(p.Commitment), 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.commitment, prometheus.GaugeValue, commitment, concatLabels(labels, aws.StringValue(p.Currency))...)
+		}
+		if start, err := time.Parse(time.RFC3339, aws.StringValue(p.Start)); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.start, prometheus.GaugeValue, float64(start.Unix()), labels...)
+		}
+		if end, err := time.Parse(time.RFC3339, aws.StringValue(p.End)); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.end, prometheus.GaugeValue, float64(end.Unix()), labels...)
+		}
+		for _, state := range savingsPlanStates {
+			value := 0.0
+			if state == aws.StringValue(p.State) {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, value, concatLabels(labels, state)...)
+		}
+	}
+}
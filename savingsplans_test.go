@@ -0,0 +1,87 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSavingsPlans(t *testing.T) {
+	c := newSavingsPlansCollector(labelLayout{}, nil)
+	ch := make(chan prometheus.Metric, 12)
+	c.collect(ch, []*savingsplans.SavingsPlan{{
+		SavingsPlanId:         aws.String("sp-1"),
+		SavingsPlanType:       aws.String(savingsplans.SavingsPlanTypeCompute),
+		Commitment:            aws.String("1.5"),
+		Currency:              aws.String("USD"),
+		Start:                 aws.String("2019-07-01T00:00:00.000Z"),
+		End:                   aws.String("2020-07-01T00:00:00.000Z"),
+		State:                 aws.String(savingsplans.SavingsPlanStatePaymentFailed),
+		PaymentOption:         aws.String(savingsplans.SavingsPlanPaymentOptionNoUpfront),
+		TermDurationInSeconds: aws.Int64(31536000),
+	}}, "123456789012")
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	expected := `
+# HELP aws_billing_savings_plan_commitment Hourly commitment of the savings plan.
+# TYPE aws_billing_savings_plan_commitment gauge
+aws_billing_savings_plan_commitment{account_id="123456789012",currency="USD",savings_plan_id="sp-1",savings_plan_type="Compute"} 1.5
+# HELP aws_billing_savings_plan_end_timestamp_seconds Time the savings plan ends, in seconds since the epoch.
+# TYPE aws_billing_savings_plan_end_timestamp_seconds gauge
+aws_billing_savings_plan_end_timestamp_seconds{account_id="123456789012",savings_plan_id="sp-1",savings_plan_type="Compute"} 1.5935616e+09
+# HELP aws_billing_savings_plan_info Terms of the savings plan. The instance family and region only apply to EC2 Instance Savings Plans.
+# TYPE aws_billing_savings_plan_info gauge
+aws_billing_savings_plan_info{account_id="123456789012",ec2_instance_family="",payment_option="No Upfront",region="",savings_plan_id="sp-1",savings_plan_type="Compute"} 1
+# HELP aws_billing_savings_plan_term_seconds Duration of the savings plan term.
+# TYPE aws_billing_savings_plan_term_seconds gauge
+aws_billing_savings_plan_term_seconds{account_id="123456789012",savings_plan_id="sp-1",savings_plan_type="Compute"} 3.1536e+07
+# HELP aws_billing_savings_plan_state Whether the savings plan is in the state given by the state label.
+# TYPE aws_billing_savings_plan_state gauge
+aws_billing_savings_plan_state{account_id="123456789012",savings_plan_id="sp-1",savings_plan_type="Compute",state="active"} 0
+aws_billing_savings_plan_state{account_id="123456789012",savings_plan_id="sp-1",savings_plan_type="Compute",state="payment-failed"} 1
+aws_billing_savings_plan_state{account_id="123456789012",savings_plan_id="sp-1",savings_plan_type="Compute",state="payment-pending"} 0
+aws_billing_savings_plan_state{account_id="123456789012",savings_plan_id="sp-1",savings_plan_type="Compute",state="pending-return"} 0
+aws_billing_savings_plan_state{account_id="123456789012",savings_plan_id="sp-1",savings_plan_type="Compute",state="queued"} 0
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected), "aws_billing_savings_plan_commitment", "aws_billing_savings_plan_end_timestamp_seconds", "aws_billing_savings_plan_info", "aws_billing_savings_plan_term_seconds", "aws_billing_savings_plan_state"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSavingsPlansEndpoint(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("eu-west-1"),
+	}))
+	for partition, want := range map[string]string{
+		"aws":    "https://savingsplans.amazonaws.com",
+		"aws-cn": "https://savingsplans.cn-northwest-1.amazonaws.com.cn",
+	} {
+		if got := newTarget(sess, awsConfig{partition: partition}).savingsPlans.Endpoint; got != want {
+			t.Errorf("%s: got endpoint %s, want %s", partition, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// targetGroup is a target group of the Prometheus HTTP service discovery.
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler serves one target per account in the format of the Prometheus
+// HTTP service discovery. The targets point at the probe endpoint of the
+// exporter under the host name it was reached at, so that scrape configs
+// follow the accounts of the exporter without relabeling.
+func (e *Exporter) sdHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		groups := []targetGroup{}
+		for _, t := range e.targets {
+			accountID, err := t.AccountID(r.Context())
+			if err != nil {
+				http.Error(w, "can't get AWS account ID: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+			groups = append(groups, targetGroup{
+				Targets: []string{r.Host},
+				Labels: map[string]string{
+					"__metrics_path__": "/probe",
+					"__param_target":   accountID,
+					"account_id":       accountID,
+				},
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(groups); err != nil {
+			log.Errorf("Can't write service discovery targets: %v", err)
+		}
+	})
+}
+
+// probeHandler serves the metrics of the account given by the target
+// parameter, as discovered through the service discovery endpoint. With a
+// cache TTL or refresh schedule, they are taken from the exporter's cached
+// scrape of all accounts, so probes of different accounts share the cache.
+// Without, each probe scrapes the probed account only.
+func (e *Exporter) probeHandler(opts promhttp.HandlerOpts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accountID := r.URL.Query().Get("target")
+		if accountID == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		c := accountCollector{exporter: e, accountID: accountID}
+		if e.schedule == nil && e.cacheTTL <= 0 {
+			for _, t := range e.targets {
+				if id, err := t.AccountID(r.Context()); err == nil && id == accountID {
+					c.target = t
+					break
+				}
+			}
+			if c.target == nil {
+				http.Error(w, "unknown target "+accountID, http.StatusNotFound)
+				return
+			}
+		}
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(c)
+		promhttp.HandlerFor(reg, opts).ServeHTTP(w, r)
+	})
+}
+
+// accountCollector collects the metrics of the exporter labeled with a
+// single account. If target is set, it scrapes that target only instead of
+// collecting the exporter's cached metrics.
+type accountCollector struct {
+	exporter  *Exporter
+	accountID string
+	target    *target
+}
+
+// Describe describes nothing, making accountCollector an unchecked
+// collector, as the metrics of an account vary.
+func (c accountCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c accountCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		defer close(metrics)
+		if c.target == nil {
+			c.exporter.Collect(metrics)
+		} else if c.exporter.mayCall(c.exporter.callsPerTarget()) {
+			c.exporter.scrape(metrics, []*target{c.target}, &snapshot{})
+		}
+	}()
+	for m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			continue
+		}
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "account_id" && l.GetValue() == c.accountID {
+				ch <- m
+				break
+			}
+		}
+	}
+}
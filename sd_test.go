@@ -0,0 +1,96 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestServiceDiscovery(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "111111111111"}, {accountID: "222222222222"}}, metrics, exporterOptions{subsystems: []string{"server"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetched []string
+	e.fetch = func(_ context.Context, t *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		fetched = append(fetched, t.accountID)
+		return costAndUsage("100"), nil
+	}
+
+	w := httptest.NewRecorder()
+	e.sdHandler().ServeHTTP(w, httptest.NewRequest("GET", "http://exporter:9614/sd", nil))
+	var groups []targetGroup
+	if err := json.NewDecoder(w.Body).Decode(&groups); err != nil {
+		t.Fatal(err)
+	}
+	want := []targetGroup{
+		{Targets: []string{"exporter:9614"}, Labels: map[string]string{"__metrics_path__": "/probe", "__param_target": "111111111111", "account_id": "111111111111"}},
+		{Targets: []string{"exporter:9614"}, Labels: map[string]string{"__metrics_path__": "/probe", "__param_target": "222222222222", "account_id": "222222222222"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("got %+v, want %+v", groups, want)
+	}
+
+	w = httptest.NewRecorder()
+	e.probeHandler(promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/probe?target=222222222222", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `aws_billing_server_blended_cost{account_id="222222222222",currency="USD",type="BlendedCost",unit=""} 100`) {
+		t.Errorf("want the metrics of the probed account, got:\n%s", body)
+	}
+	if strings.Contains(body, "111111111111") {
+		t.Errorf("want no metrics of other accounts, got:\n%s", body)
+	}
+	if !reflect.DeepEqual(fetched, []string{"222222222222"}) {
+		t.Errorf("want only the probed account scraped without a cache, got %q", fetched)
+	}
+
+	w = httptest.NewRecorder()
+	e.probeHandler(promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/probe?target=333333333333", nil))
+	if w.Code != 404 {
+		t.Errorf("want 404 for an unknown target, got %d", w.Code)
+	}
+
+	// With a cache, probes of all accounts share a single scrape.
+	fetched = nil
+	e.cacheTTL = time.Hour
+	for _, target := range []string{"111111111111", "222222222222"} {
+		w = httptest.NewRecorder()
+		e.probeHandler(promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/probe?target="+target, nil))
+		if !strings.Contains(w.Body.String(), `account_id="`+target+`"`) {
+			t.Errorf("want the metrics of %s, got:\n%s", target, w.Body.String())
+		}
+	}
+	if len(fetched) != 2 {
+		t.Errorf("want each account scraped once, got %q", fetched)
+	}
+
+	w = httptest.NewRecorder()
+	e.probeHandler(promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/probe", nil))
+	if w.Code != 400 {
+		t.Errorf("want 400 without target, got %d", w.Code)
+	}
+}
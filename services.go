@@ -0,0 +1,104 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// serviceAbbreviations are the built-in short names of the services as
+// named by Cost Explorer.
+var serviceAbbreviations = map[string]string{
+	"AWS Backup":                             "backup",
+	"AWS CloudTrail":                         "cloudtrail",
+	"AWS Config":                             "config",
+	"AWS Data Transfer":                      "data-transfer",
+	"AWS Glue":                               "glue",
+	"AWS Key Management Service":             "kms",
+	"AWS Lambda":                             "lambda",
+	"AWS Secrets Manager":                    "secretsmanager",
+	"AWS Step Functions":                     "stepfunctions",
+	"AWS WAF":                                "waf",
+	"Amazon API Gateway":                     "apigateway",
+	"Amazon Athena":                          "athena",
+	"Amazon CloudFront":                      "cloudfront",
+	"Amazon DynamoDB":                        "dynamodb",
+	"Amazon EC2 Container Registry (ECR)":    "ecr",
+	"Amazon ElastiCache":                     "elasticache",
+	"Amazon Elastic Compute Cloud - Compute": "ec2",
+	"Amazon Elastic Container Service":       "ecs",
+	"Amazon Elastic Container Service for Kubernetes": "eks",
+	"Amazon Elastic File System":                      "efs",
+	"Amazon Elastic Load Balancing":                   "elb",
+	"Amazon Elastic MapReduce":                        "emr",
+	"Amazon GuardDuty":                                "guardduty",
+	"Amazon Kinesis":                                  "kinesis",
+	"Amazon OpenSearch Service":                       "opensearch",
+	"Amazon Redshift":                                 "redshift",
+	"Amazon Relational Database Service":              "rds",
+	"Amazon Route 53":                                 "route53",
+	"Amazon SageMaker":                                "sagemaker",
+	"Amazon Simple Notification Service":              "sns",
+	"Amazon Simple Queue Service":                     "sqs",
+	"Amazon Simple Storage Service":                   "s3",
+	"Amazon Virtual Private Cloud":                    "vpc",
+	"AmazonCloudWatch":                                "cloudwatch",
+	"EC2 - Other":                                     "ec2-other",
+}
+
+// serviceNames maps service names as returned by Cost Explorer to the short
+// names they are exported as. A nil map leaves names unchanged.
+type serviceNames map[string]string
+
+// newServiceNames returns the built-in short names, overridden or extended
+// by NAME=SHORT pairs.
+func newServiceNames(overrides []string) (serviceNames, error) {
+	names := serviceNames{}
+	for name, short := range serviceAbbreviations {
+		names[name] = short
+	}
+	for _, o := range overrides {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid service name mapping %q, expected NAME=SHORT", o)
+		}
+		names[kv[0]] = kv[1]
+	}
+	// Two services with the same short name would export duplicate series.
+	services := make([]string, 0, len(names))
+	for name := range names {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	seen := map[string]string{}
+	for _, name := range services {
+		short := names[name]
+		if other, ok := seen[short]; ok {
+			return nil, fmt.Errorf("services %q and %q have the same short name %q", other, name, short)
+		}
+		seen[short] = name
+	}
+	return names, nil
+}
+
+// short returns the short name of the given service, or the name itself if
+// it has none.
+func (n serviceNames) short(name string) string {
+	if short, ok := n[name]; ok {
+		return short
+	}
+	return name
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestServiceNames(t *testing.T) {
+	names, err := newServiceNames([]string{"Amazon Bedrock=bedrock", "Amazon Simple Storage Service=storage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"Amazon Elastic Compute Cloud - Compute": "ec2",
+		"Amazon Bedrock":                         "bedrock",
+		"Amazon Simple Storage Service":          "storage",
+		"Amazon Lightsail":                       "Amazon Lightsail",
+	} {
+		if got := names.short(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if got := serviceNames(nil).short("AWS Lambda"); got != "AWS Lambda" {
+		t.Errorf("want names unchanged without mapping, got %q", got)
+	}
+
+	for _, o := range []string{"Amazon Bedrock", "=bedrock", "Amazon Bedrock="} {
+		if _, err := newServiceNames([]string{o}); err == nil {
+			t.Errorf("%s: expected error", o)
+		}
+	}
+
+	for _, overrides := range [][]string{
+		{"Amazon Bedrock=ai", "Amazon SageMaker=ai"},
+		{"Amazon Bedrock=s3"},
+	} {
+		if _, err := newServiceNames(overrides); err == nil {
+			t.Errorf("%q: expected error for colliding short names", overrides)
+		}
+	}
+	if _, err := newServiceNames([]string{"Amazon Simple Storage Service=storage", "Amazon Bedrock=s3"}); err != nil {
+		t.Errorf("want a freed short name reusable, got %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/common/log"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// partition describes where the billing APIs live within an AWS partition.
+// Cost Explorer is not regionalized: each partition exposes a single
+// endpoint in one home region, which is also used to sign requests.
+type partition struct {
+	region     string
+	ceEndpoint string
+}
+
+var partitions = map[string]partition{
+	endpoints.AwsPartitionID: {
+		region:     "us-east-1",
+		ceEndpoint: "https://ce.us-east-1.amazonaws.com",
+	},
+	endpoints.AwsUsGovPartitionID: {
+		region:     "us-gov-west-1",
+		ceEndpoint: "https://ce.us-gov-west-1.amazonaws.com",
+	},
+	endpoints.AwsCnPartitionID: {
+		region:     "cn-northwest-1",
+		ceEndpoint: "https://ce.cn-northwest-1.amazonaws.com.cn",
+	},
+}
+
+// globalServiceRegion returns the region to create clients of a global
+// service, such as AWS Support, with in the partition: the partition endpoint
+// of the service, e.g. aws-global, which the SDK resolves to the home region
+// of the service, or the region of the partition if it has none.
+func globalServiceRegion(partitionID, serviceID string) string {
+	for _, p := range endpoints.DefaultPartitions() {
+		if p.ID() != partitionID {
+			continue
+		}
+		if s, ok := p.Services()[serviceID]; ok {
+			if _, ok := s.Endpoints()[partitionID+"-global"]; ok {
+				return partitionID + "-global"
+			}
+		}
+	}
+	return partitions[partitionID].region
+}
+
+// partitionNames returns the sorted IDs of the supported partitions.
+func partitionNames() []string {
+	names := make([]string, 0, len(partitions))
+	for name := range partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// awsConfig holds the settings used to build the AWS session.
+type awsConfig struct {
+	profile  string
+	roleARNs []string
+	// accountID, if set, is the account ID of the session's own
+	// credentials, used instead of looking it up with STS.
+	accountID   string
+	externalID  string
+	sessionName string
+	partition   string
+	ceEndpoint  string
+	// billingViewARN, if set, scopes Cost Explorer queries to an AWS
+	// Billing View.
+	billingViewARN string
+	httpProxy      string
+	httpsProxy     string
+	noProxy        string
+	caBundle       string
+	// vaultPath, if set, is the path of the Vault AWS secrets engine to
+	// fetch credentials from instead of using the default chain.
+	vaultAddress string
+	vaultToken   string
+	vaultPath    string
+	// debug logs the requests and responses of the SDK with credentials
+	// redacted.
+	debug bool
+}
+
+// loadCABundle returns a certificate pool holding the system roots plus the
+// PEM encoded certificates found in the given file.
+func loadCABundle(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", file)
+	}
+	return pool, nil
+}
+
+// newHTTPClient returns the HTTP client used for all AWS API calls. Proxy
+// settings are taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables, overridden by any that were set explicitly. If a CA
+// bundle is configured, its certificates are trusted in addition to the
+// system roots.
+func newHTTPClient(cfg awsConfig) (*http.Client, error) {
+	proxy := httpproxy.FromEnvironment()
+	if cfg.httpProxy != "" {
+		proxy.HTTPProxy = cfg.httpProxy
+	}
+	if cfg.httpsProxy != "" {
+		proxy.HTTPSProxy = cfg.httpsProxy
+	}
+	if cfg.noProxy != "" {
+		proxy.NoProxy = cfg.noProxy
+	}
+	proxyFunc := proxy.ProxyFunc()
+
+	tlsConfig := &tls.Config{}
+	if cfg.caBundle != "" {
+		pool, err := loadCABundle(cfg.caBundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				return proxyFunc(req.URL)
+			},
+			TLSClientConfig: tlsConfig,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}, nil
+}
+
+// newSession returns an AWS session using the named profile from the shared
+// config and credentials files, or the default credential chain if profile
+// is empty, or credentials from Vault if a Vault path is configured.
+// Sessions without a configured region default to the home region
+// of the selected partition, so that STS calls stay within it.
+func newSession(cfg awsConfig) (*session.Session, error) {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	config := aws.Config{
+		HTTPClient: client,
+	}
+	if cfg.vaultPath != "" {
+		p := newVaultProvider(cfg.vaultAddress, cfg.vaultToken, cfg.vaultPath, client)
+		go p.renewToken()
+		config.Credentials = credentials.NewCredentials(p)
+	}
+	if cfg.debug {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody | aws.LogDebugWithRequestRetries | aws.LogDebugWithRequestErrors)
+		config.Logger = aws.LoggerFunc(func(args ...interface{}) {
+			log.Info(redactCredentials(fmt.Sprint(args...)))
+		})
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            config,
+		Profile:           cfg.profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String(partitions[cfg.partition].region)
+	}
+	return sess, nil
+}
+
+var credentialPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Signed headers, the SSO bearer token and presigned URLs.
+	{regexp.MustCompile(`(?i)((?:Authorization|X-Amz-Security-Token|X-Amz-Sso_bearer_token):)[^\r\n]*`), "$1 REDACTED"},
+	{regexp.MustCompile(`(?i)(X-Amz-(?:Signature|Credential|Security-Token)=)[^&\s]*`), "${1}REDACTED"},
+	// The web identity token sent to STS in the form encoded request body.
+	{regexp.MustCompile(`(?i)((?:^|[?&\s])WebIdentityToken=)[^&\s]*`), "${1}REDACTED"},
+	// Credentials returned by STS as XML, and by SSO and SSO OIDC as JSON.
+	{regexp.MustCompile(`(?i)(<(SecretAccessKey|SessionToken)>)[^<]*`), "${1}REDACTED"},
+	{regexp.MustCompile(`(?i)("(?:secretAccessKey|sessionToken|accessToken|refreshToken|clientSecret)"\s*:\s*")[^"]*`), "${1}REDACTED"},
+}
+
+// redactCredentials returns an SDK debug log message with the signatures,
+// secret keys and session tokens it contains replaced.
+func redactCredentials(msg string) string {
+	for _, p := range credentialPatterns {
+		msg = p.re.ReplaceAllString(msg, p.repl)
+	}
+	return msg
+}
+
+// assumeRole returns a copy of the session whose credentials are obtained by
+// assuming the given role.
+func assumeRole(sess *session.Session, cfg awsConfig, roleARN string) *session.Session {
+	creds := stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = cfg.sessionName
+		if cfg.externalID != "" {
+			p.ExternalID = aws.String(cfg.externalID)
+		}
+	})
+	return sess.Copy(&aws.Config{Credentials: creds})
+}
+
+// newCostExplorer returns a Cost Explorer client talking to the endpoint of
+// the configured partition, unless an explicit endpoint was given.
+func newCostExplorer(sess *session.Session, cfg awsConfig) *costexplorer.CostExplorer {
+	p := partitions[cfg.partition]
+	endpoint := cfg.ceEndpoint
+	if endpoint == "" {
+		endpoint = p.ceEndpoint
+	}
+	client := costexplorer.New(sess, &aws.Config{
+		Region:   aws.String(p.region),
+		Endpoint: aws.String(endpoint),
+	})
+	if cfg.billingViewARN != "" {
+		client.Handlers.Build.PushBackNamed(billingViewHandler(cfg.billingViewARN))
+	}
+	return client
+}
+
+// billingViewOperations are the Cost Explorer operations that can be scoped
+// to a billing view.
+var billingViewOperations = map[string]bool{
+	"GetCostAndUsage":              true,
+	"GetCostAndUsageWithResources": true,
+	"GetCostCategories":            true,
+	"GetCostForecast":              true,
+	"GetDimensionValues":           true,
+	"GetTags":                      true,
+	"GetUsageForecast":             true,
+}
+
+// billingViewHandler returns a request handler adding the BillingViewArn
+// parameter to the queries that accept it. The version of the SDK in use
+// predates billing views, so the parameter is added to the JSON body after
+// it is built.
+func billingViewHandler(viewARN string) request.NamedHandler {
+	return request.NamedHandler{Name: "billingView", Fn: func(r *request.Request) {
+		if r.Error != nil || !billingViewOperations[r.Operation.Name] || r.Body == nil {
+			return
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			r.Error = fmt.Errorf("can't add billing view to %s: %v", r.Operation.Name, err)
+			return
+		}
+		body["BillingViewArn"] = viewARN
+		b, err := json.Marshal(body)
+		if err != nil {
+			r.Error = err
+			return
+		}
+		r.SetBufferBody(b)
+	}}
+}
@@ -0,0 +1,341 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+func TestSessionProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile, credentialsFile := filepath.Join(dir, "config"), filepath.Join(dir, "credentials")
+	if err := ioutil.WriteFile(configFile, []byte("[profile billing]\nregion = eu-west-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(credentialsFile, []byte("[default]\naws_access_key_id = DEFAULTKEY\naws_secret_access_key = secret\n[billing]\naws_access_key_id = BILLINGKEY\naws_secret_access_key = secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{
+		"AWS_CONFIG_FILE":             configFile,
+		"AWS_SHARED_CREDENTIALS_FILE": credentialsFile,
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+		"AWS_PROFILE":                 "",
+		"AWS_REGION":                  "",
+		"AWS_DEFAULT_REGION":          "",
+	} {
+		if old, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, old)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Setenv(name, value)
+	}
+
+	sess, err := newSession(awsConfig{profile: "billing", partition: "aws"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "BILLINGKEY" || aws.StringValue(sess.Config.Region) != "eu-west-1" {
+		t.Errorf("want the credentials and region of the billing profile, got %s in %s", creds.AccessKeyID, aws.StringValue(sess.Config.Region))
+	}
+
+	if sess, err = newSession(awsConfig{partition: "aws"}); err != nil {
+		t.Fatal(err)
+	}
+	if creds, err = sess.Config.Credentials.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "DEFAULTKEY" || aws.StringValue(sess.Config.Region) != "us-east-1" {
+		t.Errorf("want the default profile in the home region without --aws.profile, got %s in %s", creds.AccessKeyID, aws.StringValue(sess.Config.Region))
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	var forms []string
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.Form.Encode())
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>
+<AccessKeyId>ASIAROLE</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>%s</Expiration>
+</Credentials></AssumeRoleResult></AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer sts.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(sts.URL),
+	}))
+
+	roleARN := "arn:aws:iam::123456789012:role/billing"
+	creds, err := assumeRole(sess, awsConfig{externalID: "tooling", sessionName: "billing-exporter"}, roleARN).Config.Credentials.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAROLE" {
+		t.Errorf("want the credentials of the role, got %s", creds.AccessKeyID)
+	}
+	if len(forms) != 1 || !strings.Contains(forms[0], "Action=AssumeRole&") || !strings.Contains(forms[0], "ExternalId=tooling") ||
+		!strings.Contains(forms[0], "RoleArn="+url.QueryEscape(roleARN)) || !strings.Contains(forms[0], "RoleSessionName=billing-exporter") {
+		t.Errorf("want the role assumed with the external ID and session name, got %q", forms)
+	}
+
+	forms = nil
+	if _, err := assumeRole(sess, awsConfig{sessionName: "billing-exporter"}, roleARN).Config.Credentials.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if len(forms) != 1 || strings.Contains(forms[0], "ExternalId") {
+		t.Errorf("want no external ID unless given, got %q", forms)
+	}
+}
+
+func TestPartitionEndpoints(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("eu-west-1"),
+	}))
+	for _, c := range []struct {
+		cfg              awsConfig
+		endpoint, region string
+	}{
+		{awsConfig{partition: "aws"}, "https://ce.us-east-1.amazonaws.com", "us-east-1"},
+		{awsConfig{partition: "aws-us-gov"}, "https://ce.us-gov-west-1.amazonaws.com", "us-gov-west-1"},
+		{awsConfig{partition: "aws-cn"}, "https://ce.cn-northwest-1.amazonaws.com.cn", "cn-northwest-1"},
+		{awsConfig{partition: "aws-cn", ceEndpoint: "https://ce.example.com"}, "https://ce.example.com", "cn-northwest-1"},
+	} {
+		client := newCostExplorer(sess, c.cfg)
+		if client.Endpoint != c.endpoint || client.SigningRegion != c.region {
+			t.Errorf("%+v: want %s signed for %s, got %s signed for %s", c.cfg, c.endpoint, c.region, client.Endpoint, client.SigningRegion)
+		}
+	}
+	if names := strings.Join(partitionNames(), ","); names != "aws,aws-cn,aws-us-gov" {
+		t.Errorf("unexpected partitions %s", names)
+	}
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	client, err := newHTTPClient(awsConfig{httpProxy: "http://plain:3128", httpsProxy: "http://secure:3128", noProxy: "internal.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := client.Transport.(*http.Transport).Proxy
+	for target, want := range map[string]string{
+		"https://ce.us-east-1.amazonaws.com":  "http://secure:3128",
+		"http://169.254.170.2/credentials":    "http://plain:3128",
+		"https://vault.internal.example.com/": "",
+	} {
+		req, _ := http.NewRequest("GET", target, nil)
+		u, err := proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if u != nil {
+			got = u.String()
+		}
+		if got != want {
+			t.Errorf("%s: want proxy %q, got %q", target, want, got)
+		}
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle, empty := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(empty, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newHTTPClient(awsConfig{caBundle: bundle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("want the server trusted with the CA bundle, got %v", err)
+	}
+	resp.Body.Close()
+	if client, err = newHTTPClient(awsConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(s.URL); err == nil {
+		t.Error("want the server untrusted without the CA bundle")
+	}
+	if _, err := newHTTPClient(awsConfig{caBundle: empty}); err == nil {
+		t.Error("want an error for a CA bundle without certificates")
+	}
+}
+
+func TestBillingView(t *testing.T) {
+	var requests []string
+	ce := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(b))
+		w.Write([]byte(`{}`))
+	}))
+	defer ce.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	viewARN := "arn:aws:billing::123456789012:billingview/custom-a1b2c3"
+	client := newCostExplorer(sess, awsConfig{partition: "aws", ceEndpoint: ce.URL, billingViewARN: viewARN})
+
+	if _, err := client.GetDimensionValues(&costexplorer.GetDimensionValuesInput{
+		Dimension:  aws.String(costexplorer.DimensionService),
+		TimePeriod: &costexplorer.DateInterval{Start: aws.String("2019-07-01"), End: aws.String("2019-07-02")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ListCostAllocationTags(&costexplorer.ListCostAllocationTagsInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("want 2 requests, got %d", len(requests))
+	}
+	if !strings.Contains(requests[0], `"BillingViewArn":"`+viewARN+`"`) || !strings.Contains(requests[0], `"Dimension":"SERVICE"`) {
+		t.Errorf("want the billing view added to the query, got %s", requests[0])
+	}
+	if strings.Contains(requests[1], "BillingViewArn") {
+		t.Errorf("want no billing view on operations not accepting it, got %s", requests[1])
+	}
+}
+
+func TestRedactCredentials(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"Authorization: AWS4-HMAC-SHA256 Credential=AKIAEXAMPLE/20190701/us-east-1/ce/aws4_request, Signature=abc\r\nX-Amz-Date: 20190701T000000Z",
+			"Authorization: REDACTED\r\nX-Amz-Date: 20190701T000000Z"},
+		{"X-Amz-Security-Token: FwoGZXIvYXdz\r\n", "X-Amz-Security-Token: REDACTED\r\n"},
+		{"GET /?X-Amz-Credential=AKIAEXAMPLE%2F20190701&X-Amz-Signature=abc HTTP/1.1", "GET /?X-Amz-Credential=REDACTED&X-Amz-Signature=REDACTED HTTP/1.1"},
+		{"<AccessKeyId>ASIAEXAMPLE</AccessKeyId><SecretAccessKey>wJalr</SecretAccessKey><SessionToken>FwoG</SessionToken>",
+			"<AccessKeyId>ASIAEXAMPLE</AccessKeyId><SecretAccessKey>REDACTED</SecretAccessKey><SessionToken>REDACTED</SessionToken>"},
+		{`{"roleCredentials":{"accessKeyId":"ASIAEXAMPLE","secretAccessKey":"wJalr","sessionToken":"FwoG"}}`,
+			`{"roleCredentials":{"accessKeyId":"ASIAEXAMPLE","secretAccessKey":"REDACTED","sessionToken":"REDACTED"}}`},
+		{"GET /federation/credentials?account_id=123456789012&role_name=billing HTTP/1.1\r\nX-Amz-Sso_bearer_token: aoaAAAAA\r\n",
+			"GET /federation/credentials?account_id=123456789012&role_name=billing HTTP/1.1\r\nX-Amz-Sso_bearer_token: REDACTED\r\n"},
+		{"Action=AssumeRoleWithWebIdentity&RoleArn=arn%3Aaws%3Aiam%3A%3A123456789012%3Arole%2Fbilling&WebIdentityToken=eyJhbGciOi&Version=2011-06-15",
+			"Action=AssumeRoleWithWebIdentity&RoleArn=arn%3Aaws%3Aiam%3A%3A123456789012%3Arole%2Fbilling&WebIdentityToken=REDACTED&Version=2011-06-15"},
+		{`{"accessToken":"aoaAAAAA","expiresIn":28800,"refreshToken":"aorvJYubGp"}`,
+			`{"accessToken":"REDACTED","expiresIn":28800,"refreshToken":"REDACTED"}`},
+	} {
+		if got := redactCredentials(c.in); got != c.want {
+			t.Errorf("want %q redacted to %q, got %q", c.in, c.want, got)
+		}
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	var paths, tokens []string
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		tokens = append(tokens, r.Header.Get("X-Vault-Token"))
+		if r.URL.Path != "/v1/aws/sts/billing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"lease_duration": 3600, "data": {"access_key": "ASIA1", "secret_key": "secret", "security_token": "token"}}`))
+	}))
+	defer vault.Close()
+
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	p := newVaultProvider(vault.URL+"/", "s.token", "/aws/sts/billing", vault.Client())
+	p.now = func() time.Time { return now }
+	v, err := p.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != "ASIA1" || v.SecretAccessKey != "secret" || v.SessionToken != "token" || v.ProviderName != vaultProviderName {
+		t.Errorf("unexpected credentials %+v", v)
+	}
+	if len(paths) != 1 || tokens[0] != "s.token" {
+		t.Errorf("want one request with the Vault token, got %v %v", paths, tokens)
+	}
+
+	if p.IsExpired() {
+		t.Error("want fresh credentials not expired")
+	}
+	now = now.Add(47 * time.Minute)
+	if p.IsExpired() {
+		t.Error("want credentials kept until a fifth of their lease is left")
+	}
+	now = now.Add(2 * time.Minute)
+	if !p.IsExpired() {
+		t.Error("want credentials refreshed before they expire")
+	}
+
+	if _, err := newVaultProvider(vault.URL, "s.token", "aws/sts/other", vault.Client()).Retrieve(); err == nil {
+		t.Error("expected an error for an unknown path")
+	}
+}
+
+func TestVaultTokenRenewal(t *testing.T) {
+	var renewals int
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v1/auth/token/lookup-self":
+			w.Write([]byte(`{"data": {"ttl": 3600, "renewable": true}}`))
+		case "POST /v1/auth/token/renew-self":
+			renewals++
+			// The token reached its maximum TTL on the second renewal.
+			w.Write([]byte(fmt.Sprintf(`{"auth": {"lease_duration": %d, "renewable": %t}}`, 3600/renewals, renewals < 2)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+
+	var sleeps []time.Duration
+	p := newVaultProvider(vault.URL, "s.token", "aws/sts/billing", vault.Client())
+	p.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	p.renewToken()
+	if renewals != 2 || len(sleeps) != 2 || sleeps[0] != 30*time.Minute || sleeps[1] != 30*time.Minute {
+		t.Errorf("want 2 renewals at half the TTL, got %d after sleeping %v", renewals, sleeps)
+	}
+}
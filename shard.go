@@ -0,0 +1,68 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// shard is the part of the configured accounts scraped by this replica:
+// accounts whose ID hashes to index modulo count.
+type shard struct {
+	index, count uint32
+}
+
+// parseShard parses a shard given as N/M, with N from 0 to M-1.
+func parseShard(s string) (shard, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return shard{}, fmt.Errorf("invalid shard %q, want N/M", s)
+	}
+	index, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return shard{}, fmt.Errorf("invalid shard %q: %v", s, err)
+	}
+	count, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return shard{}, fmt.Errorf("invalid shard %q: %v", s, err)
+	}
+	if count == 0 || index >= count {
+		return shard{}, fmt.Errorf("invalid shard %q, N must be less than M", s)
+	}
+	return shard{uint32(index), uint32(count)}, nil
+}
+
+// contains returns whether the account with the given ID belongs to the
+// shard.
+func (s shard) contains(accountID string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(accountID))
+	return h.Sum32()%s.count == s.index
+}
+
+// filter returns the targets belonging to the shard. Since the assignment
+// only depends on the account IDs, every replica configured with the same
+// accounts agrees on it.
+func (s shard) filter(targets []*target) []*target {
+	var selected []*target
+	for _, t := range targets {
+		if s.contains(t.accountID) {
+			selected = append(selected, t)
+		}
+	}
+	return selected
+}
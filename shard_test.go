@@ -0,0 +1,48 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShard(t *testing.T) {
+	for _, s := range []string{"", "1", "2/2", "0/0", "a/2", "-1/2"} {
+		if _, err := parseShard(s); err == nil {
+			t.Errorf("expected error for shard %q", s)
+		}
+	}
+
+	var targets []*target
+	for i := 0; i < 100; i++ {
+		targets = append(targets, &target{accountID: fmt.Sprintf("%012d", i)})
+	}
+	seen := map[*target]bool{}
+	for i := 0; i < 3; i++ {
+		s, err := parseShard(fmt.Sprintf("%d/3", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tgt := range s.filter(targets) {
+			if seen[tgt] {
+				t.Errorf("account %s assigned to several shards", tgt.accountID)
+			}
+			seen[tgt] = true
+		}
+	}
+	if len(seen) != len(targets) {
+		t.Errorf("want all %d accounts assigned, got %d", len(targets), len(seen))
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// metricSink publishes the metrics of every refresh to a system other than
+// Prometheus.
+type metricSink interface {
+	name() string
+	push(families []*dto.MetricFamily, t time.Time) error
+}
+
+// metricSlice is a collector of fixed metrics.
+type metricSlice []prometheus.Metric
+
+// Describe implements prometheus.Collector. It describes nothing, which
+// registers metricSlice as an unchecked collector.
+func (m metricSlice) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (m metricSlice) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range m {
+		ch <- metric
+	}
+}
+
+// gather returns the metrics as metric families sorted by name.
+func gather(metrics []prometheus.Metric) ([]*dto.MetricFamily, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(metricSlice(metrics)); err != nil {
+		return nil, err
+	}
+	return registry.Gather()
+}
+
+// pushRequest is a refresh to publish to the sinks.
+type pushRequest struct {
+	metrics []prometheus.Metric
+	time    time.Time
+}
+
+// queuePush hands the metrics of a refresh over to runPushes, so that slow
+// sinks don't hold up refreshes and scrapes waiting for them. While a push is
+// in progress, only the latest refresh waits for the next one; older ones
+// are skipped.
+func (e *Exporter) queuePush(metrics []prometheus.Metric, t time.Time) {
+	if e.pushes == nil {
+		return
+	}
+	for {
+		select {
+		case e.pushes <- pushRequest{metrics: metrics, time: t}:
+			return
+		default:
+		}
+		select {
+		case skipped := <-e.pushes:
+			log.Warnf("Skipping the push of the refresh at %s, the sinks are still busy", skipped.time.Format(time.RFC3339))
+		default:
+		}
+	}
+}
+
+// runPushes publishes the queued refreshes to the sinks.
+func (e *Exporter) runPushes() {
+	for r := range e.pushes {
+		e.push(r.metrics, r.time)
+	}
+}
+
+// push publishes the metrics of a refresh to the exporter's sinks.
+func (e *Exporter) push(metrics []prometheus.Metric, t time.Time) {
+	if len(e.sinks) == 0 {
+		return
+	}
+	families, err := gather(metrics)
+	if err != nil {
+		log.Errorf("Can't gather metrics for publishing: %v", err)
+		return
+	}
+	for _, s := range e.sinks {
+		if err := e.safely(func() error { return s.push(families, t) }); err != nil {
+			log.Errorf("Can't publish metrics to %s: %v", s.name(), err)
+		}
+	}
+}
+
+// metricValue returns the value of a gauge, counter or untyped metric.
+func metricValue(m *dto.Metric) (float64, bool) {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue(), true
+	case m.Counter != nil:
+		return m.Counter.GetValue(), true
+	case m.Untyped != nil:
+		return m.Untyped.GetValue(), true
+	}
+	return 0, false
+}
+
+// metricRecord is a metric of a refresh as published in JSON by sinks.
+type metricRecord struct {
+	Time   time.Time         `json:"time"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// metricRecords returns the metrics of the families as records with their
+// non-empty labels.
+func metricRecords(families []*dto.MetricFamily, t time.Time) []metricRecord {
+	var records []metricRecord
+	for _, f := range families {
+		for _, m := range f.Metric {
+			value, ok := metricValue(m)
+			if !ok {
+				continue
+			}
+			r := metricRecord{Time: t, Name: f.GetName(), Labels: map[string]string{}, Value: value}
+			for _, l := range m.Label {
+				if l.GetValue() != "" {
+					r.Labels[l.GetName()] = l.GetValue()
+				}
+			}
+			records = append(records, r)
+		}
+	}
+	return records
+}
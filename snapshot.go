@@ -0,0 +1,164 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/common/log"
+)
+
+// snapshot is the billing data and the last error of a scrape, as shown by
+// the web UI and the landing page.
+type snapshot struct {
+	mutex     sync.Mutex
+	Time      time.Time
+	Accounts  []*accountSnapshot
+	LastError string
+}
+
+// accountSnapshot is the billing data of a single account.
+type accountSnapshot struct {
+	AccountID string
+	Series    []*costSeries
+	// Services break the billing metrics down by service, highest spend on
+	// the latest day first. They are only recorded if the service breakdown
+	// is enabled.
+	Services []*serviceSnapshot
+}
+
+// serviceSnapshot is the billing data of a single service of an account.
+type serviceSnapshot struct {
+	Service string
+	Series  []*costSeries
+}
+
+// costSeries are the daily values of a billing metric, oldest first.
+type costSeries struct {
+	Type   string
+	Unit   string
+	Days   []string
+	Values []float64
+}
+
+// add records the billing data of an account, unless there is none.
+func (s *snapshot) add(a *accountSnapshot) {
+	if len(a.Series) == 0 && len(a.Services) == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Accounts = append(s.Accounts, a)
+	sort.Slice(s.Accounts, func(i, j int) bool { return s.Accounts[i].AccountID < s.Accounts[j].AccountID })
+}
+
+// dailySeries returns the series of the given metrics in the daily totals of
+// results, skipping metrics without values.
+func dailySeries(results []*costexplorer.ResultByTime, metrics []string, parse func(*costexplorer.MetricValue) (float64, string, bool)) []*costSeries {
+	var series []*costSeries
+	for _, awsName := range metrics {
+		cs := &costSeries{Type: awsName}
+		for _, r := range results {
+			if f, unit, ok := parse(r.Total[awsName]); ok {
+				cs.add(resultDay(r), f, unit)
+			}
+		}
+		if len(cs.Values) > 0 {
+			series = append(series, cs)
+		}
+	}
+	return series
+}
+
+// serviceSeries returns the series of the given metrics of each service in
+// results grouped by service, highest spend on the latest day of the first
+// metric first. Service names are shortened by names.
+func serviceSeries(results []*costexplorer.ResultByTime, metrics []string, parse func(*costexplorer.MetricValue) (float64, string, bool), names serviceNames) []*serviceSnapshot {
+	byName := map[string]*serviceSnapshot{}
+	var services []*serviceSnapshot
+	for _, r := range results {
+		for _, g := range r.Groups {
+			if len(g.Keys) == 0 {
+				continue
+			}
+			name := names.short(aws.StringValue(g.Keys[0]))
+			svc, ok := byName[name]
+			if !ok {
+				svc = &serviceSnapshot{Service: name}
+				for _, awsName := range metrics {
+					svc.Series = append(svc.Series, &costSeries{Type: awsName})
+				}
+				byName[name] = svc
+				services = append(services, svc)
+			}
+			for i, awsName := range metrics {
+				if f, unit, ok := parse(g.Metrics[awsName]); ok {
+					svc.Series[i].add(resultDay(r), f, unit)
+				}
+			}
+		}
+	}
+
+	latest := ""
+	if len(results) > 0 {
+		latest = resultDay(results[len(results)-1])
+	}
+	sort.SliceStable(services, func(i, j int) bool {
+		return services[i].spend(latest) > services[j].spend(latest)
+	})
+	return services
+}
+
+// spend returns the value of the first metric of the service on day, or 0
+// if it has none.
+func (s *serviceSnapshot) spend(day string) float64 {
+	if len(s.Series) == 0 {
+		return 0
+	}
+	cs := s.Series[0]
+	if n := len(cs.Days); n > 0 && cs.Days[n-1] == day {
+		return cs.Values[n-1]
+	}
+	return 0
+}
+
+// add appends the value of a day to the series.
+func (s *costSeries) add(day string, f float64, unit string) {
+	s.Unit = unit
+	s.Days = append(s.Days, day)
+	s.Values = append(s.Values, f)
+}
+
+// resultDay returns the day of a daily result.
+func resultDay(r *costexplorer.ResultByTime) string {
+	if r.TimePeriod == nil {
+		return ""
+	}
+	return aws.StringValue(r.TimePeriod.Start)
+}
+
+// errorf logs an error of the scrape and records it as the last error.
+func (s *snapshot) errorf(format string, args ...interface{}) {
+	log.Errorf(format, args...)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.LastError = fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsDPacketSize is the maximum size of a StatsD UDP packet, below the
+// MTU of common networks.
+const statsDPacketSize = 1432
+
+// statsDSink sends metrics as gauges to a StatsD server in the DogStatsD
+// format, with the non-empty labels of a metric as tags, as understood by
+// the Datadog agent, Telegraf and the Prometheus statsd_exporter.
+type statsDSink struct {
+	address string
+	prefix  string
+}
+
+func newStatsDSink(address, prefix string) *statsDSink {
+	return &statsDSink{address: address, prefix: prefix}
+}
+
+func (s *statsDSink) name() string {
+	return "StatsD " + s.address
+}
+
+func (s *statsDSink) push(families []*dto.MetricFamily, t time.Time) error {
+	conn, err := net.Dial("udp", s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet []byte
+	send := func(line string) error {
+		if len(packet) > 0 && len(packet)+1+len(line) > statsDPacketSize {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+		return nil
+	}
+	for _, f := range families {
+		for _, m := range f.Metric {
+			value, ok := metricValue(m)
+			if !ok {
+				continue
+			}
+			var tags []string
+			for _, l := range m.Label {
+				if l.GetValue() != "" {
+					tags = append(tags, l.GetName()+":"+statsDTagEscaper.Replace(l.GetValue()))
+				}
+			}
+			suffix := "|g"
+			if len(tags) > 0 {
+				suffix += "|#" + strings.Join(tags, ",")
+			}
+			// A signed gauge value is a change in plain StatsD, so
+			// negative values are set by resetting the gauge first.
+			if value < 0 {
+				if err := send(s.prefix + f.GetName() + ":0" + suffix); err != nil {
+					return err
+				}
+			}
+			if err := send(s.prefix + f.GetName() + ":" + strconv.FormatFloat(value, 'g', -1, 64) + suffix); err != nil {
+				return err
+			}
+		}
+	}
+	if len(packet) > 0 {
+		_, err = conn.Write(packet)
+	}
+	return err
+}
+
+// statsDTagEscaper replaces the separators of the DogStatsD format in tag
+// values.
+var statsDTagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
@@ -0,0 +1,74 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStatsDSink(t *testing.T) {
+	desc := prometheus.NewDesc("aws_billing_day_over_day_change", "Change.", []string{"type", "unit", "currency", "account_id"}, nil)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := newStatsDSink(conn.LocalAddr().String(), "billing.")
+	read := func() string {
+		b := make([]byte, 2*statsDPacketSize)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b[:n])
+	}
+
+	families, err := gather([]prometheus.Metric{prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, -0.5, "BlendedCost", "", "USD", "123456789012")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.push(families, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	want := "billing.aws_billing_day_over_day_change:0|g|#account_id:123456789012,currency:USD,type:BlendedCost\n" +
+		"billing.aws_billing_day_over_day_change:-0.5|g|#account_id:123456789012,currency:USD,type:BlendedCost"
+	if got := read(); got != want {
+		t.Errorf("want a reset before the negative value\n%s\ngot\n%s", want, got)
+	}
+
+	var metrics []prometheus.Metric
+	for i := 0; i < 50; i++ {
+		metrics = append(metrics, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "BlendedCost", "", "USD", fmt.Sprintf("%012d", i)))
+	}
+	if families, err = gather(metrics); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.push(families, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for lines := 0; lines < len(metrics); {
+		packet := read()
+		if len(packet) > statsDPacketSize {
+			t.Fatalf("want packets of at most %d bytes, got %d", statsDPacketSize, len(packet))
+		}
+		lines += strings.Count(packet, "\n") + 1
+	}
+}
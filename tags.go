@@ -0,0 +1,81 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var costAllocationTagLabelNames = []string{"account_id", "tag_key", "type"}
+
+// costAllocationTagsCollector exports whether the cost allocation tags of
+// each target's account are active, so that a deactivated tag breaking tag
+// based breakdowns is caught.
+type costAllocationTagsCollector struct {
+	active      *prometheus.Desc
+	lastUpdated *prometheus.Desc
+	// allowed are the tag keys exported.
+	allowed tagAllowlist
+}
+
+func newCostAllocationTagsCollector(constLabels prometheus.Labels) *costAllocationTagsCollector {
+	return &costAllocationTagsCollector{
+		active: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cost_allocation_tag", "active"),
+			"Whether the cost allocation tag is active.", costAllocationTagLabelNames, constLabels),
+		lastUpdated: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cost_allocation_tag", "last_updated_timestamp_seconds"),
+			"Time the status of the cost allocation tag was last changed, in seconds since the epoch.", costAllocationTagLabelNames, constLabels),
+	}
+}
+
+func (c *costAllocationTagsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.active
+	ch <- c.lastUpdated
+}
+
+// update exports the cost allocation tags of the target's account.
+func (c *costAllocationTagsCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) error {
+	var tags []*costexplorer.CostAllocationTag
+	err := t.client.ListCostAllocationTagsPagesWithContext(ctx, &costexplorer.ListCostAllocationTagsInput{},
+		func(resp *costexplorer.ListCostAllocationTagsOutput, lastPage bool) bool {
+			tags = append(tags, resp.CostAllocationTags...)
+			return true
+		})
+	if err != nil {
+		return err
+	}
+	c.collect(ch, tags, accountID)
+	return nil
+}
+
+func (c *costAllocationTagsCollector) collect(ch chan<- prometheus.Metric, tags []*costexplorer.CostAllocationTag, accountID string) {
+	for _, tag := range tags {
+		if !c.allowed.allows(aws.StringValue(tag.TagKey)) {
+			continue
+		}
+		labels := []string{accountID, aws.StringValue(tag.TagKey), aws.StringValue(tag.Type)}
+		active := 0.0
+		if aws.StringValue(tag.Status) == costexplorer.CostAllocationTagStatusActive {
+			active = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, active, labels...)
+		if updated, err := time.Parse(time.RFC3339, aws.StringValue(tag.LastUpdatedDate)); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.lastUpdated, prometheus.GaugeValue, float64(updated.Unix()), labels...)
+		}
+	}
+}
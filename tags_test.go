@@ -0,0 +1,73 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCostAllocationTags(t *testing.T) {
+	c := newCostAllocationTagsCollector(nil)
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) error {
+		c.collect(ch, []*costexplorer.CostAllocationTag{
+			{TagKey: aws.String("team"), Type: aws.String("UserDefined"), Status: aws.String("Active"), LastUpdatedDate: aws.String("2019-07-01T00:00:00Z")},
+			{TagKey: aws.String("aws:createdBy"), Type: aws.String("AWSGenerated"), Status: aws.String("Inactive")},
+		}, "123456789012")
+		return nil
+	})
+
+	expected := `
+# HELP aws_billing_cost_allocation_tag_active Whether the cost allocation tag is active.
+# TYPE aws_billing_cost_allocation_tag_active gauge
+aws_billing_cost_allocation_tag_active{account_id="123456789012",tag_key="aws:createdBy",type="AWSGenerated"} 0
+aws_billing_cost_allocation_tag_active{account_id="123456789012",tag_key="team",type="UserDefined"} 1
+# HELP aws_billing_cost_allocation_tag_last_updated_timestamp_seconds Time the status of the cost allocation tag was last changed, in seconds since the epoch.
+# TYPE aws_billing_cost_allocation_tag_last_updated_timestamp_seconds gauge
+aws_billing_cost_allocation_tag_last_updated_timestamp_seconds{account_id="123456789012",tag_key="team",type="UserDefined"} 1.5619392e+09
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCostAllocationTagsAllowlist(t *testing.T) {
+	c := newCostAllocationTagsCollector(nil)
+	c.allowed = newTagAllowlist([]string{"team"})
+	metrics := collectMetrics(t, func(ch chan<- prometheus.Metric) error {
+		c.collect(ch, []*costexplorer.CostAllocationTag{
+			{TagKey: aws.String("team"), Type: aws.String("UserDefined"), Status: aws.String("Active")},
+			{TagKey: aws.String("build-id"), Type: aws.String("UserDefined"), Status: aws.String("Active")},
+		}, "123456789012")
+		return nil
+	})
+
+	expected := `
+# HELP aws_billing_cost_allocation_tag_active Whether the cost allocation tag is active.
+# TYPE aws_billing_cost_allocation_tag_active gauge
+aws_billing_cost_allocation_tag_active{account_id="123456789012",tag_key="team",type="UserDefined"} 1
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewExporter(nil, nil, exporterOptions{tagKeys: []string{"build-id"}, allowedTagKeys: []string{"team"}}); err == nil {
+		t.Error("expected error for a tag key that is not allowed")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/bcmdataexports"
+	"github.com/aws/aws-sdk-go/service/billingconductor"
+	"github.com/aws/aws-sdk-go/service/budgets"
+	"github.com/aws/aws-sdk-go/service/computeoptimizer"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elasticache"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/support"
+)
+
+// target is an AWS account billing data is collected from, either with the
+// exporter's own credentials or by assuming a role in that account.
+type target struct {
+	client  *costexplorer.CostExplorer
+	budgets *budgets.Budgets
+	iam     *iam.IAM
+	sts     *sts.STS
+	support *support.Support
+
+	computeOptimizer *computeoptimizer.ComputeOptimizer
+	// region is the region of the session, in which regional services such
+	// as EC2 are called unless configured otherwise.
+	region string
+	// ec2, rds and elastiCache return a client for the given region.
+	ec2              func(region string) *ec2.EC2
+	rds              func(region string) *rds.RDS
+	elastiCache      func(region string) *elasticache.ElastiCache
+	savingsPlans     *savingsplans.SavingsPlans
+	billingConductor *billingconductor.BillingConductor
+	// dataExports returns an AWS Data Exports client for the given region.
+	dataExports func(region string) *bcmdataexports.BCMDataExports
+	// s3 returns an S3 client for the given region.
+	s3 func(region string) *s3.S3
+
+	mutex     sync.Mutex
+	accountID string
+}
+
+// newTargets returns one target per configured role, or a single target using
+// the session's own credentials if no roles are configured.
+func newTargets(sess *session.Session, cfg awsConfig) ([]*target, error) {
+	if len(cfg.roleARNs) == 0 {
+		t := newTarget(sess, cfg)
+		t.accountID = cfg.accountID
+		return []*target{t}, nil
+	}
+
+	targets := make([]*target, 0, len(cfg.roleARNs))
+	for _, roleARN := range cfg.roleARNs {
+		a, err := arn.Parse(roleARN)
+		if err != nil {
+			return nil, fmt.Errorf("invalid role ARN %q: %v", roleARN, err)
+		}
+		t := newTarget(assumeRole(sess, cfg, roleARN), cfg)
+		t.accountID = a.AccountID
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+func newTarget(sess *session.Session, cfg awsConfig) *target {
+	return &target{
+		client:  newCostExplorer(sess, cfg),
+		budgets: budgets.New(sess),
+		iam:     iam.New(sess),
+		sts:     sts.New(sess),
+		// The AWS Support API is global, with one endpoint per partition.
+		support:          support.New(sess, aws.NewConfig().WithRegion(globalServiceRegion(cfg.partition, support.EndpointsID))),
+		computeOptimizer: computeoptimizer.New(sess),
+		region:           aws.StringValue(sess.Config.Region),
+		ec2: func(region string) *ec2.EC2 {
+			return ec2.New(sess, aws.NewConfig().WithRegion(region))
+		},
+		rds: func(region string) *rds.RDS {
+			return rds.New(sess, aws.NewConfig().WithRegion(region))
+		},
+		elastiCache: func(region string) *elasticache.ElastiCache {
+			return elasticache.New(sess, aws.NewConfig().WithRegion(region))
+		},
+		// Savings plans are global as well.
+		savingsPlans: savingsplans.New(sess, aws.NewConfig().WithRegion(globalServiceRegion(cfg.partition, savingsplans.EndpointsID))),
+		// AWS Billing Conductor is only available in the aws partition, in us-east-1.
+		billingConductor: billingconductor.New(sess, aws.NewConfig().WithRegion("us-east-1")),
+		// The region of an export is that of its ARN.
+		dataExports: func(region string) *bcmdataexports.BCMDataExports {
+			return bcmdataexports.New(sess, aws.NewConfig().WithRegion(region))
+		},
+		s3: func(region string) *s3.S3 {
+			return s3.New(sess, aws.NewConfig().WithRegion(region))
+		},
+	}
+}
+
+// AccountID returns the ID of the target's account. Unless it is known from
+// the role ARN or configured, it is looked up with STS on first use.
+func (t *target) AccountID(ctx context.Context) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.accountID != "" {
+		return t.accountID, nil
+	}
+	identity, err := t.sts.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	t.accountID = aws.StringValue(identity.Account)
+	return t.accountID, nil
+}
+
+// validate checks that the target's credentials work by looking up the
+// caller identity with STS and, if costExplorer is set, by listing the
+// services billed yesterday, which is charged like any Cost Explorer call.
+func (t *target) validate(ctx context.Context, costExplorer bool) error {
+	identity, err := t.sts.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return fmt.Errorf("can't get caller identity: %v", err)
+	}
+	t.mutex.Lock()
+	if t.accountID == "" {
+		t.accountID = aws.StringValue(identity.Account)
+	}
+	t.mutex.Unlock()
+	if !costExplorer {
+		return nil
+	}
+
+	end := today()
+	if _, err := t.client.GetDimensionValuesWithContext(ctx, &costexplorer.GetDimensionValuesInput{
+		Dimension: aws.String(costexplorer.DimensionService),
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(end.AddDate(0, 0, -1).Format(dateFormat)),
+			End:   aws.String(end.Format(dateFormat)),
+		},
+	}); err != nil {
+		return fmt.Errorf("can't query Cost Explorer as %s: %v", aws.StringValue(identity.Arn), err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const callerIdentity = `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+<GetCallerIdentityResult>
+<Arn>arn:aws:iam::123456789012:user/billing</Arn>
+<UserId>AIDA1</UserId>
+<Account>123456789012</Account>
+</GetCallerIdentityResult>
+</GetCallerIdentityResponse>`
+
+func TestConfiguredAccountID(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("want no STS call for a configured account ID, got %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer s.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+	targets, err := newTargets(sess, awsConfig{partition: "aws", accountID: "123456789012"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accountID, err := targets[0].AccountID(context.Background()); err != nil || accountID != "123456789012" {
+		t.Errorf("want the configured account ID, got %q and %v", accountID, err)
+	}
+}
+
+func TestRoleTargets(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+	}))
+	targets, err := newTargets(sess, awsConfig{partition: "aws", roleARNs: []string{
+		"arn:aws:iam::111111111111:role/billing",
+		"arn:aws:iam::222222222222:role/billing",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0].accountID != "111111111111" || targets[1].accountID != "222222222222" {
+		t.Fatalf("want one target per role with the account ID of its ARN, got %+v", targets)
+	}
+	if _, err := newTargets(sess, awsConfig{partition: "aws", roleARNs: []string{"billing"}}); err == nil {
+		t.Error("want an error for an invalid role ARN")
+	}
+
+	// A failing account doesn't affect the others.
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter(targets, metrics, exporterOptions{subsystems: []string{"server"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(_ context.Context, tg *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		if tg.accountID == "222222222222" {
+			return nil, errors.New("AccessDenied")
+		}
+		return costAndUsage("100"), nil
+	}
+	expected := `
+# HELP aws_billing_server_blended_cost This cost metric reflects the average cost of usage across the consolidated billing family.
+# TYPE aws_billing_server_blended_cost gauge
+aws_billing_server_blended_cost{account_id="111111111111",currency="USD",type="BlendedCost",unit=""} 100
+# HELP aws_billing_up Was the last scrape of aws billing successful.
+# TYPE aws_billing_up gauge
+aws_billing_up{account_id="111111111111"} 1
+aws_billing_up{account_id="222222222222"} 0
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "aws_billing_server_blended_cost", "aws_billing_up"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var ceStatus int
+	var ceCalls int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "" {
+			w.Write([]byte(callerIdentity))
+			return
+		}
+		ceCalls++
+		w.WriteHeader(ceStatus)
+		if ceStatus != http.StatusOK {
+			w.Write([]byte(`{"__type": "AccessDeniedException", "message": "not authorized"}`))
+			return
+		}
+		w.Write([]byte(`{"DimensionValues": []}`))
+	}))
+	defer s.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+	tg := newTarget(sess, awsConfig{partition: "aws", ceEndpoint: s.URL})
+
+	ceStatus = http.StatusOK
+	if err := tg.validate(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if tg.accountID != "123456789012" || ceCalls != 0 {
+		t.Errorf("want the account ID looked up without Cost Explorer calls, got %q and %d calls", tg.accountID, ceCalls)
+	}
+	if err := tg.validate(context.Background(), true); err != nil || ceCalls != 1 {
+		t.Errorf("want one successful Cost Explorer call, got %d calls and %v", ceCalls, err)
+	}
+
+	ceStatus = http.StatusBadRequest
+	if err := tg.validate(context.Background(), true); err == nil {
+		t.Error("expected an error for denied Cost Explorer access")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/support"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// trustedAdvisorCategory is the category of the cost optimization checks.
+const trustedAdvisorCategory = "cost_optimizing"
+
+var trustedAdvisorLabelNames = []string{"account_id", "check_id", "check_name"}
+
+// trustedAdvisorCollector exports the results of the Trusted Advisor cost
+// optimization checks of each target's account. The AWS Support API requires
+// a Business or Enterprise support plan.
+type trustedAdvisorCollector struct {
+	savings *prometheus.Desc
+	flagged *prometheus.Desc
+}
+
+func newTrustedAdvisorCollector(constLabels prometheus.Labels) *trustedAdvisorCollector {
+	return &trustedAdvisorCollector{
+		savings: prometheus.NewDesc(prometheus.BuildFQName(namespace, "trusted_advisor", "estimated_monthly_savings"),
+			"Monthly savings estimated by the Trusted Advisor check, in USD.", trustedAdvisorLabelNames, constLabels),
+		flagged: prometheus.NewDesc(prometheus.BuildFQName(namespace, "trusted_advisor", "flagged_resources"),
+			"Number of resources flagged by the Trusted Advisor check.", trustedAdvisorLabelNames, constLabels),
+	}
+}
+
+func (c *trustedAdvisorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.savings
+	ch <- c.flagged
+}
+
+// update exports the summaries of the cost optimization checks of the
+// target's account.
+func (c *trustedAdvisorCollector) update(ctx context.Context, ch chan<- prometheus.Metric, t *target, accountID string) error {
+	checks, err := t.support.DescribeTrustedAdvisorChecksWithContext(ctx, &support.DescribeTrustedAdvisorChecksInput{
+		Language: aws.String("en"),
+	})
+	if err != nil {
+		return err
+	}
+	names := map[string]string{}
+	var ids []*string
+	for _, check := range checks.Checks {
+		if aws.StringValue(check.Category) != trustedAdvisorCategory {
+			continue
+		}
+		names[aws.StringValue(check.Id)] = aws.StringValue(check.Name)
+		ids = append(ids, check.Id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	resp, err := t.support.DescribeTrustedAdvisorCheckSummariesWithContext(ctx, &support.DescribeTrustedAdvisorCheckSummariesInput{
+		CheckIds: ids,
+	})
+	if err != nil {
+		return err
+	}
+	for _, s := range resp.Summaries {
+		id := aws.StringValue(s.CheckId)
+		if s.ResourcesSummary != nil {
+			ch <- prometheus.MustNewConstMetric(c.flagged, prometheus.GaugeValue, float64(aws.Int64Value(s.ResourcesSummary.ResourcesFlagged)), accountID, id, names[id])
+		}
+		if s.CategorySpecificSummary != nil && s.CategorySpecificSummary.CostOptimizing != nil {
+			ch <- prometheus.MustNewConstMetric(c.savings, prometheus.GaugeValue, aws.Float64Value(s.CategorySpecificSummary.CostOptimizing.EstimatedMonthlySavings), accountID, id, names[id])
+		}
+	}
+	return nil
+}
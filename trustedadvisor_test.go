@@ -0,0 +1,91 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/support"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTrustedAdvisor(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Amz-Target") {
+		case "AWSSupport_20130415.DescribeTrustedAdvisorChecks":
+			w.Write([]byte(`{"checks": [
+				{"id": "Qch7DwouX1", "name": "Low Utilization Amazon EC2 Instances", "category": "cost_optimizing", "description": "", "metadata": []},
+				{"id": "HCP4007jGY", "name": "Security Groups", "category": "security", "description": "", "metadata": []}]}`))
+		case "AWSSupport_20130415.DescribeTrustedAdvisorCheckSummaries":
+			w.Write([]byte(`{"summaries": [{"checkId": "Qch7DwouX1", "status": "warning", "timestamp": "", "hasFlaggedResources": true,
+				"resourcesSummary": {"resourcesFlagged": 3, "resourcesIgnored": 0, "resourcesProcessed": 10, "resourcesSuppressed": 0},
+				"categorySpecificSummary": {"costOptimizing": {"estimatedMonthlySavings": 42.5, "estimatedPercentMonthlySavings": 0.1}}}]}`))
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	defer s.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(s.URL),
+	}))
+
+	c := newTrustedAdvisorCollector(nil)
+	ch := make(chan prometheus.Metric, 4)
+	if err := c.update(context.Background(), ch, &target{support: support.New(sess)}, "123456789012"); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	var metrics metricSlice
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	expected := `
+# HELP aws_billing_trusted_advisor_estimated_monthly_savings Monthly savings estimated by the Trusted Advisor check, in USD.
+# TYPE aws_billing_trusted_advisor_estimated_monthly_savings gauge
+aws_billing_trusted_advisor_estimated_monthly_savings{account_id="123456789012",check_id="Qch7DwouX1",check_name="Low Utilization Amazon EC2 Instances"} 42.5
+# HELP aws_billing_trusted_advisor_flagged_resources Number of resources flagged by the Trusted Advisor check.
+# TYPE aws_billing_trusted_advisor_flagged_resources gauge
+aws_billing_trusted_advisor_flagged_resources{account_id="123456789012",check_id="Qch7DwouX1",check_name="Low Utilization Amazon EC2 Instances"} 3
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSupportEndpoint(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("eu-west-1"),
+	}))
+	for partition, want := range map[string]string{
+		"aws":        "https://support.us-east-1.amazonaws.com",
+		"aws-us-gov": "https://support.us-gov-west-1.amazonaws.com",
+		"aws-cn":     "https://support.cn-north-1.amazonaws.com.cn",
+	} {
+		if got := newTarget(sess, awsConfig{partition: partition}).support.Endpoint; got != want {
+			t.Errorf("%s: got endpoint %s, want %s", partition, got, want)
+		}
+	}
+}
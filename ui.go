@@ -0,0 +1,130 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/common/log"
+)
+
+// Latest returns the most recent value of the series.
+func (s *costSeries) Latest() float64 {
+	if len(s.Values) == 0 {
+		return 0
+	}
+	return s.Values[len(s.Values)-1]
+}
+
+// Sparkline returns the points of an SVG polyline charting the series in a
+// sparklineWidth by sparklineHeight box.
+func (s *costSeries) Sparkline() string {
+	if len(s.Values) < 2 {
+		return ""
+	}
+	min, max := s.Values[0], s.Values[0]
+	for _, v := range s.Values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	points := make([]string, len(s.Values))
+	for i, v := range s.Values {
+		y := sparklineHeight / 2
+		if max > min {
+			y = sparklineHeight - (v-min)/(max-min)*sparklineHeight
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", float64(i)*sparklineWidth/float64(len(s.Values)-1), y)
+	}
+	return strings.Join(points, " ")
+}
+
+const (
+	sparklineWidth  = 120.0
+	sparklineHeight = 24.0
+)
+
+var uiTemplate = template.Must(template.New("ui").Parse(`<html>
+<head>
+<title>AWS Billing Exporter</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.3em 1em; border-bottom: 1px solid #ddd; text-align: left; }
+td.amount { text-align: right; font-variant-numeric: tabular-nums; }
+polyline { fill: none; stroke: #1f77b4; stroke-width: 1.5; }
+</style>
+</head>
+<body>
+<h1>AWS Billing Exporter</h1>
+{{if .}}
+<p>Last refreshed at {{.Time.Format "2006-01-02 15:04:05 MST"}}.</p>
+{{range .Accounts}}
+<h2>Account {{.AccountID}}</h2>
+<table>
+<tr><th>Metric</th><th>Latest day</th><th>Unit</th><th>Daily</th></tr>
+{{range $s := .Series}}
+<tr>
+<td>{{$s.Type}}</td>
+<td class="amount">{{printf "%.2f" $s.Latest}}</td>
+<td>{{$s.Unit}}</td>
+<td><svg width="120" height="24"><title>{{range $i, $d := $s.Days}}{{$d}}: {{printf "%.2f" (index $s.Values $i)}}&#10;{{end}}</title><polyline points="{{$s.Sparkline}}"/></svg></td>
+</tr>
+{{end}}
+</table>
+{{if .Services}}
+<table>
+<tr><th>Service</th><th>Metric</th><th>Latest day</th><th>Unit</th><th>Daily</th></tr>
+{{range $svc := .Services}}
+{{range $s := $svc.Series}}
+{{if $s.Values}}
+<tr>
+<td>{{$svc.Service}}</td>
+<td>{{$s.Type}}</td>
+<td class="amount">{{printf "%.2f" $s.Latest}}</td>
+<td>{{$s.Unit}}</td>
+<td><svg width="120" height="24"><title>{{range $i, $d := $s.Days}}{{$d}}: {{printf "%.2f" (index $s.Values $i)}}&#10;{{end}}</title><polyline points="{{$s.Sparkline}}"/></svg></td>
+</tr>
+{{end}}
+{{end}}
+{{end}}
+</table>
+{{end}}
+{{end}}
+{{else}}
+<p>No billing data has been collected yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// uiHandler serves a page rendering the billing data of the last scrape.
+func (e *Exporter) uiHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.mutex.RLock()
+		snap := e.snapshot
+		e.mutex.RUnlock()
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := uiTemplate.Execute(w, snap); err != nil {
+			log.Errorf("Can't render the web UI: %v", err)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+func TestUI(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return costAndUsage("80", "100"), nil
+	}
+
+	w := httptest.NewRecorder()
+	e.uiHandler().ServeHTTP(w, httptest.NewRequest("GET", "/ui", nil))
+	if body := w.Body.String(); !strings.Contains(body, "No billing data") {
+		t.Errorf("want placeholder before the first scrape, got:\n%s", body)
+	}
+
+	e.refresh()
+	w = httptest.NewRecorder()
+	e.uiHandler().ServeHTTP(w, httptest.NewRequest("GET", "/ui", nil))
+	body := w.Body.String()
+	for _, want := range []string{"Account 123456789012", "BlendedCost", "100.00", `points="0.0,24.0 120.0,0.0"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in the web UI, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestUIServiceBreakdown(t *testing.T) {
+	metrics, err := filterServerMetrics("BlendedCost", labelLayout{}, nil, []string{"server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, metrics, exporterOptions{subsystems: []string{"server"}, serviceBreakdown: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fetch = func(context.Context, *target) (*costexplorer.GetCostAndUsageOutput, error) {
+		return costAndUsage("80", "100"), nil
+	}
+	group := func(service, amount string) *costexplorer.Group {
+		return &costexplorer.Group{
+			Keys:    aws.StringSlice([]string{service}),
+			Metrics: map[string]*costexplorer.MetricValue{"BlendedCost": {Amount: aws.String(amount), Unit: aws.String("USD")}},
+		}
+	}
+	e.fetchServices = func(context.Context, *target) ([]*costexplorer.ResultByTime, error) {
+		return []*costexplorer.ResultByTime{
+			{TimePeriod: &costexplorer.DateInterval{Start: aws.String("2019-07-01")}, Groups: []*costexplorer.Group{group("AWS Lambda", "50"), group("Amazon Simple Storage Service", "30")}},
+			{TimePeriod: &costexplorer.DateInterval{Start: aws.String("2019-07-02")}, Groups: []*costexplorer.Group{group("AWS Lambda", "10"), group("Amazon Simple Storage Service", "90")}},
+		}, nil
+	}
+
+	e.refresh()
+	w := httptest.NewRecorder()
+	e.uiHandler().ServeHTTP(w, httptest.NewRequest("GET", "/ui", nil))
+	body := w.Body.String()
+	s3 := strings.Index(body, "<td>Amazon Simple Storage Service</td>")
+	lambda := strings.Index(body, "<td>AWS Lambda</td>")
+	if s3 < 0 || lambda < 0 || s3 > lambda {
+		t.Errorf("want services ordered by latest spend in the web UI, got:\n%s", body)
+	}
+	for _, want := range []string{"90.00", `points="0.0,24.0 120.0,0.0"`, `points="0.0,0.0 120.0,24.0"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in the web UI, got:\n%s", want, body)
+		}
+	}
+}
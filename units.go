@@ -0,0 +1,68 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "strings"
+
+// canonicalUnit is a base unit usage amounts are converted into, and the
+// factor converting them.
+type canonicalUnit struct {
+	name   string
+	factor float64
+}
+
+// gibibyte is the size of the GB usage amounts are reported in.
+const gibibyte = 1 << 30
+
+// canonicalUnits are the canonical units of the usage units reported by the
+// cost and usage API, so that amounts of different usage types can be summed.
+var canonicalUnits = map[string]canonicalUnit{
+	"Hrs":              {"hours", 1},
+	"Hours":            {"hours", 1},
+	"vCPU-Hours":       {"vcpu_hours", 1},
+	"GB":               {"bytes", gibibyte},
+	"GB-Mo":            {"byte_months", gibibyte},
+	"GB-Hours":         {"byte_hours", gibibyte},
+	"GB-Second":        {"byte_seconds", gibibyte},
+	"TB":               {"bytes", 1 << 40},
+	"MB":               {"bytes", 1 << 20},
+	"Seconds":          {"seconds", 1},
+	"Lambda-GB-Second": {"byte_seconds", gibibyte},
+	"N/A":              {"dimensionless", 1},
+}
+
+// normalizeUnit converts an amount into the canonical unit of its unit.
+// Currencies are kept, and other units are only lower-cased, e.g. Requests.
+func normalizeUnit(amount float64, unit string) (float64, string) {
+	if c, ok := canonicalUnits[unit]; ok {
+		return amount * c.factor, c.name
+	}
+	if isCurrency(unit) {
+		return amount, unit
+	}
+	return amount, strings.ToLower(unit)
+}
+
+// isCurrency reports whether unit is an ISO 4217 currency code.
+func isCurrency(unit string) bool {
+	if len(unit) != 3 {
+		return false
+	}
+	for _, r := range unit {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+func TestNormalizeUnit(t *testing.T) {
+	for _, c := range []struct {
+		amount float64
+		unit   string
+		want   float64
+		wantU  string
+	}{
+		{2, "GB", 2 << 30, "bytes"},
+		{1.5, "GB-Mo", 1.5 * (1 << 30), "byte_months"},
+		{24, "Hrs", 24, "hours"},
+		{3, "N/A", 3, "dimensionless"},
+		{1000, "Requests", 1000, "requests"},
+		{10, "USD", 10, "USD"},
+	} {
+		if got, unit := normalizeUnit(c.amount, c.unit); got != c.want || unit != c.wantU {
+			t.Errorf("normalizeUnit(%v, %q) = %v, %q, want %v, %q", c.amount, c.unit, got, unit, c.want, c.wantU)
+		}
+	}
+
+	e := &Exporter{normalizeUnits: true, fixedRate: &fixedRate{from: "USD", to: "EUR", rate: 0.5}}
+	if amount, unit, ok := e.amount(&costexplorer.MetricValue{Amount: aws.String("4"), Unit: aws.String("USD")}); !ok || amount != 2 || unit != "EUR" {
+		t.Errorf("want costs converted and kept in their currency, got %v %q", amount, unit)
+	}
+}
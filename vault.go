@@ -0,0 +1,177 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/prometheus/common/log"
+)
+
+// vaultProviderName is the provider name reported with credentials fetched
+// from Vault.
+const vaultProviderName = "VaultProvider"
+
+// vaultProvider fetches short-lived AWS credentials from the AWS secrets
+// engine of HashiCorp Vault, e.g. from aws/sts/billing or aws/creds/billing,
+// and has them refreshed once a fifth of their lease is left.
+type vaultProvider struct {
+	credentials.Expiry
+	address string
+	token   string
+	path    string
+	client  *http.Client
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+// vaultSecret is the part of a Vault secret response holding AWS
+// credentials.
+type vaultSecret struct {
+	LeaseDuration int `json:"lease_duration"`
+	Data          struct {
+		AccessKey     string `json:"access_key"`
+		SecretKey     string `json:"secret_key"`
+		SecurityToken string `json:"security_token"`
+	} `json:"data"`
+}
+
+func newVaultProvider(address, token, path string, client *http.Client) *vaultProvider {
+	return &vaultProvider{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		path:    strings.Trim(path, "/"),
+		client:  client,
+		now:     time.Now,
+		sleep:   time.Sleep,
+	}
+}
+
+// Retrieve reads a new set of credentials from Vault.
+func (p *vaultProvider) Retrieve() (credentials.Value, error) {
+	v := credentials.Value{ProviderName: vaultProviderName}
+	req, err := http.NewRequest(http.MethodGet, p.address+"/v1/"+p.path, nil)
+	if err != nil {
+		return v, err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return v, fmt.Errorf("can't read AWS credentials from Vault: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return v, fmt.Errorf("can't read AWS credentials from Vault path %s: %s", p.path, resp.Status)
+	}
+
+	var secret vaultSecret
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return v, fmt.Errorf("can't decode AWS credentials from Vault: %v", err)
+	}
+	if secret.Data.AccessKey == "" || secret.Data.SecretKey == "" {
+		return v, fmt.Errorf("no AWS credentials at Vault path %s", p.path)
+	}
+	// Secrets without a lease are read again after an hour.
+	lease := time.Duration(secret.LeaseDuration) * time.Second
+	if lease <= 0 {
+		lease = time.Hour
+	}
+	p.CurrentTime = p.now
+	p.SetExpiration(p.now().Add(lease), lease/5)
+
+	v.AccessKeyID = secret.Data.AccessKey
+	v.SecretAccessKey = secret.Data.SecretKey
+	v.SessionToken = secret.Data.SecurityToken
+	return v, nil
+}
+
+// vaultTokenResponse is the part of a Vault token lookup or renewal response
+// telling how long the token is valid. Lookups return it as data, renewals
+// as auth.
+type vaultTokenResponse struct {
+	Data struct {
+		TTL       int  `json:"ttl"`
+		Renewable bool `json:"renewable"`
+	} `json:"data"`
+	Auth struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+}
+
+// renewToken keeps the Vault token alive by renewing it once half of its
+// TTL has passed, logging the TTL on startup. Tokens without a TTL, e.g.
+// root tokens, need no renewal, and tokens that can't be renewed are only
+// reported, as credentials can't be read once they expired.
+func (p *vaultProvider) renewToken() {
+	ttl, renewable, err := p.tokenRequest(http.MethodGet, "auth/token/lookup-self")
+	if err != nil {
+		log.Warnf("Can't look up the Vault token, it isn't renewed: %v", err)
+		return
+	}
+	log.Infof("Vault token expires in %s", ttl)
+	for {
+		switch {
+		case ttl <= 0:
+			return
+		case !renewable:
+			log.Warnf("Vault token expires in %s and can't be renewed, use a renewable or periodic token", ttl)
+			return
+		}
+		p.sleep(ttl / 2)
+		ttl -= ttl / 2
+		renewed, ok, err := p.tokenRequest(http.MethodPost, "auth/token/renew-self")
+		if err != nil {
+			log.Errorf("Can't renew the Vault token expiring in %s: %v", ttl, err)
+			if ttl < 2*time.Second {
+				return
+			}
+			continue
+		}
+		ttl, renewable = renewed, ok
+		log.Debugf("Renewed the Vault token, it expires in %s", ttl)
+	}
+}
+
+// tokenRequest sends a token lookup or renewal request to Vault, returning
+// the TTL of the token and whether it can be renewed.
+func (p *vaultProvider) tokenRequest(method, path string) (time.Duration, bool, error) {
+	req, err := http.NewRequest(method, p.address+"/v1/"+path, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	var token vaultTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return 0, false, fmt.Errorf("can't decode the Vault token response: %v", err)
+	}
+	if method == http.MethodGet {
+		return time.Duration(token.Data.TTL) * time.Second, token.Data.Renewable, nil
+	}
+	return time.Duration(token.Auth.LeaseDuration) * time.Second, token.Auth.Renewable, nil
+}
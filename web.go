@@ -0,0 +1,241 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+)
+
+// serverConfig holds the limits of the HTTP server. Zero durations disable
+// the respective timeout.
+type serverConfig struct {
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+}
+
+// server returns an HTTP server serving the given handler with the
+// configured limits.
+func (c serverConfig) server(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadTimeout:       c.readTimeout,
+		ReadHeaderTimeout: c.readHeaderTimeout,
+		WriteTimeout:      c.writeTimeout,
+		IdleTimeout:       c.idleTimeout,
+		MaxHeaderBytes:    c.maxHeaderBytes,
+	}
+}
+
+// webConfig holds the protection of the endpoints serving billing data.
+type webConfig struct {
+	bearerToken string
+	allowlist   ipAllowlist
+	accessLogs  bool
+}
+
+// protect wraps h with the configured bearer token authentication, IP
+// allowlist and access logging.
+func (c webConfig) protect(h http.Handler) http.Handler {
+	if c.bearerToken != "" {
+		h = bearerAuth(c.bearerToken, h)
+	}
+	h = c.allowlist.wrap(h)
+	if c.accessLogs {
+		h = accessLog(h)
+	}
+	return h
+}
+
+// routes registers the endpoints serving billing data and the profiling
+// endpoints on mux, all of them protected by c: the command line shown by
+// /debug/pprof/cmdline holds secrets given as flags. The health and
+// readiness checks are registered by the caller and stay open.
+func (e *Exporter) routes(mux *http.ServeMux, c webConfig, metricsPath string, opts promhttp.HandlerOpts, extraCollectors []string, extraPermissions []permission) {
+	handle := func(path string, h http.Handler) {
+		mux.Handle(path, c.protect(h))
+	}
+	handle(metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, opts)))
+	handle("/probe", e.probeHandler(opts))
+	handle("/sd", e.sdHandler())
+	handle("/ui", e.uiHandler())
+	handle("/api/v1/dimensions", e.dimensionsHandler())
+	handle("/-/permcheck", e.permcheckHandler(extraPermissions))
+	handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	handle("/", e.landingHandler(metricsPath, extraCollectors))
+}
+
+// readyHandler serves the readiness check, failing until ready is set.
+func readyHandler(ready *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(ready) == 0 {
+			http.Error(w, "Not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("Ready"))
+	})
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// accessLog logs the method, path, status, duration and remote address of
+// the requests served by h at debug level.
+func accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		log.Debugf("%s %s %d %s from %s", r.Method, r.URL.Path, rec.status, time.Since(start), r.RemoteAddr)
+	})
+}
+
+// bearerAuth serves h only to requests authorized with the given bearer
+// token.
+func bearerAuth(token string, h http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="aws_billing_exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// readBearerToken returns the bearer token given directly or read from a
+// file, or an empty string if neither is given.
+func readBearerToken(token, file string) (string, error) {
+	return readSecret("web.bearer-token", token, file)
+}
+
+// ipAllowlist is a list of networks allowed to access the web endpoints.
+type ipAllowlist []*net.IPNet
+
+// newIPAllowlist parses the given CIDRs. Single addresses are accepted as
+// networks of one address.
+func newIPAllowlist(cidrs []string) (ipAllowlist, error) {
+	var l ipAllowlist
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", c, err)
+		}
+		l = append(l, n)
+	}
+	return l, nil
+}
+
+// allows returns whether a request from the given remote address may be
+// served. Requests over Unix domain sockets have no remote IP address and
+// are allowed.
+func (l ipAllowlist) allows(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr == "" || remoteAddr == "@"
+	}
+	ip := net.ParseIP(host)
+	for _, n := range l {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap serves h only to requests from the allowed networks, or to all
+// requests if the allowlist is empty.
+func (l ipAllowlist) wrap(h http.Handler) http.Handler {
+	if len(l) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allows(r.RemoteAddr) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// unixScheme prefixes listen addresses of Unix domain sockets.
+const unixScheme = "unix://"
+
+// listen returns a listener on the given address, either host:port or
+// unix:///path/to/socket. A socket file left behind by a previous run is
+// removed first.
+func listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixScheme) {
+		return net.Listen("tcp", address)
+	}
+	path := strings.TrimPrefix(address, unixScheme)
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// listenAll returns listeners on all the given addresses, closing those
+// already opened if one fails.
+func listenAll(addresses []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, a := range addresses {
+		l, err := listen(a)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestListenAll(t *testing.T) {
+	listeners, err := listenAll([]string{"127.0.0.1:0", "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("got %d listeners, want 2", len(listeners))
+	}
+	taken := listeners[0].Addr().String()
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	l, err := net.Listen("tcp", taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// The first listener is closed again when the second address is taken.
+	if _, err := listenAll([]string{"127.0.0.1:0", taken}); err == nil {
+		t.Error("expected an error for an address in use")
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	l, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := serverConfig{readHeaderTimeout: 50 * time.Millisecond}.server(http.NotFoundHandler())
+	go s.Serve(l)
+
+	// A client that never sends its headers is disconnected.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(conn); err != nil {
+		t.Errorf("want the connection closed by the server, got %v", err)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws_billing_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "exporter.sock")
+
+	// A stale socket of a previous run is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listen("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://exporter/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := ioutil.ReadAll(resp.Body); string(b) != "ok" {
+		t.Errorf("got %q, want ok", b)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	var ready int32
+	h := readyHandler(&ready)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/-/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want 503 before startup completed, got %d", rec.Code)
+	}
+	atomic.StoreInt32(&ready, 1)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/-/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("want 200 once ready, got %d", rec.Code)
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	http.NotFoundHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+	if rec.status != http.StatusNotFound || w.Code != http.StatusNotFound {
+		t.Errorf("got recorded status %d and written status %d, want 404", rec.status, w.Code)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	h := bearerAuth("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	for _, tc := range []struct {
+		header string
+		code   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic c2VjcmV0", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%q: got status %d, want %d", tc.header, w.Code, tc.code)
+		}
+	}
+}
+
+func TestRoutesRequireBearerToken(t *testing.T) {
+	e, err := NewExporter([]*target{{accountID: "123456789012"}}, nil, exporterOptions{subsystems: []string{"server"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	e.routes(mux, webConfig{bearerToken: "secret"}, "/metrics", promhttp.HandlerOpts{}, nil, nil)
+	for _, path := range []string{"/metrics", "/probe", "/sd", "/ui", "/api/v1/dimensions", "/-/permcheck", "/debug/pprof/", "/debug/pprof/cmdline", "/"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d without a token, want %d", path, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestIPAllowlist(t *testing.T) {
+	if _, err := newIPAllowlist([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	l, err := newIPAllowlist([]string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:51234":    true,
+		"192.168.1.5:80":    true,
+		"192.168.1.6:80":    false,
+		"[fd00::1]:9614":    true,
+		"[2001:db8::1]:443": false,
+		"@":                 true,
+	} {
+		if got := l.allows(addr); got != want {
+			t.Errorf("%s: got %v, want %v", addr, got, want)
+		}
+	}
+
+	h := l.wrap(http.NotFoundHandler())
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.RemoteAddr = "172.16.0.1:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want 403", w.Code)
+	}
+}
+
+func TestReadBearerToken(t *testing.T) {
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("secret\n")
+	f.Close()
+
+	if token, err := readBearerToken("", f.Name()); err != nil || token != "secret" {
+		t.Errorf("got %q, %v, want the token of the file", token, err)
+	}
+	if _, err := readBearerToken("other", f.Name()); err == nil {
+		t.Error("expected an error for both a token and a file")
+	}
+	if token, err := readBearerToken("", ""); err != nil || token != "" {
+		t.Errorf("got %q, %v, want no token", token, err)
+	}
+}
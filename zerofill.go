@@ -0,0 +1,112 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// zeroFiller remembers the series of the groups breakdowns exported, so that
+// groups without cost in a later refresh are exported as 0 instead of
+// disappearing, which shows up as gaps in dashboards and fires absent()
+// alerts. With a TTL, groups missing for longer than it are dropped, so that
+// decommissioned accounts and services don't stay around forever.
+type zeroFiller struct {
+	mutex sync.Mutex
+	ttl   time.Duration
+	now   func() time.Time
+	// seen are the series exported so far by breakdown and account, by
+	// their identity.
+	seen map[string]map[string]seenSeries
+}
+
+// seenSeries is a series exported by a breakdown and when it last had a
+// value.
+type seenSeries struct {
+	metric   prometheus.Metric
+	lastSeen time.Time
+}
+
+func newZeroFiller(ttl time.Duration) *zeroFiller {
+	return &zeroFiller{ttl: ttl, now: time.Now, seen: map[string]map[string]seenSeries{}}
+}
+
+// run runs a breakdown, given by key, passing its metrics on to ch followed
+// by zeros for the series it exported before but not this time. If the
+// breakdown fails, its groups are unknown and nothing is filled.
+func (z *zeroFiller) run(ch chan<- prometheus.Metric, key string, f func(chan<- prometheus.Metric) error) error {
+	metrics := make(chan prometheus.Metric)
+	// done is buffered and metrics closed even if f panics, so that the
+	// goroutine passing on the metrics doesn't leak.
+	done := make(chan map[string]seenSeries, 1)
+	now := z.now()
+	go func() {
+		current := map[string]seenSeries{}
+		for m := range metrics {
+			ch <- m
+			if id, ok := seriesID(m); ok {
+				current[id] = seenSeries{metric: m, lastSeen: now}
+			}
+		}
+		done <- current
+	}()
+	err := func() error {
+		defer close(metrics)
+		return f(metrics)
+	}()
+	current := <-done
+	if err != nil {
+		return err
+	}
+
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	for id, s := range z.seen[key] {
+		if _, ok := current[id]; ok || z.ttl > 0 && now.Sub(s.lastSeen) > z.ttl {
+			continue
+		}
+		ch <- zeroMetric{s.metric}
+		current[id] = s
+	}
+	z.seen[key] = current
+	return nil
+}
+
+// seriesID returns the name and labels of the series of a gauge. ok is false
+// for other metrics, which aren't filled.
+func seriesID(m prometheus.Metric) (id string, ok bool) {
+	var out dto.Metric
+	if err := m.Write(&out); err != nil || out.Gauge == nil {
+		return "", false
+	}
+	return m.Desc().String() + "{" + labelsID(m) + "}", true
+}
+
+// zeroMetric is a gauge exported as 0.
+type zeroMetric struct {
+	prometheus.Metric
+}
+
+func (m zeroMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	zero := 0.0
+	out.Gauge = &dto.Gauge{Value: &zero}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 The ABCDevOps Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestZeroFill(t *testing.T) {
+	desc := prometheus.NewDesc("aws_billing_service_last_day", "Cost by service.", []string{"service"}, nil)
+	z := newZeroFiller(0)
+	refresh := func(services map[string]float64, err error) metricSlice {
+		return collectMetrics(t, func(ch chan<- prometheus.Metric) error {
+			got := z.run(ch, "preset:service/123456789012", func(ch chan<- prometheus.Metric) error {
+				for s, v := range services {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, s)
+				}
+				return err
+			})
+			if got != err {
+				return fmt.Errorf("want error %v, got %v", err, got)
+			}
+			return nil
+		})
+	}
+
+	refresh(map[string]float64{"EC2": 10, "S3": 2}, nil)
+	expected := `
+# HELP aws_billing_service_last_day Cost by service.
+# TYPE aws_billing_service_last_day gauge
+aws_billing_service_last_day{service="EC2"} 12
+aws_billing_service_last_day{service="S3"} 0
+`
+	if err := testutil.CollectAndCompare(refresh(map[string]float64{"EC2": 12}, nil), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP aws_billing_service_last_day Cost by service.
+# TYPE aws_billing_service_last_day gauge
+aws_billing_service_last_day{service="EC2"} 1
+`
+	if err := testutil.CollectAndCompare(refresh(map[string]float64{"EC2": 1}, errors.New("throttled")), strings.NewReader(expected)); err != nil {
+		t.Errorf("want nothing filled after a failure: %v", err)
+	}
+}
+
+func TestZeroFillTTL(t *testing.T) {
+	desc := prometheus.NewDesc("aws_billing_service_last_day", "Cost by service.", []string{"service"}, nil)
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	z := newZeroFiller(48 * time.Hour)
+	z.now = func() time.Time { return now }
+	refresh := func(services ...string) int {
+		return len(collectMetrics(t, func(ch chan<- prometheus.Metric) error {
+			return z.run(ch, "job:services/123456789012", func(ch chan<- prometheus.Metric) error {
+				for _, s := range services {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, s)
+				}
+				return nil
+			})
+		}))
+	}
+
+	refresh("EC2", "S3")
+	now = now.Add(24 * time.Hour)
+	if n := refresh("EC2"); n != 2 {
+		t.Errorf("want S3 filled within the TTL, got %d series", n)
+	}
+	now = now.Add(25 * time.Hour)
+	if n := refresh("EC2"); n != 1 {
+		t.Errorf("want S3 dropped after the TTL, got %d series", n)
+	}
+	if n := refresh("EC2"); n != 1 {
+		t.Errorf("want S3 forgotten after the TTL, got %d series", n)
+	}
+}